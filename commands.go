@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/helios"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	ClientSecretLength = 32
+	PingTimeout        = 5 //in seconds
+)
+
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := flags.String("config", ConfigPath, "path to the config file")
+	return flags, configPath
+}
+
+//Reads the config and initializes the logger, which is required by the storages
+func loadConfig(configPath string) (*config.Config, error) {
+	conf, err := config.ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.InitLogger(helios.AppName, logger.LogLevelInfo)
+	return conf, nil
+}
+
+func newRedisStorage(conf *config.Config) *storage.RedisStorage {
+	return storage.NewRedisStorage(&conf.RedisGeneral, &conf.RedisMaster, &conf.RedisSlave, &conf.Server)
+}
+
+func runServe(args []string) error {
+	flags, configPath := newFlagSet("serve")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	helios.NewHelios().Run(*configPath)
+	return nil
+}
+
+func runCheckConfig(args []string) error {
+	flags, configPath := newFlagSet("check-config")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := config.ReadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("#Config %s is valid, effective settings:\n%s", *configPath, conf.String())
+	return nil
+}
+
+func runCreateClient(args []string) error {
+	flags, configPath := newFlagSet("create-client")
+	id := flags.String("id", "", "client id")
+	isSecretOnStdin := flags.Bool("secret-stdin", false, "read the client secret from stdin instead of generating it")
+	redirectUri := flags.String("redirect-uri", "", "client redirect uri")
+	tokenReusePolicy := flags.String("token-reuse-policy", "", "reuse, new or cap, the server default is used if not given")
+	maxSessions := flags.Int("max-sessions", 0, "max number of access tokens per user for the cap policy")
+	tokenExchangeAudiences := flags.String("token-exchange-audiences", "",
+		"comma separated audiences the client may exchange tokens for, token exchange is denied if not given")
+	tokenExchangeImpersonation := flags.Bool("token-exchange-impersonation", false,
+		"allow exchanging tokens without an actor_token")
+	jwtPublicKeyFile := flags.String("jwt-public-key-file", "",
+		"PEM file with the public keys the JWT bearer assertions of the client are verified with")
+	jwksUri := flags.String("jwks-uri", "", "URL of the JWK set the JWT bearer assertions of the client are verified with")
+	jwtIssuer := flags.String("jwt-issuer", "", "iss of the JWT bearer assertions of the client, the client id if not given")
+	tokenEndpointAuthMethod := flags.String("token-endpoint-auth-method", "",
+		"how the client authenticates: client_secret_basic, client_secret_post, private_key_jwt (with the JWT keys), "+
+			"client_secret_jwt or, set by the TLS flags, tls_client_auth and self_signed_tls_client_auth; any secret method if not given")
+	tlsSubjectDn := flags.String("tls-client-auth-subject-dn", "",
+		"subject DN of the certificate the client authenticates with (tls_client_auth) instead of the secret")
+	tlsThumbprint := flags.String("tls-client-certificate-thumbprint", "",
+		"SHA-256 thumbprint (x5t#S256) of the certificate the client authenticates with (self_signed_tls_client_auth)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" || *redirectUri == "" {
+		return errors.New("-id and -redirect-uri are required")
+	}
+	if *tlsSubjectDn != "" && *tlsThumbprint != "" {
+		return errors.New("only one of -tls-client-auth-subject-dn and -tls-client-certificate-thumbprint can be given")
+	}
+	if (*tlsSubjectDn != "" || *tlsThumbprint != "") && *tokenEndpointAuthMethod != "" {
+		return errors.New("-token-endpoint-auth-method can't be given with the TLS flags, they set it")
+	}
+	switch *tokenEndpointAuthMethod {
+	case "", helios.TokenEndpointAuthMethodClientSecretBasic, helios.TokenEndpointAuthMethodClientSecretPost,
+		helios.TokenEndpointAuthMethodClientSecretJwt:
+	case helios.TokenEndpointAuthMethodPrivateKeyJwt:
+		if *jwtPublicKeyFile == "" && *jwksUri == "" {
+			return errors.New("-jwt-public-key-file or -jwks-uri is required for private_key_jwt")
+		}
+	default:
+		return errors.New("-token-endpoint-auth-method is not supported: " + *tokenEndpointAuthMethod)
+	}
+	if *tokenReusePolicy != "" && !config.IsValidTokenReusePolicy(*tokenReusePolicy, *maxSessions) {
+		return errors.New("-token-reuse-policy must be one of reuse, new or cap (with -max-sessions greater than 0)")
+	}
+
+	jwtPublicKeys := ""
+	if *jwtPublicKeyFile != "" {
+		pemData, err := ioutil.ReadFile(*jwtPublicKeyFile)
+		if err != nil {
+			return err
+		}
+		if _, err = jwt.ParsePublicKeysPEM(pemData); err != nil {
+			return err
+		}
+		jwtPublicKeys = string(pemData)
+	}
+
+	//The secret isn't taken from the command line, where it would show up in the process list and the shell history
+	secret := ""
+	if *isSecretOnStdin {
+		var err error
+		if secret, err = readSecret("Client secret: "); err != nil {
+			return err
+		}
+		if secret == "" {
+			return errors.New("the client secret must be given on stdin with -secret-stdin")
+		}
+	} else {
+		secretBytes := make([]byte, ClientSecretLength/2)
+		if _, err := rand.Read(secretBytes); err != nil {
+			return err
+		}
+		secret = hex.EncodeToString(secretBytes)
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	redisStorage := newRedisStorage(conf)
+	defer redisStorage.DoClose()
+
+	client := &storage.Client{Id: *id, Secret: secret, RedirectUri: *redirectUri,
+		TokenReusePolicy: *tokenReusePolicy, MaxSessions: *maxSessions,
+		TokenExchangeImpersonation: *tokenExchangeImpersonation,
+		JwtPublicKeys:              jwtPublicKeys, JwksUri: *jwksUri, JwtIssuer: *jwtIssuer,
+		TlsClientAuthSubjectDn: *tlsSubjectDn, TlsClientCertificateThumbprint: *tlsThumbprint,
+		TokenEndpointAuthMethod: *tokenEndpointAuthMethod}
+	if *tlsSubjectDn != "" {
+		client.TokenEndpointAuthMethod = helios.TokenEndpointAuthMethodTlsClientAuth
+	} else if *tlsThumbprint != "" {
+		client.TokenEndpointAuthMethod = helios.TokenEndpointAuthMethodSelfSignedTlsClientAuth
+	}
+	if *tokenExchangeAudiences != "" {
+		client.TokenExchangeAudiences = strings.Split(*tokenExchangeAudiences, ",")
+	}
+	if err = redisStorage.SetClient(*id, client); err != nil {
+		return err
+	}
+
+	fmt.Printf("client_id = %s\nclient_secret = %s\nredirect_uri = %s\n", client.Id, client.Secret, client.RedirectUri)
+	return nil
+}
+
+func runListClients(args []string) error {
+	flags, configPath := newFlagSet("list-clients")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	redisStorage := newRedisStorage(conf)
+	defer redisStorage.DoClose()
+
+	clients, err := redisStorage.ListClients()
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		policy, maxSessions := redisStorage.GetTokenReusePolicy(client)
+		if policy == config.TokenReusePolicyCap {
+			policy = fmt.Sprintf("%s:%d", policy, maxSessions)
+		}
+		fmt.Printf("%s\t%s\t%s\n", client.GetId(), client.GetRedirectUri(), policy)
+	}
+	return nil
+}
+
+func runHashPassword(args []string) error {
+	flags := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := readSecret("Password: ")
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return errors.New("the password to hash must be given on stdin")
+	}
+
+	hash, err := models.NewPasswordHash(password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hash)
+	return nil
+}
+
+//Reads a secret from the first line of stdin, so it doesn't show up in the process list or the shell history.
+//When stdin is a terminal the secret is asked for with the prompt and not echoed while typed.
+func readSecret(prompt string) (string, error) {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	//stty fails for the character devices which aren't terminals, like /dev/null
+	if stat.Mode()&os.ModeCharDevice != 0 && setTerminalEcho(false) == nil {
+		defer func() {
+			setTerminalEcho(true)
+			fmt.Fprintln(os.Stderr)
+		}()
+		fmt.Fprint(os.Stderr, prompt)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func setTerminalEcho(isEnabled bool) error {
+	mode := "-echo"
+	if isEnabled {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func runRevokeUser(args []string) error {
+	flags, configPath := newFlagSet("revoke-user")
+	userId := flags.String("user-id", "", "id of the user whose tokens should be removed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userId == "" {
+		return errors.New("-user-id is required")
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	redisStorage := newRedisStorage(conf)
+	defer redisStorage.DoClose()
+
+	revoked, err := redisStorage.RevokeUserTokens(*userId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked %d token(s) of user %s\n", revoked, *userId)
+	return nil
+}
+
+func runPing(args []string) error {
+	flags, configPath := newFlagSet("ping")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	redisStorage := newRedisStorage(conf)
+	defer redisStorage.DoClose()
+	storageFactory := models.NewStorageFactory(&conf.Db)
+	defer storageFactory.Close()
+	storagePinger := storageFactory.GetStoragePinger()
+
+	dependencies := []struct {
+		name string
+		ping func() error
+	}{
+		{"Redis Master", redisStorage.PingMaster},
+		{"Redis Slave", redisStorage.PingSlave},
+		{"MySQL Master", storagePinger.PingMaster},
+		{"MySQL Slave", storagePinger.PingSlave},
+	}
+
+	allOk := true
+	for _, dependency := range dependencies {
+		status := "OK"
+		if err := pingWithTimeout(dependency.ping); err != nil {
+			if _, isDisabled := err.(*storage.StorageDisabledError); isDisabled {
+				status = "Disabled"
+			} else {
+				status = "Down (" + err.Error() + ")"
+				allOk = false
+			}
+		}
+		fmt.Printf("%-14s %s\n", dependency.name+":", status)
+	}
+
+	if !allOk {
+		return errors.New("some of the dependencies are down")
+	}
+	return nil
+}
+
+func pingWithTimeout(ping func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- ping()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(PingTimeout * time.Second):
+		return errors.New("timeout")
+	}
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"code.google.com/p/gcfg"
@@ -12,18 +16,32 @@ type ServerConfig struct {
 	Address                     string `gcfg:"address"`
 	AccessTokenExpirationInSec  int    `gcfg:"access-token-expiration-in-sec"`
 	RefreshTokenExpirationInSec int    `gcfg:"refresh-token-expiration-in-sec"`
-	AllowMultipleAccessTokens   bool   `gcfg:"allow-multiple-access-tokens"`
+	DefaultTokenReusePolicy     string `gcfg:"default-token-reuse-policy"`
+	DefaultMaxSessions          int    `gcfg:"default-max-sessions"`
+	RotateRefreshTokens         bool   `gcfg:"rotate-refresh-tokens"`
 	ForceReadOnly               bool   `gcfg:"force-read-only"`
+	ShutdownDrainPeriodInSec    int    `gcfg:"shutdown-drain-period-in-sec"`
+	ShutdownTimeoutInSec        int    `gcfg:"shutdown-timeout-in-sec"`
+	ClientIpHeader              string `gcfg:"client-ip-header"`
+	Issuer                      string `gcfg:"issuer"`
+	TlsCertFile                 string `gcfg:"tls-cert-file"`
+	TlsKeyFile                  string `gcfg:"tls-key-file"`
+	TlsClientCaFile             string `gcfg:"tls-client-ca-file"`
+	//Deprecated: replaced by default-token-reuse-policy, true means new and false reuse
+	AllowMultipleAccessTokens bool `gcfg:"allow-multiple-access-tokens"`
 }
 
 type DbConfig struct {
-	ConnectionStringMaster string `gcfg:"connection-string-master"`
-	ConnectionStringSlave  string `gcfg:"connection-string-slave"`
-	Type                   string `gcfg:"type"`
-	Engine                 string `gcfg:"engine"`
-	Encoding               string `gcfg:"encoding"`
-	UserTable              string `gcfg:"user-table"`
-	UserTableKey           string `gcfg:"user-table-key"`
+	ConnectionStringMaster     string `gcfg:"connection-string-master" secret:"true"`
+	ConnectionStringMasterFile string `gcfg:"connection-string-master-file"`
+	ConnectionStringSlave      string `gcfg:"connection-string-slave" secret:"true"`
+	ConnectionStringSlaveFile  string `gcfg:"connection-string-slave-file"`
+	Type                       string `gcfg:"type"`
+	Engine                     string `gcfg:"engine"`
+	Encoding                   string `gcfg:"encoding"`
+	UserTable                  string `gcfg:"user-table"`
+	UserTableKey               string `gcfg:"user-table-key"`
+	UserStatusCacheTTLInSec    int    `gcfg:"user-status-cache-ttl-in-sec"`
 }
 
 type RedisGeneralConfig struct {
@@ -33,28 +51,399 @@ type RedisGeneralConfig struct {
 type RedisInstanceConfig struct {
 	UseThisInstance bool          `gcfg:"use-this-instance"`
 	Address         string        `gcfg:"address"`
-	Password        string        `gcfg:"password"`
+	Password        string        `gcfg:"password" secret:"true"`
+	PasswordFile    string        `gcfg:"password-file"`
 	MaxIdleConn     int           `gcfg:"max-idle-connections"`
 	IdleTimeoutSec  time.Duration `gcfg:"idle-timeout-in-seconds"`
 }
 
+type AdminConfig struct {
+	Token     string `gcfg:"token" secret:"true"`
+	TokenFile string `gcfg:"token-file"`
+}
+
+type MailConfig struct {
+	Type             string `gcfg:"type"`
+	From             string `gcfg:"from"`
+	SmtpAddress      string `gcfg:"smtp-address"`
+	SmtpUsername     string `gcfg:"smtp-username"`
+	SmtpPassword     string `gcfg:"smtp-password" secret:"true"`
+	SmtpPasswordFile string `gcfg:"smtp-password-file"`
+	FilePath         string `gcfg:"file-path"`
+}
+
+type PasswordResetConfig struct {
+	Url                  string `gcfg:"url"`
+	TokenExpirationInSec int    `gcfg:"token-expiration-in-sec"`
+}
+
+type EmailConfirmationConfig struct {
+	Url                  string `gcfg:"url"`
+	TokenExpirationInSec int    `gcfg:"token-expiration-in-sec"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength             int    `gcfg:"min-length"`
+	MinCharacterClasses   int    `gcfg:"min-character-classes"`
+	BreachedPasswordsFile string `gcfg:"breached-passwords-file"`
+}
+
+type MfaConfig struct {
+	EncryptionKey           string `gcfg:"encryption-key" secret:"true"`
+	EncryptionKeyFile       string `gcfg:"encryption-key-file"`
+	Issuer                  string `gcfg:"issuer"`
+	MfaTokenExpirationInSec int    `gcfg:"mfa-token-expiration-in-sec"`
+	MaxMfaTokenAttempts     int    `gcfg:"max-mfa-token-attempts"`
+	RecoveryCodeCount       int    `gcfg:"recovery-code-count"`
+}
+
+type DeviceAuthorizationConfig struct {
+	VerificationUri           string `gcfg:"verification-uri"`
+	DeviceCodeExpirationInSec int    `gcfg:"device-code-expiration-in-sec"`
+	PollingIntervalInSec      int    `gcfg:"polling-interval-in-sec"`
+	MaxUserCodeAttempts       int    `gcfg:"max-user-code-attempts"`
+}
+
+type JwtAssertionConfig struct {
+	MaxLifetimeInSec  int `gcfg:"max-lifetime-in-sec"`
+	ClockSkewInSec    int `gcfg:"clock-skew-in-sec"`
+	JwksCacheTTLInSec int `gcfg:"jwks-cache-ttl-in-sec"`
+	JwksTimeoutInSec  int `gcfg:"jwks-timeout-in-sec"`
+}
+
+type ClientRegistrationConfig struct {
+	Enabled                  bool   `gcfg:"enabled"`
+	InitialAccessToken       string `gcfg:"initial-access-token" secret:"true"`
+	InitialAccessTokenFile   string `gcfg:"initial-access-token-file"`
+	AllowedGrantTypes        string `gcfg:"allowed-grant-types"`
+	RequireHttpsRedirectUris bool   `gcfg:"require-https-redirect-uris"`
+}
+
+type DpopConfig struct {
+	Enabled             bool `gcfg:"enabled"`
+	ProofMaxAgeInSec    int  `gcfg:"proof-max-age-in-sec"`
+	ProofClockSkewInSec int  `gcfg:"proof-clock-skew-in-sec"`
+}
+
 type Config struct {
-	Server       ServerConfig        `gcfg:"server"`
-	Db           DbConfig            `gcfg:"db"`
-	RedisGeneral RedisGeneralConfig  `gcfg:"redis-general"`
-	RedisMaster  RedisInstanceConfig `gcfg:"redis-master"`
-	RedisSlave   RedisInstanceConfig `gcfg:"redis-slave"`
+	Server              ServerConfig              `gcfg:"server"`
+	Db                  DbConfig                  `gcfg:"db"`
+	RedisGeneral        RedisGeneralConfig        `gcfg:"redis-general"`
+	RedisMaster         RedisInstanceConfig       `gcfg:"redis-master"`
+	RedisSlave          RedisInstanceConfig       `gcfg:"redis-slave"`
+	Admin               AdminConfig               `gcfg:"admin"`
+	Mail                MailConfig                `gcfg:"mail"`
+	PasswordReset       PasswordResetConfig       `gcfg:"password-reset"`
+	EmailConfirmation   EmailConfirmationConfig   `gcfg:"email-confirmation"`
+	PasswordPolicy      PasswordPolicyConfig      `gcfg:"password-policy"`
+	Mfa                 MfaConfig                 `gcfg:"mfa"`
+	DeviceAuthorization DeviceAuthorizationConfig `gcfg:"device-authorization"`
+	JwtAssertion        JwtAssertionConfig        `gcfg:"jwt-assertion"`
+	ClientRegistration  ClientRegistrationConfig  `gcfg:"client-registration"`
+	Dpop                DpopConfig                `gcfg:"dpop"`
+}
+
+//Settings which can be changed on a running instance. All other settings require a restart.
+var reloadableSettings = map[string]bool{
+	"server.access-token-expiration-in-sec":  true,
+	"server.refresh-token-expiration-in-sec": true,
+	"server.default-token-reuse-policy":      true,
+	"server.default-max-sessions":            true,
+	"server.allow-multiple-access-tokens":    true,
+	"server.rotate-refresh-tokens":           true,
+	"server.force-read-only":                 true,
+	"server.shutdown-drain-period-in-sec":    true,
+	"server.shutdown-timeout-in-sec":         true,
+	"redis-master.max-idle-connections":      true,
+	"redis-master.idle-timeout-in-seconds":   true,
+	"redis-slave.max-idle-connections":       true,
+	"redis-slave.idle-timeout-in-seconds":    true,
+}
+
+const (
+	SecretMask = "*****"
+	//Used when shutdown-timeout-in-sec isn't set, the in-flight requests would be cut off at once otherwise
+	DefaultShutdownTimeoutInSec = 15
+	//Used when max-user-code-attempts isn't set, the user codes of others could be guessed otherwise
+	DefaultMaxUserCodeAttempts = 5
+)
+
+const (
+	//Emails are sent through an SMTP server
+	MailTypeSmtp = "smtp"
+	//Emails are appended to a file, for development and testing
+	MailTypeFile = "file"
+)
+
+const (
+	//The last valid access token of the user is handed out again
+	TokenReusePolicyReuse = "reuse"
+	//Every login gets a new access token
+	TokenReusePolicyNew = "new"
+	//Every login gets a new access token, the oldest ones are revoked above the max sessions
+	TokenReusePolicyCap = "cap"
+)
+
+//The grant types in which the client gets tokens of users who didn't log in with it, only the clients created
+//by an admin may use them
+var nonRegistrableGrantTypes = []string{
+	"assertion",
+	"urn:ietf:params:oauth:grant-type:jwt-bearer",
+	"urn:ietf:params:oauth:grant-type:token-exchange",
+}
+
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid config:\n  " + strings.Join(e.Errors, "\n  ")
 }
 
 var config *Config
 
 func LoadConfig(path string) *Config {
+	config, err := ReadConfig(path)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+//Reads the config file, applies the HELIOS_SECTION_KEY environment overrides, reads the secret files
+//and validates the result. It doesn't panic, so it can be used to reload the config of a running instance.
+func ReadConfig(path string) (*Config, error) {
 	var config Config
 	err := gcfg.ReadFileInto(&config, path)
-
 	if err != nil {
-		msg := fmt.Sprintf("Error on config file load: %v\n", err)
-		panic(errors.New(msg))
+		return nil, errors.New(fmt.Sprintf("Error on config file load: %v\n", err))
+	}
+
+	errs := config.applyEnvOverrides()
+	errs = append(errs, config.readSecretFiles()...)
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	config.applyDefaults()
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+//Fills in the settings which are optional in the config file
+func (config *Config) applyDefaults() {
+	//The config files from before the per-client policies only have allow-multiple-access-tokens
+	if config.Server.DefaultTokenReusePolicy == "" {
+		if config.Server.AllowMultipleAccessTokens {
+			config.Server.DefaultTokenReusePolicy = TokenReusePolicyNew
+		} else {
+			config.Server.DefaultTokenReusePolicy = TokenReusePolicyReuse
+		}
+	}
+	if config.Server.ShutdownTimeoutInSec == 0 {
+		config.Server.ShutdownTimeoutInSec = DefaultShutdownTimeoutInSec
+	}
+	if config.DeviceAuthorization.MaxUserCodeAttempts == 0 {
+		config.DeviceAuthorization.MaxUserCodeAttempts = DefaultMaxUserCodeAttempts
+	}
+}
+
+//Checks all the settings and returns a ValidationError listing every problem found
+func (config *Config) Validate() error {
+	var errs []string
+	check := func(isValid bool, msg string) {
+		if !isValid {
+			errs = append(errs, msg)
+		}
+	}
+
+	check(config.Server.Address != "", "server.address must be set")
+	check(config.Server.AccessTokenExpirationInSec > 0, "server.access-token-expiration-in-sec must be greater than 0")
+	check(config.Server.RefreshTokenExpirationInSec > 0, "server.refresh-token-expiration-in-sec must be greater than 0")
+	check(config.Server.RefreshTokenExpirationInSec >= config.Server.AccessTokenExpirationInSec,
+		"server.refresh-token-expiration-in-sec must not be lower than server.access-token-expiration-in-sec")
+	check(IsValidTokenReusePolicy(config.Server.DefaultTokenReusePolicy, config.Server.DefaultMaxSessions),
+		"server.default-token-reuse-policy must be one of reuse, new or cap (with server.default-max-sessions greater than 0)")
+	check(config.Server.ShutdownDrainPeriodInSec >= 0, "server.shutdown-drain-period-in-sec must not be negative")
+	check(config.Server.ShutdownTimeoutInSec > 0, "server.shutdown-timeout-in-sec must be greater than 0")
+
+	check(config.Db.ConnectionStringMaster != "", "db.connection-string-master must be set")
+	check(config.Db.ConnectionStringSlave != "", "db.connection-string-slave must be set")
+	check(config.Db.Type != "", "db.type must be set")
+	check(config.Db.UserTable != "", "db.user-table must be set")
+	check(config.Db.UserTableKey != "", "db.user-table-key must be set")
+	check(config.Db.UserStatusCacheTTLInSec >= 0, "db.user-status-cache-ttl-in-sec must not be negative")
+
+	check(config.RedisMaster.UseThisInstance || config.RedisSlave.UseThisInstance,
+		"at least one of redis-master and redis-slave must have use-this-instance enabled")
+	for _, redis := range []struct {
+		section string
+		config  *RedisInstanceConfig
+	}{{"redis-master", &config.RedisMaster}, {"redis-slave", &config.RedisSlave}} {
+		if !redis.config.UseThisInstance {
+			continue
+		}
+		check(redis.config.Address != "", redis.section+".address must be set")
+		check(redis.config.MaxIdleConn >= 0, redis.section+".max-idle-connections must not be negative")
+		check(redis.config.IdleTimeoutSec >= 0, redis.section+".idle-timeout-in-seconds must not be negative")
+	}
+
+	switch config.Mail.Type {
+	case "":
+	case MailTypeSmtp:
+		check(config.Mail.SmtpAddress != "", "mail.smtp-address must be set when mail.type is smtp")
+	case MailTypeFile:
+		check(config.Mail.FilePath != "", "mail.file-path must be set when mail.type is file")
+	default:
+		errs = append(errs, "mail.type must be empty, smtp or file")
+	}
+	if config.Mail.Type != "" {
+		check(config.Mail.From != "", "mail.from must be set when mail.type is set")
+		check(config.PasswordReset.Url != "", "password-reset.url must be set when mail.type is set")
+		check(config.PasswordReset.TokenExpirationInSec > 0,
+			"password-reset.token-expiration-in-sec must be greater than 0 when mail.type is set")
+	}
+	if config.EmailConfirmation.Url != "" {
+		check(config.EmailConfirmation.TokenExpirationInSec > 0,
+			"email-confirmation.token-expiration-in-sec must be greater than 0 when email-confirmation.url is set")
+	}
+
+	check(config.PasswordPolicy.MinLength >= 0, "password-policy.min-length must not be negative")
+	check(config.PasswordPolicy.MinCharacterClasses >= 0 && config.PasswordPolicy.MinCharacterClasses <= 4,
+		"password-policy.min-character-classes must be between 0 and 4")
+
+	check((config.Server.TlsCertFile == "") == (config.Server.TlsKeyFile == ""),
+		"server.tls-cert-file and server.tls-key-file must be set together")
+	check(config.Server.TlsClientCaFile == "" || config.Server.TlsCertFile != "",
+		"server.tls-cert-file must be set when server.tls-client-ca-file is set")
+
+	if config.Mfa.EncryptionKey != "" {
+		key, err := hex.DecodeString(config.Mfa.EncryptionKey)
+		check(err == nil && len(key) == 32, "mfa.encryption-key must be 32 bytes encoded in hex")
+		check(config.Mfa.Issuer != "", "mfa.issuer must be set when mfa.encryption-key is set")
+		check(config.Mfa.MfaTokenExpirationInSec > 0,
+			"mfa.mfa-token-expiration-in-sec must be greater than 0 when mfa.encryption-key is set")
+		check(config.Mfa.MaxMfaTokenAttempts > 0,
+			"mfa.max-mfa-token-attempts must be greater than 0 when mfa.encryption-key is set")
+		check(config.Mfa.RecoveryCodeCount > 0,
+			"mfa.recovery-code-count must be greater than 0 when mfa.encryption-key is set")
+	}
+
+	if config.DeviceAuthorization.VerificationUri != "" {
+		check(config.DeviceAuthorization.DeviceCodeExpirationInSec > 0,
+			"device-authorization.device-code-expiration-in-sec must be greater than 0 when device-authorization.verification-uri is set")
+		check(config.DeviceAuthorization.PollingIntervalInSec > 0,
+			"device-authorization.polling-interval-in-sec must be greater than 0 when device-authorization.verification-uri is set")
+		check(config.DeviceAuthorization.MaxUserCodeAttempts > 0,
+			"device-authorization.max-user-code-attempts must be greater than 0 when device-authorization.verification-uri is set")
+	}
+
+	if config.Server.Issuer != "" {
+		check(config.JwtAssertion.MaxLifetimeInSec > 0,
+			"jwt-assertion.max-lifetime-in-sec must be greater than 0 when server.issuer is set")
+		check(config.JwtAssertion.ClockSkewInSec >= 0, "jwt-assertion.clock-skew-in-sec must not be negative")
+		check(config.JwtAssertion.JwksCacheTTLInSec >= 0, "jwt-assertion.jwks-cache-ttl-in-sec must not be negative")
+		check(config.JwtAssertion.JwksTimeoutInSec > 0,
+			"jwt-assertion.jwks-timeout-in-sec must be greater than 0 when server.issuer is set")
+	}
+
+	if config.ClientRegistration.Enabled {
+		check(len(strings.Fields(config.ClientRegistration.AllowedGrantTypes)) > 0,
+			"client-registration.allowed-grant-types must be set when client-registration.enabled is true")
+		for _, grantType := range strings.Fields(config.ClientRegistration.AllowedGrantTypes) {
+			check(IsRegistrableGrantType(grantType),
+				"client-registration.allowed-grant-types can't include "+grantType)
+		}
+	}
+
+	if config.Dpop.Enabled {
+		check(config.Dpop.ProofMaxAgeInSec > 0, "dpop.proof-max-age-in-sec must be greater than 0 when dpop.enabled is true")
+		check(config.Dpop.ProofClockSkewInSec >= 0, "dpop.proof-clock-skew-in-sec must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func IsValidTokenReusePolicy(policy string, maxSessions int) bool {
+	switch policy {
+	case TokenReusePolicyReuse, TokenReusePolicyNew:
+		return true
+	case TokenReusePolicyCap:
+		return maxSessions > 0
+	}
+	return false
+}
+
+//Tells if the clients registering themselves may be given the grant type
+func IsRegistrableGrantType(grantType string) bool {
+	for _, nonRegistrable := range nonRegistrableGrantTypes {
+		if grantType == nonRegistrable {
+			return false
+		}
+	}
+	return true
+}
+
+//Formats the config as an ini file with the values of the secret settings masked
+func (config *Config) String() string {
+	var buf bytes.Buffer
+
+	sections := reflect.ValueOf(config).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "[%s]\n", sections.Type().Field(i).Tag.Get("gcfg"))
+		section := sections.Field(i)
+
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			value := section.Field(j)
+			var formatted string
+			switch {
+			case value.Kind() == reflect.String && field.Tag.Get("secret") == "true" && value.String() != "":
+				formatted = quote(SecretMask)
+			case value.Kind() == reflect.String:
+				formatted = quote(value.String())
+			case value.Kind() == reflect.Int64:
+				//time.Duration settings are given as plain numbers
+				formatted = fmt.Sprintf("%d", value.Int())
+			default:
+				formatted = fmt.Sprintf("%v", value.Interface())
+			}
+			fmt.Fprintf(&buf, "%s = %s\n", field.Tag.Get("gcfg"), formatted)
+		}
+	}
+	return buf.String()
+}
+
+//Returns the names (section.key) of the settings which differ between the two configs,
+//split into the ones which can be applied on a running instance and the ones which need a restart
+func Diff(oldConfig *Config, newConfig *Config) (reloadable []string, restartRequired []string) {
+	oldSections := reflect.ValueOf(oldConfig).Elem()
+	newSections := reflect.ValueOf(newConfig).Elem()
+
+	for i := 0; i < oldSections.NumField(); i++ {
+		sectionName := oldSections.Type().Field(i).Tag.Get("gcfg")
+		oldSection := oldSections.Field(i)
+		newSection := newSections.Field(i)
+
+		for j := 0; j < oldSection.NumField(); j++ {
+			if reflect.DeepEqual(oldSection.Field(j).Interface(), newSection.Field(j).Interface()) {
+				continue
+			}
+			name := sectionName + "." + oldSection.Type().Field(j).Tag.Get("gcfg")
+			if reloadableSettings[name] {
+				reloadable = append(reloadable, name)
+			} else {
+				restartRequired = append(restartRequired, name)
+			}
+		}
 	}
-	return &config
+	return reloadable, restartRequired
 }
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gcfg"
+)
+
+func TestDiff(t *testing.T) {
+	oldConfig := Config{}
+	oldConfig.Server.AccessTokenExpirationInSec = 3600
+	oldConfig.RedisMaster.Address = "localhost:6379"
+
+	newConfig := oldConfig
+	newConfig.Server.AccessTokenExpirationInSec = 60
+	newConfig.RedisMaster.Address = "localhost:6380"
+	newConfig.RedisMaster.MaxIdleConn = 5
+
+	reloadable, restartRequired := Diff(&oldConfig, &newConfig)
+
+	expectedReloadable := []string{"server.access-token-expiration-in-sec", "redis-master.max-idle-connections"}
+	if !reflect.DeepEqual(reloadable, expectedReloadable) {
+		t.Fatal("Wrong reloadable settings. Expected:", expectedReloadable, "Actual:", reloadable)
+	}
+	expectedRestartRequired := []string{"redis-master.address"}
+	if !reflect.DeepEqual(restartRequired, expectedRestartRequired) {
+		t.Fatal("Wrong restart required settings. Expected:", expectedRestartRequired, "Actual:", restartRequired)
+	}
+}
+
+func TestDiffNoChanges(t *testing.T) {
+	config := Config{}
+	reloadable, restartRequired := Diff(&config, &config)
+	if len(reloadable) != 0 || len(restartRequired) != 0 {
+		t.Fatal("No changes expected. Actual:", reloadable, restartRequired)
+	}
+}
+
+const (
+	TestConfig = `
+[server]
+address = ":8080"
+access-token-expiration-in-sec = 3600
+refresh-token-expiration-in-sec = 15552000
+default-token-reuse-policy = "reuse"
+
+[db]
+connection-string-master = "user:pass@tcp(master:3306)/wikicities"
+connection-string-slave = "user:pass@tcp(slave:3306)/wikicities"
+type = "mysql"
+user-table = "user"
+user-table-key = "Id"
+
+[redis-master]
+use-this-instance = true
+address = "localhost:6379"
+`
+)
+
+func writeTempFile(content string, t *testing.T) string {
+	file, err := ioutil.TempFile("", "helios")
+	if err != nil {
+		t.Fatal("Error creating temp file", err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatal("Error writing temp file", err)
+	}
+	return file.Name()
+}
+
+func TestReadConfig(t *testing.T) {
+	path := writeTempFile(TestConfig, t)
+	defer os.Remove(path)
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal("Valid config rejected", err)
+	}
+	if config.Server.AccessTokenExpirationInSec != 3600 {
+		t.Fatal("Wrong access token expiration. Expected: 3600 Actual:", config.Server.AccessTokenExpirationInSec)
+	}
+	if config.Server.ShutdownTimeoutInSec != DefaultShutdownTimeoutInSec {
+		t.Fatal("Default shutdown timeout expected. Actual:", config.Server.ShutdownTimeoutInSec)
+	}
+	if config.DeviceAuthorization.MaxUserCodeAttempts != DefaultMaxUserCodeAttempts {
+		t.Fatal("Default max user code attempts expected. Actual:", config.DeviceAuthorization.MaxUserCodeAttempts)
+	}
+}
+
+func TestDefaultTokenReusePolicy(t *testing.T) {
+	tests := []struct {
+		settings string
+		expected string
+	}{
+		{"", TokenReusePolicyReuse},
+		{"allow-multiple-access-tokens = false\n", TokenReusePolicyReuse},
+		{"allow-multiple-access-tokens = true\n", TokenReusePolicyNew},
+		{"allow-multiple-access-tokens = true\ndefault-token-reuse-policy = \"reuse\"\n", TokenReusePolicyReuse},
+	}
+
+	for _, test := range tests {
+		content := strings.Replace(TestConfig, "default-token-reuse-policy = \"reuse\"\n", test.settings, 1)
+		path := writeTempFile(content, t)
+		config, err := ReadConfig(path)
+		os.Remove(path)
+		if err != nil {
+			t.Fatalf("Config with %q rejected: %s", test.settings, err)
+		}
+		if config.Server.DefaultTokenReusePolicy != test.expected {
+			t.Errorf("Wrong policy for %q. Expected: %s Actual: %s", test.settings, test.expected,
+				config.Server.DefaultTokenReusePolicy)
+		}
+	}
+}
+
+func TestValidateAggregatesErrors(t *testing.T) {
+	config := Config{}
+	config.Server.Address = ":8080"
+	config.Server.RefreshTokenExpirationInSec = 60
+	config.Server.DefaultTokenReusePolicy = TokenReusePolicyCap
+	config.Server.DefaultMaxSessions = 2
+	config.Server.ShutdownTimeoutInSec = DefaultShutdownTimeoutInSec
+	config.Db.ConnectionStringMaster = "master"
+	config.Db.ConnectionStringSlave = "slave"
+	config.Db.Type = "mysql"
+	config.Db.UserTable = "user"
+	config.Db.UserTableKey = "Id"
+
+	err := config.Validate()
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatal("ValidationError expected. Actual:", err)
+	}
+
+	expected := []string{
+		"server.access-token-expiration-in-sec must be greater than 0",
+		"at least one of redis-master and redis-slave must have use-this-instance enabled",
+	}
+	if !reflect.DeepEqual(validationErr.Errors, expected) {
+		t.Fatal("Wrong validation errors. Expected:", expected, "Actual:", validationErr.Errors)
+	}
+}
+
+func TestIsRegistrableGrantType(t *testing.T) {
+	tests := []struct {
+		grantType string
+		expected  bool
+	}{
+		{"password", true},
+		{"refresh_token", true},
+		{"urn:ietf:params:oauth:grant-type:device_code", true},
+		{"assertion", false},
+		{"urn:ietf:params:oauth:grant-type:jwt-bearer", false},
+		{"urn:ietf:params:oauth:grant-type:token-exchange", false},
+	}
+
+	for _, test := range tests {
+		if IsRegistrableGrantType(test.grantType) != test.expected {
+			t.Errorf("Wrong result for %s. Expected: %t", test.grantType, test.expected)
+		}
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	path := writeTempFile(TestConfig, t)
+	defer os.Remove(path)
+
+	os.Setenv("HELIOS_SERVER_ACCESS_TOKEN_EXPIRATION_IN_SEC", "60")
+	os.Setenv("HELIOS_REDIS_MASTER_ADDRESS", "redis:6379")
+	defer os.Unsetenv("HELIOS_SERVER_ACCESS_TOKEN_EXPIRATION_IN_SEC")
+	defer os.Unsetenv("HELIOS_REDIS_MASTER_ADDRESS")
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal("Valid config rejected", err)
+	}
+	if config.Server.AccessTokenExpirationInSec != 60 {
+		t.Fatal("Access token expiration not overridden. Actual:", config.Server.AccessTokenExpirationInSec)
+	}
+	if config.RedisMaster.Address != "redis:6379" {
+		t.Fatal("Redis master address not overridden. Actual:", config.RedisMaster.Address)
+	}
+}
+
+func TestInvalidEnvOverride(t *testing.T) {
+	path := writeTempFile(TestConfig, t)
+	defer os.Remove(path)
+
+	os.Setenv("HELIOS_SERVER_ACCESS_TOKEN_EXPIRATION_IN_SEC", "an hour")
+	defer os.Unsetenv("HELIOS_SERVER_ACCESS_TOKEN_EXPIRATION_IN_SEC")
+
+	if _, err := ReadConfig(path); err == nil {
+		t.Fatal("Invalid environment override accepted")
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	secretPath := writeTempFile("root:secret@tcp(master:3306)/wikicities\n", t)
+	defer os.Remove(secretPath)
+	path := writeTempFile(TestConfig, t)
+	defer os.Remove(path)
+
+	os.Setenv("HELIOS_DB_CONNECTION_STRING_MASTER_FILE", secretPath)
+	defer os.Unsetenv("HELIOS_DB_CONNECTION_STRING_MASTER_FILE")
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal("Valid config rejected", err)
+	}
+	if config.Db.ConnectionStringMaster != "root:secret@tcp(master:3306)/wikicities" {
+		t.Fatal("Connection string not read from file. Actual:", config.Db.ConnectionStringMaster)
+	}
+}
+
+func TestStringMasksSecrets(t *testing.T) {
+	path := writeTempFile(TestConfig+"password = \"redis-secret\"\n", t)
+	defer os.Remove(path)
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal("Valid config rejected", err)
+	}
+	formatted := config.String()
+	if strings.Contains(formatted, "redis-secret") || strings.Contains(formatted, "user:pass") {
+		t.Fatal("Secrets not masked:", formatted)
+	}
+
+	var reread Config
+	if err = gcfg.ReadStringInto(&reread, formatted); err != nil {
+		t.Fatal("Formatted config can't be read back", err)
+	}
+	if reread.Server.AccessTokenExpirationInSec != config.Server.AccessTokenExpirationInSec {
+		t.Fatal("Wrong access token expiration after reading back:", reread.Server.AccessTokenExpirationInSec)
+	}
+}
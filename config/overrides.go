@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"code.google.com/p/gcfg"
+)
+
+const (
+	EnvPrefix = "HELIOS_"
+)
+
+//Returns the name of the environment variable overriding the given setting,
+//e.g. HELIOS_REDIS_MASTER_ADDRESS for the address key of the redis-master section
+func EnvName(section string, key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(section+"_"+key, "-", "_", -1))
+}
+
+//Overrides every setting for which a HELIOS_SECTION_KEY environment variable is set.
+//The values are parsed by gcfg, exactly like the ones from the config file.
+func (config *Config) applyEnvOverrides() []string {
+	var errs []string
+
+	sections := reflect.ValueOf(config).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		sectionName := sections.Type().Field(i).Tag.Get("gcfg")
+		section := sections.Field(i)
+
+		for j := 0; j < section.NumField(); j++ {
+			key := section.Type().Field(j).Tag.Get("gcfg")
+			envName := EnvName(sectionName, key)
+			value, isSet := os.LookupEnv(envName)
+			if !isSet {
+				continue
+			}
+
+			ini := fmt.Sprintf("[%s]\n%s = %s\n", sectionName, key, quote(value))
+			if err := gcfg.ReadStringInto(config, ini); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", envName, err.Error()))
+			}
+		}
+	}
+	return errs
+}
+
+//Replaces the secrets with the contents of the files given in their *-file settings,
+//so they don't have to be stored in the config file (e.g. when mounted as container secrets)
+func (config *Config) readSecretFiles() []string {
+	var errs []string
+
+	secrets := []struct {
+		name  string
+		path  string
+		value *string
+	}{
+		{"db.connection-string-master-file", config.Db.ConnectionStringMasterFile, &config.Db.ConnectionStringMaster},
+		{"db.connection-string-slave-file", config.Db.ConnectionStringSlaveFile, &config.Db.ConnectionStringSlave},
+		{"redis-master.password-file", config.RedisMaster.PasswordFile, &config.RedisMaster.Password},
+		{"redis-slave.password-file", config.RedisSlave.PasswordFile, &config.RedisSlave.Password},
+		{"admin.token-file", config.Admin.TokenFile, &config.Admin.Token},
+		{"mail.smtp-password-file", config.Mail.SmtpPasswordFile, &config.Mail.SmtpPassword},
+		{"mfa.encryption-key-file", config.Mfa.EncryptionKeyFile, &config.Mfa.EncryptionKey},
+		{"client-registration.initial-access-token-file", config.ClientRegistration.InitialAccessTokenFile,
+			&config.ClientRegistration.InitialAccessToken},
+	}
+
+	for _, secret := range secrets {
+		if secret.path == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(secret.path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", secret.name, err.Error()))
+			continue
+		}
+		*secret.value = strings.TrimRight(string(content), "\r\n")
+	}
+	return errs
+}
+
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return `"` + value + `"`
+}
@@ -0,0 +1,81 @@
+package helios
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+type AdminController struct {
+	token          string
+	redisStorage   *storage.RedisStorage
+	influxdbClient *client.Client
+}
+
+func NewAdminController(
+	influxdbClient *client.Client,
+	redisStorage *storage.RedisStorage,
+	adminConfig *config.AdminConfig) *AdminController {
+
+	controller := new(AdminController)
+	controller.influxdbClient = influxdbClient
+	controller.redisStorage = redisStorage
+	controller.token = adminConfig.Token
+
+	http.HandleFunc("/admin/revoke_user", controller.revokeUserHandler)
+
+	return controller
+}
+
+//The admin endpoints are disabled unless a token is configured
+func (controller *AdminController) isAuthorized(r *http.Request) bool {
+	if controller.token == "" {
+		return false
+	}
+
+	authorization := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(authorization) != 2 || authorization[0] != "Bearer" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(authorization[1]), []byte(controller.token)) == 1
+}
+
+func adminError(w http.ResponseWriter, statusCode int, message string) {
+	outputJSON(w, statusCode, map[string]interface{}{"error": message})
+}
+
+func (controller *AdminController) revokeUserHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "revokeUserHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "POST" {
+		adminError(w, http.StatusMethodNotAllowed, "Request must be POST")
+		return
+	}
+	if !controller.isAuthorized(r) {
+		adminError(w, http.StatusUnauthorized, "Invalid admin token")
+		return
+	}
+
+	userId := r.FormValue("user_id")
+	if userId == "" {
+		adminError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	revoked, err := controller.redisStorage.RevokeUserTokens(userId)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		adminError(w, http.StatusInternalServerError, "Tokens could not be revoked")
+		return
+	}
+
+	logger.GetLogger().Info(fmt.Sprintf("Revoked %d token(s) of user %s", revoked, userId))
+	outputJSON(w, http.StatusOK, map[string]interface{}{"user_id": userId, "revoked_tokens": revoked})
+}
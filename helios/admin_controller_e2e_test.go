@@ -0,0 +1,48 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	RevokeUserEndpoint = "/admin/revoke_user"
+	InfoEndpoint       = "/info"
+
+	//Has to match the token in the [admin] section of the config used by the tested instance
+	TestAdminToken = "test-admin-token"
+	TestUserId     = "1"
+)
+
+func revokeUser(userId string, adminToken string, t *testing.T) (int, []byte) {
+	return postResponse(ServerAddress+RevokeUserEndpoint, url.Values{"user_id": {userId}},
+		map[string]string{"Authorization": "Bearer " + adminToken}, t)
+}
+
+func TestE2eRevokeUser(t *testing.T) {
+	skipInShortMode(t)
+
+	tokenResponse := getTokenResponse(TestUserName, TestPassword, t)
+	accessToken := getJsonString(tokenResponse, "access_token", t)
+
+	statusCode, body := revokeUser(TestUserId, TestAdminToken, t)
+	if statusCode != http.StatusOK {
+		t.Fatal(fmt.Sprintf("User tokens not revoked: %d %s", statusCode, string(body)))
+	}
+
+	infoBody := getResponse(ServerAddress+InfoEndpoint+"?code="+url.QueryEscape(accessToken), t)
+	if getJsonString(unmarshall(infoBody, t), "error", t) == "" {
+		t.Fatal(fmt.Sprintf("Revoked token still valid: %s", string(infoBody)))
+	}
+}
+
+func TestE2eRevokeUserInvalidAdminToken(t *testing.T) {
+	skipInShortMode(t)
+
+	statusCode, body := revokeUser(TestUserId, "InvalidToken", t)
+	if statusCode != http.StatusUnauthorized {
+		t.Fatal(fmt.Sprintf("Invalid admin token accepted: %d %s", statusCode, string(body)))
+	}
+}
@@ -0,0 +1,224 @@
+package helios
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	ClientAssertionTypeJwtBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+//Authenticates the clients at the endpoints they call, with the method registered for the client:
+//the secret, the certificate (RFC 8705) or a signed client assertion (RFC 7523)
+type ClientAuthenticator struct {
+	server            *ReloadableServer
+	redisStorage      *storage.RedisStorage
+	clientKeyResolver *ClientKeyResolver
+	issuer            string
+	jwtMaxLifetime    time.Duration
+	jwtClockSkew      time.Duration
+}
+
+func NewClientAuthenticator(
+	server *ReloadableServer,
+	redisStorage *storage.RedisStorage,
+	clientKeyResolver *ClientKeyResolver,
+	serverConfig *config.ServerConfig,
+	jwtAssertionConfig *config.JwtAssertionConfig) *ClientAuthenticator {
+
+	authenticator := new(ClientAuthenticator)
+	authenticator.server = server
+	authenticator.redisStorage = redisStorage
+	authenticator.clientKeyResolver = clientKeyResolver
+	authenticator.issuer = serverConfig.Issuer
+	authenticator.jwtMaxLifetime = time.Duration(jwtAssertionConfig.MaxLifetimeInSec) * time.Second
+	authenticator.jwtClockSkew = time.Duration(jwtAssertionConfig.ClockSkewInSec) * time.Second
+	return authenticator
+}
+
+//Authenticates the client, sets an error on the response if it fails. The clients registered for mutual TLS
+//authenticate with their certificate, the ones registered for client assertions with an assertion and
+//the other ones with the secret, the same way osin checks it.
+func (authenticator *ClientAuthenticator) Authenticate(resp *osin.Response, r *http.Request) osin.Client {
+	if _, hasAssertion := r.Form["client_assertion"]; hasAssertion || r.Form.Get("client_assertion_type") != "" {
+		return authenticator.authenticateAssertion(resp, r)
+	}
+
+	clientId, secret, hasSecret, err := authenticator.getClientCredentials(r)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+		return nil
+	}
+	client := authenticator.getClient(resp, clientId)
+	if client == nil {
+		return nil
+	}
+
+	isAuthenticated := hasSecret && client.GetSecret() == secret
+	switch getTokenEndpointAuthMethod(client) {
+	case TokenEndpointAuthMethodTlsClientAuth, TokenEndpointAuthMethodSelfSignedTlsClientAuth:
+		isAuthenticated = isCertificateAuthenticated(client, r)
+	case TokenEndpointAuthMethodPrivateKeyJwt, TokenEndpointAuthMethodClientSecretJwt:
+		//The secret isn't enough for these clients either
+		isAuthenticated = false
+	}
+	if !isAuthenticated {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return nil
+	}
+	return client
+}
+
+//Returns the client id with the secret from the params or the basic auth. The clients authenticating
+//with mutual TLS send only the client id.
+func (authenticator *ClientAuthenticator) getClientCredentials(r *http.Request) (string, string, bool, error) {
+	if _, hasSecret := r.Form["client_secret"]; hasSecret && authenticator.server.Get().Config.AllowClientSecretInParams {
+		if clientId := r.Form.Get("client_id"); clientId != "" {
+			return clientId, r.Form.Get("client_secret"), true, nil
+		}
+	}
+
+	auth, err := osin.CheckBasicAuth(r)
+	if err != nil {
+		return "", "", false, err
+	}
+	if auth != nil {
+		return auth.Username, auth.Password, true, nil
+	}
+	if clientId := r.Form.Get("client_id"); clientId != "" && getClientCertificate(r) != nil {
+		return clientId, "", false, nil
+	}
+	return "", "", false, errors.New("Client authentication not sent")
+}
+
+//Client assertion authentication (RFC 7523 section 2.2): a JWT with the client id in iss and sub, signed with
+//a key registered for the client (private_key_jwt) or with HMAC of the client secret (client_secret_jwt)
+func (authenticator *ClientAuthenticator) authenticateAssertion(resp *osin.Response, r *http.Request) osin.Client {
+	//The assertions have to be issued for helios, so they can't be checked without its URL
+	if authenticator.issuer == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "Client assertions are not supported")
+		return nil
+	}
+	if r.Form.Get("client_assertion_type") != ClientAssertionTypeJwtBearer {
+		resp.SetError(osin.E_INVALID_REQUEST, "client_assertion_type must be "+ClientAssertionTypeJwtBearer)
+		return nil
+	}
+
+	token, err := jwt.Parse(r.Form.Get("client_assertion"))
+	if err != nil {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, err.Error())
+		return nil
+	}
+	clientId := token.Claims.String("sub")
+	if clientId == "" || (r.Form.Get("client_id") != "" && r.Form.Get("client_id") != clientId) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "sub must be the client id")
+		return nil
+	}
+	client := authenticator.getClient(resp, clientId)
+	if client == nil {
+		return nil
+	}
+
+	var keys []jwt.PublicKey
+	if getTokenEndpointAuthMethod(client) == TokenEndpointAuthMethodPrivateKeyJwt {
+		if keys, err = authenticator.clientKeyResolver.GetKeys(client.(*storage.Client)); err != nil {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+			resp.InternalError = err
+			return nil
+		}
+	}
+	if err = authenticator.verifyClientAssertion(token, client, keys, r.URL.Path); err != nil {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, err.Error())
+		return nil
+	}
+
+	isFirstUse, err := markJwtUsed(authenticator.redisStorage, token, authenticator.jwtClockSkew)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return nil
+	}
+	if !isFirstUse {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "The client assertion has already been used")
+		return nil
+	}
+	return client
+}
+
+//Checks the signature and the claims of the client assertion sent to the path, its jti is checked against
+//the used ones separately. The keys are the ones of the private_key_jwt clients.
+func (authenticator *ClientAuthenticator) verifyClientAssertion(
+	token *jwt.Token, client osin.Client, keys []jwt.PublicKey, path string) error {
+
+	var err error
+	switch getTokenEndpointAuthMethod(client) {
+	case TokenEndpointAuthMethodPrivateKeyJwt:
+		err = token.VerifyWithKeys(keys)
+	case TokenEndpointAuthMethodClientSecretJwt:
+		err = token.Verify([]byte(client.GetSecret()))
+	default:
+		err = errors.New("The client doesn't authenticate with client assertions")
+	}
+	if err != nil {
+		return err
+	}
+	return token.Claims.Validate(&jwt.Validation{
+		Issuer:  client.GetId(),
+		Subject: client.GetId(),
+		//The token endpoint is the audience RFC 7523 expects, but the assertions may be issued for helios
+		//or for the endpoint they are sent to as well
+		Audiences:   []string{authenticator.issuer, authenticator.issuer + TokenEndpointPath, authenticator.issuer + path},
+		MaxLifetime: authenticator.jwtMaxLifetime,
+		ClockSkew:   authenticator.jwtClockSkew,
+		Required:    []string{"exp", "jti"},
+	})
+}
+
+//Loads the client, sets an error on the response if it doesn't exist
+func (authenticator *ClientAuthenticator) getClient(resp *osin.Response, clientId string) osin.Client {
+	client, err := resp.Storage.GetClient(clientId)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return nil
+	}
+	if client == nil || client.GetRedirectUri() == "" {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return nil
+	}
+	return client
+}
+
+//osin authenticates the clients of its own grant types by the secret only. The client is authenticated
+//here first and its secret is passed on to osin, so the clients which don't send the secret can use them too.
+func (authenticator *ClientAuthenticator) AuthenticateOsinAccessRequest(resp *osin.Response, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+		return false
+	}
+
+	client := authenticator.Authenticate(resp, r)
+	if client == nil {
+		return false
+	}
+	r.Form.Set("client_id", client.GetId())
+	r.Form.Set("client_secret", client.GetSecret())
+	return true
+}
+
+//The clients created before the auth methods were introduced have none, they use the secret
+func getTokenEndpointAuthMethod(client osin.Client) string {
+	if heliosClient, isHeliosClient := client.(*storage.Client); isHeliosClient {
+		return heliosClient.TokenEndpointAuthMethod
+	}
+	return ""
+}
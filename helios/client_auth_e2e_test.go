@@ -0,0 +1,25 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestE2eForgedClientAssertion(t *testing.T) {
+	skipInShortMode(t)
+
+	//{"alg":"none"}.{"iss":"123456","sub":"123456"}
+	assertion := "eyJhbGciOiJub25lIn0.eyJpc3MiOiIxMjM0NTYiLCJzdWIiOiIxMjM0NTYifQ."
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":            {"password"},
+		"client_assertion_type": {ClientAssertionTypeJwtBearer},
+		"client_assertion":      {assertion},
+		"username":              {"test"},
+		"password":              {"test"},
+	}, nil, t)
+	objMap := unmarshall(body, t)
+	if getJsonString(objMap, "access_token", t) != "" || getJsonString(objMap, "error", t) == "" {
+		t.Fatal(fmt.Sprintf("Unsigned client assertion accepted: %s", string(body)))
+	}
+}
@@ -0,0 +1,151 @@
+package helios
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+)
+
+func newTestClientAuthenticator(issuer string) *ClientAuthenticator {
+	return &ClientAuthenticator{
+		issuer:            issuer,
+		jwtMaxLifetime:    10 * time.Minute,
+		jwtClockSkew:      time.Minute,
+		clientKeyResolver: NewClientKeyResolver(&config.JwtAssertionConfig{}),
+	}
+}
+
+func TestVerifyClientAssertion(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := []jwt.PublicKey{{Key: &key.PublicKey}}
+	keyClient := &storage.Client{Id: "app", TokenEndpointAuthMethod: TokenEndpointAuthMethodPrivateKeyJwt}
+	secretClient := &storage.Client{Id: "app", Secret: "secret", TokenEndpointAuthMethod: TokenEndpointAuthMethodClientSecretJwt}
+	authenticator := newTestClientAuthenticator(TestIssuer)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		client   osin.Client
+		alg      string
+		key      interface{}
+		change   func(claims jwt.Claims)
+		expected error
+	}{
+		{"private_key_jwt", keyClient, "ES256", key, func(claims jwt.Claims) {}, nil},
+		{"client_secret_jwt", secretClient, "HS256", []byte("secret"), func(claims jwt.Claims) {}, nil},
+		{"issued for the token endpoint", keyClient, "ES256", key, func(claims jwt.Claims) {
+			claims["aud"] = TestIssuer + TokenEndpointPath
+		}, nil},
+		{"issued for the endpoint it's sent to", keyClient, "ES256", key, func(claims jwt.Claims) {
+			claims["aud"] = TestIssuer + IntrospectPath
+		}, nil},
+		{"signed with another key", keyClient, "ES256", otherKey, func(claims jwt.Claims) {}, jwt.InvalidSignatureError},
+		{"signed with another secret", secretClient, "HS256", []byte("other"), func(claims jwt.Claims) {},
+			jwt.InvalidSignatureError},
+		{"signed with the secret of a private_key_jwt client", &storage.Client{Id: "app", Secret: "secret",
+			TokenEndpointAuthMethod: TokenEndpointAuthMethodPrivateKeyJwt}, "HS256", []byte("secret"),
+			func(claims jwt.Claims) {}, jwt.InvalidSignatureError},
+		{"issued by someone else", keyClient, "ES256", key, func(claims jwt.Claims) { claims["iss"] = "other" },
+			jwt.InvalidIssuerError},
+		{"issued for someone else", keyClient, "ES256", key, func(claims jwt.Claims) {
+			claims["aud"] = "https://other.example.com"
+		}, jwt.InvalidAudienceError},
+		{"expired", keyClient, "ES256", key, func(claims jwt.Claims) {
+			claims["exp"] = now.Add(-2 * time.Minute).Unix()
+		}, jwt.ExpiredError},
+		{"valid for too long", keyClient, "ES256", key, func(claims jwt.Claims) {
+			claims["exp"] = now.Add(time.Hour).Unix()
+		}, jwt.LifetimeTooLongError},
+		{"without jti", keyClient, "ES256", key, func(claims jwt.Claims) { delete(claims, "jti") }, jwt.MissingClaimError},
+	}
+
+	for _, test := range tests {
+		claims := newTestJwtClaims("app", "app")
+		test.change(claims)
+		assertion, err := jwt.Sign(test.alg, "", claims, test.key)
+		if err != nil {
+			t.Fatal("Error signing the assertion", err)
+		}
+		token, err := jwt.Parse(assertion)
+		if err != nil {
+			t.Fatal("Error parsing the assertion", err)
+		}
+		if err = authenticator.verifyClientAssertion(token, test.client, keys, IntrospectPath); err != test.expected {
+			t.Errorf("Wrong result for the assertion %s. Expected: %v Actual: %v", test.name, test.expected, err)
+		}
+	}
+
+	token, _ := jwt.Parse(mustSign("ES256", newTestJwtClaims("app", "app"), key, t))
+	if authenticator.verifyClientAssertion(token, &storage.Client{Id: "app", Secret: "secret"}, keys, IntrospectPath) == nil {
+		t.Error("Assertion of a client which authenticates with the secret accepted")
+	}
+}
+
+func TestAuthenticateAssertionRejects(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertion := mustSign("HS256", newTestJwtClaims("app", "app"), []byte("secret"), t)
+	clientStorage := &testClientStorage{clients: map[string]osin.Client{
+		"app": &storage.Client{Id: "app", Secret: "secret", RedirectUri: "https://app.example.com/cb",
+			TokenEndpointAuthMethod: TokenEndpointAuthMethodClientSecretJwt},
+		"keyless": &storage.Client{Id: "keyless", RedirectUri: "https://app.example.com/cb",
+			TokenEndpointAuthMethod: TokenEndpointAuthMethodPrivateKeyJwt},
+	}}
+
+	tests := []struct {
+		name     string
+		issuer   string
+		form     url.Values
+		expected string
+	}{
+		{"helios without an issuer", "", url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {assertion}}, osin.E_INVALID_REQUEST},
+		{"wrong assertion type", TestIssuer, url.Values{"client_assertion_type": {"urn:other"},
+			"client_assertion": {assertion}}, osin.E_INVALID_REQUEST},
+		{"malformed assertion", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {"malformed"}}, osin.E_UNAUTHORIZED_CLIENT},
+		{"assertion without sub", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {mustSign("HS256", newTestJwtClaims("app", ""), []byte("secret"), t)}},
+			osin.E_UNAUTHORIZED_CLIENT},
+		{"assertion of another client_id", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {assertion}, "client_id": {"other"}}, osin.E_UNAUTHORIZED_CLIENT},
+		{"unknown client", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {mustSign("HS256", newTestJwtClaims("other", "other"), []byte("secret"), t)}},
+			osin.E_UNAUTHORIZED_CLIENT},
+		{"client without keys", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {mustSign("ES256", newTestJwtClaims("keyless", "keyless"), key, t)}},
+			osin.E_UNAUTHORIZED_CLIENT},
+		{"assertion signed with another secret", TestIssuer, url.Values{"client_assertion_type": {ClientAssertionTypeJwtBearer},
+			"client_assertion": {mustSign("HS256", newTestJwtClaims("app", "app"), []byte("other"), t)}},
+			osin.E_UNAUTHORIZED_CLIENT},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", TestIssuer+TokenEndpointPath, strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		resp := osin.NewResponse(clientStorage)
+		client := newTestClientAuthenticator(test.issuer).authenticateAssertion(resp, r)
+		if client != nil || resp.Output["error"] != test.expected {
+			t.Errorf("Wrong result for %s. Expected: %s Actual: %v", test.name, test.expected, resp.Output["error"])
+		}
+	}
+}
+
+func mustSign(alg string, claims jwt.Claims, key interface{}, t *testing.T) string {
+	signed, err := jwt.Sign(alg, "", claims, key)
+	if err != nil {
+		t.Fatal("Error signing the JWT", err)
+	}
+	return signed
+}
@@ -0,0 +1,51 @@
+package helios
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+)
+
+//Finds the public keys the JWTs signed by the clients are verified with. The JWK sets published
+//by the clients are cached, so a key rotation is picked up within the cache TTL.
+type ClientKeyResolver struct {
+	httpClient *http.Client
+	jwksCache  *models.ExpiringCache
+}
+
+func NewClientKeyResolver(jwtAssertionConfig *config.JwtAssertionConfig) *ClientKeyResolver {
+	return &ClientKeyResolver{
+		httpClient: &http.Client{Timeout: time.Duration(jwtAssertionConfig.JwksTimeoutInSec) * time.Second},
+		jwksCache:  models.NewExpiringCache(time.Duration(jwtAssertionConfig.JwksCacheTTLInSec) * time.Second),
+	}
+}
+
+//Returns the PEM keys of the client and the keys of its JWK sets, none if the client has no keys
+func (resolver *ClientKeyResolver) GetKeys(client *storage.Client) ([]jwt.PublicKey, error) {
+	var keys []jwt.PublicKey
+	if client.Jwks != nil {
+		keys = append(keys, client.Jwks.PublicKeys()...)
+	}
+	if client.JwtPublicKeys != "" {
+		pemKeys, err := jwt.ParsePublicKeysPEM([]byte(client.JwtPublicKeys))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pemKeys...)
+	}
+
+	if client.JwksUri != "" {
+		set, err := resolver.jwksCache.Get(client.JwksUri, func() (interface{}, error) {
+			return jwt.FetchKeySet(resolver.httpClient, client.JwksUri)
+		})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, set.(*jwt.JWKSet).PublicKeys()...)
+	}
+	return keys, nil
+}
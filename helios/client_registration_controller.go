@@ -0,0 +1,356 @@
+package helios
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	ClientsPath = "/clients"
+)
+
+const (
+	ErrorClientRegistrationDisabled = "client_registration_disabled"
+	ErrorInvalidRedirectUri         = "invalid_redirect_uri"
+	ErrorInvalidClientMetadata      = "invalid_client_metadata"
+)
+
+const (
+	TokenEndpointAuthMethodClientSecretBasic = "client_secret_basic"
+	TokenEndpointAuthMethodClientSecretPost  = "client_secret_post"
+	TokenEndpointAuthMethodPrivateKeyJwt     = "private_key_jwt"
+	TokenEndpointAuthMethodClientSecretJwt   = "client_secret_jwt"
+)
+
+const (
+	RegisteredClientIdLength      = 16
+	RegisteredClientSecretLength  = 32
+	RegistrationAccessTokenLength = 32
+)
+
+//The ways the clients can authenticate at the token endpoint with the secret
+var tokenEndpointAuthMethods = []string{
+	TokenEndpointAuthMethodClientSecretBasic,
+	TokenEndpointAuthMethodClientSecretPost,
+}
+
+//Client metadata (RFC 7591) as sent by the apps
+type clientMetadata struct {
+	RedirectUris            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	ClientName              string   `json:"client_name"`
+	//Checked for tls_client_auth and self_signed_tls_client_auth (RFC 8705), the thumbprint is specific to helios
+	TlsClientAuthSubjectDn         string `json:"tls_client_auth_subject_dn"`
+	TlsClientCertificateThumbprint string `json:"tls_client_certificate_thumbprint"`
+	//Keys of the private_key_jwt clients, the JWK set itself or its URL
+	Jwks    *jwt.JWKSet `json:"jwks"`
+	JwksUri string      `json:"jwks_uri"`
+	//Only in the update requests, they have to match the registration
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+//Lets apps register OAuth clients themselves (RFC 7591) and manage the registration with the
+//registration access token returned (RFC 7592)
+type ClientRegistrationController struct {
+	redisStorage             *storage.RedisStorage
+	isEnabled                bool
+	initialAccessToken       string
+	allowedGrantTypes        []string
+	tokenEndpointAuthMethods []string
+	requireHttpsRedirectUris bool
+	issuer                   string
+	influxdbClient           *client.Client
+}
+
+func NewClientRegistrationController(
+	influxdbClient *client.Client,
+	redisStorage *storage.RedisStorage,
+	serverConfig *config.ServerConfig,
+	clientRegistrationConfig *config.ClientRegistrationConfig) *ClientRegistrationController {
+
+	controller := new(ClientRegistrationController)
+	controller.influxdbClient = influxdbClient
+	controller.redisStorage = redisStorage
+	controller.isEnabled = clientRegistrationConfig.Enabled
+	controller.initialAccessToken = clientRegistrationConfig.InitialAccessToken
+	controller.allowedGrantTypes = strings.Fields(clientRegistrationConfig.AllowedGrantTypes)
+	controller.tokenEndpointAuthMethods = getTokenEndpointAuthMethods(serverConfig)
+	controller.requireHttpsRedirectUris = clientRegistrationConfig.RequireHttpsRedirectUris
+	controller.issuer = serverConfig.Issuer
+
+	http.HandleFunc(ClientsPath, controller.registerHandler)
+	http.HandleFunc(ClientsPath+"/", controller.manageHandler)
+
+	return controller
+}
+
+func (controller *ClientRegistrationController) registerHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "registerClientHandler")
+	defer closeTimer(timer)
+
+	if !controller.isEnabled {
+		outputError(w, http.StatusNotFound, ErrorClientRegistrationDisabled, "Client registration is disabled")
+		return
+	}
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+	if controller.initialAccessToken != "" &&
+		subtle.ConstantTimeCompare([]byte(getBearerToken(r)), []byte(controller.initialAccessToken)) != 1 {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, "The initial access token is invalid")
+		return
+	}
+
+	metadata, isValid := controller.readMetadata(w, r)
+	if !isValid {
+		return
+	}
+
+	clientId, err := randomHex(RegisteredClientIdLength)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	clientSecret, err := randomHex(RegisteredClientSecretLength)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	registrationAccessToken, err := randomHex(RegistrationAccessTokenLength)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	registeredClient := &storage.Client{
+		Id:                          clientId,
+		Secret:                      clientSecret,
+		RegistrationAccessTokenHash: hashRegistrationAccessToken(registrationAccessToken),
+		IssuedAt:                    time.Now().Unix(),
+	}
+	applyMetadata(registeredClient, metadata)
+	if err = controller.redisStorage.SetClient(clientId, registeredClient); err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	logger.GetLogger().Info(fmt.Sprintf("Registered client %s (%s)", clientId, registeredClient.ClientName))
+
+	output := controller.clientOutput(registeredClient)
+	output["registration_access_token"] = registrationAccessToken
+	outputJSON(w, http.StatusCreated, output)
+}
+
+//Reads (GET), updates (PUT) or deletes (DELETE) the registration at /clients/<client_id>
+func (controller *ClientRegistrationController) manageHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "manageClientHandler")
+	defer closeTimer(timer)
+
+	if !controller.isEnabled {
+		outputError(w, http.StatusNotFound, ErrorClientRegistrationDisabled, "Client registration is disabled")
+		return
+	}
+
+	registeredClient := controller.authenticateRegistration(w, r)
+	if registeredClient == nil {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		outputJSON(w, http.StatusOK, controller.clientOutput(registeredClient))
+	case "PUT":
+		metadata, isValid := controller.readMetadata(w, r)
+		if !isValid {
+			return
+		}
+		if metadata.ClientId != registeredClient.Id ||
+			(metadata.ClientSecret != "" && metadata.ClientSecret != registeredClient.Secret) {
+			outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "client_id and client_secret must match the registration")
+			return
+		}
+
+		applyMetadata(registeredClient, metadata)
+		if err := controller.redisStorage.SetClient(registeredClient.Id, registeredClient); err != nil {
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		outputJSON(w, http.StatusOK, controller.clientOutput(registeredClient))
+	case "DELETE":
+		if err := controller.redisStorage.DeleteClient(registeredClient.Id); err != nil {
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		logger.GetLogger().Info(fmt.Sprintf("Deleted registered client %s", registeredClient.Id))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be GET, PUT or DELETE")
+	}
+}
+
+//Loads the client from the path, the registration access token of the client has to be passed as the bearer token
+func (controller *ClientRegistrationController) authenticateRegistration(w http.ResponseWriter, r *http.Request) *storage.Client {
+	clientId := strings.TrimPrefix(r.URL.Path, ClientsPath+"/")
+	token := getBearerToken(r)
+
+	//Unknown clients get the same error as wrong tokens, so the registered client ids can't be probed
+	var registeredClient *storage.Client
+	if clientId != "" && token != "" {
+		if osinClient, err := controller.redisStorage.GetClient(clientId); err == nil {
+			registeredClient, _ = osinClient.(*storage.Client)
+		}
+	}
+	if registeredClient == nil || registeredClient.RegistrationAccessTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashRegistrationAccessToken(token)),
+			[]byte(registeredClient.RegistrationAccessTokenHash)) != 1 {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, "The registration access token is invalid")
+		return nil
+	}
+	return registeredClient
+}
+
+//Parses and checks the metadata against the server policy, outputs the error and returns false if it's not valid
+func (controller *ClientRegistrationController) readMetadata(w http.ResponseWriter, r *http.Request) (*clientMetadata, bool) {
+	metadata := new(clientMetadata)
+	if err := json.NewDecoder(r.Body).Decode(metadata); err != nil {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "The request body must be the client metadata in JSON")
+		return nil, false
+	}
+
+	if len(metadata.RedirectUris) == 0 {
+		outputError(w, http.StatusBadRequest, ErrorInvalidRedirectUri, "At least one redirect uri is required")
+		return nil, false
+	}
+	for _, redirectUri := range metadata.RedirectUris {
+		if !controller.isValidRedirectUri(redirectUri) {
+			outputError(w, http.StatusBadRequest, ErrorInvalidRedirectUri, "Invalid redirect uri: "+redirectUri)
+			return nil, false
+		}
+	}
+
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = controller.allowedGrantTypes
+	}
+	for _, grantType := range metadata.GrantTypes {
+		if !containsString(controller.allowedGrantTypes, grantType) || !config.IsRegistrableGrantType(grantType) {
+			outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "Grant type not allowed: "+grantType)
+			return nil, false
+		}
+	}
+
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = TokenEndpointAuthMethodClientSecretBasic
+	}
+	if !containsString(controller.tokenEndpointAuthMethods, metadata.TokenEndpointAuthMethod) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata,
+			"Token endpoint auth method not supported: "+metadata.TokenEndpointAuthMethod)
+		return nil, false
+	}
+	if metadata.TokenEndpointAuthMethod == TokenEndpointAuthMethodTlsClientAuth && metadata.TlsClientAuthSubjectDn == "" {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "tls_client_auth_subject_dn is required")
+		return nil, false
+	}
+	if metadata.TokenEndpointAuthMethod == TokenEndpointAuthMethodSelfSignedTlsClientAuth &&
+		metadata.TlsClientCertificateThumbprint == "" {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "tls_client_certificate_thumbprint is required")
+		return nil, false
+	}
+	if metadata.Jwks != nil && metadata.JwksUri != "" {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "Only one of jwks and jwks_uri can be given")
+		return nil, false
+	}
+	if metadata.JwksUri != "" && !controller.isValidJwksUri(metadata.JwksUri) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "Invalid jwks_uri: "+metadata.JwksUri)
+		return nil, false
+	}
+	if metadata.TokenEndpointAuthMethod == TokenEndpointAuthMethodPrivateKeyJwt && metadata.JwksUri == "" &&
+		(metadata.Jwks == nil || len(metadata.Jwks.PublicKeys()) == 0) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidClientMetadata, "jwks or jwks_uri with signing keys is required")
+		return nil, false
+	}
+	return metadata, true
+}
+
+//The redirect uris are stored space separated, like osin expects them with the separator helios configures
+func (controller *ClientRegistrationController) isValidRedirectUri(redirectUri string) bool {
+	parsed, err := url.Parse(redirectUri)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(redirectUri, " \t\r\n") {
+		return false
+	}
+	return !controller.requireHttpsRedirectUris || parsed.Scheme == "https"
+}
+
+func (controller *ClientRegistrationController) isValidJwksUri(jwksUri string) bool {
+	parsed, err := url.Parse(jwksUri)
+	if err != nil || !parsed.IsAbs() {
+		return false
+	}
+	return parsed.Scheme == "https" || (!controller.requireHttpsRedirectUris && parsed.Scheme == "http")
+}
+
+func applyMetadata(registeredClient *storage.Client, metadata *clientMetadata) {
+	registeredClient.RedirectUri = strings.Join(metadata.RedirectUris, RedirectUriSeparator)
+	registeredClient.GrantTypes = metadata.GrantTypes
+	registeredClient.TokenEndpointAuthMethod = metadata.TokenEndpointAuthMethod
+	registeredClient.ClientName = metadata.ClientName
+	registeredClient.TlsClientAuthSubjectDn = metadata.TlsClientAuthSubjectDn
+	registeredClient.TlsClientCertificateThumbprint = metadata.TlsClientCertificateThumbprint
+	registeredClient.Jwks = metadata.Jwks
+	registeredClient.JwksUri = metadata.JwksUri
+}
+
+func (controller *ClientRegistrationController) clientOutput(registeredClient *storage.Client) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":                         registeredClient.Id,
+		"client_secret":                     registeredClient.Secret,
+		"client_id_issued_at":               registeredClient.IssuedAt,
+		"client_secret_expires_at":          0,
+		"registration_client_uri":           controller.issuer + ClientsPath + "/" + registeredClient.Id,
+		"redirect_uris":                     strings.Split(registeredClient.RedirectUri, RedirectUriSeparator),
+		"grant_types":                       registeredClient.GrantTypes,
+		"token_endpoint_auth_method":        registeredClient.TokenEndpointAuthMethod,
+		"client_name":                       registeredClient.ClientName,
+		"tls_client_auth_subject_dn":        registeredClient.TlsClientAuthSubjectDn,
+		"tls_client_certificate_thumbprint": registeredClient.TlsClientCertificateThumbprint,
+		"jwks":                              registeredClient.Jwks,
+		"jwks_uri":                          registeredClient.JwksUri,
+	}
+}
+
+func hashRegistrationAccessToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(length int) (string, error) {
+	randomBytes := make([]byte, length/2)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
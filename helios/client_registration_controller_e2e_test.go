@@ -0,0 +1,27 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestE2eManageClientInvalidRegistrationToken(t *testing.T) {
+	skipInShortMode(t)
+
+	req, err := http.NewRequest("GET", ServerAddress+ClientsPath+"/"+TestClientId, nil)
+	if err != nil {
+		t.Fatal("Error creating request", err)
+	}
+	req.Header.Set("Authorization", "Bearer InvalidToken")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error getting response", err)
+	}
+	resp.Body.Close()
+
+	//Clients created with create-client have no registration access token at all
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusNotFound {
+		t.Fatal(fmt.Sprintf("Registration read with an invalid token: %d", resp.StatusCode))
+	}
+}
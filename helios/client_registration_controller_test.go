@@ -0,0 +1,108 @@
+package helios
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+)
+
+func TestReadMetadata(t *testing.T) {
+	controller := &ClientRegistrationController{
+		allowedGrantTypes:        []string{"password", "refresh_token"},
+		tokenEndpointAuthMethods: getTokenEndpointAuthMethods(&config.ServerConfig{TlsCertFile: "cert.pem", Issuer: TestIssuer}),
+		requireHttpsRedirectUris: true,
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"minimal", `{"redirect_uris": ["https://app.example.com/cb"]}`, ""},
+		{"not JSON", `redirect_uris=https://app.example.com/cb`, ErrorInvalidClientMetadata},
+		{"no redirect uris", `{"redirect_uris": []}`, ErrorInvalidRedirectUri},
+		{"http redirect uri", `{"redirect_uris": ["http://app.example.com/cb"]}`, ErrorInvalidRedirectUri},
+		{"relative redirect uri", `{"redirect_uris": ["/cb"]}`, ErrorInvalidRedirectUri},
+		{"redirect uri with a fragment", `{"redirect_uris": ["https://app.example.com/cb#a"]}`, ErrorInvalidRedirectUri},
+		{"redirect uri with a space", `{"redirect_uris": ["https://app.example.com/c b"]}`, ErrorInvalidRedirectUri},
+		{"allowed grant type", `{"redirect_uris": ["https://app.example.com/cb"], "grant_types": ["password"]}`, ""},
+		{"grant type not allowed", `{"redirect_uris": ["https://app.example.com/cb"], "grant_types": ["client_credentials"]}`,
+			ErrorInvalidClientMetadata},
+		{"unsupported auth method", `{"redirect_uris": ["https://app.example.com/cb"], "token_endpoint_auth_method": "none"}`,
+			ErrorInvalidClientMetadata},
+		{"tls_client_auth without subject DN", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "tls_client_auth"}`, ErrorInvalidClientMetadata},
+		{"tls_client_auth", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "tls_client_auth", "tls_client_auth_subject_dn": "CN=app"}`, ""},
+		{"self_signed_tls_client_auth without thumbprint", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "self_signed_tls_client_auth"}`, ErrorInvalidClientMetadata},
+		{"private_key_jwt without keys", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "private_key_jwt"}`, ErrorInvalidClientMetadata},
+		{"private_key_jwt with jwks_uri", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "private_key_jwt", "jwks_uri": "https://app.example.com/jwks"}`, ""},
+		{"http jwks_uri", `{"redirect_uris": ["https://app.example.com/cb"],
+			"token_endpoint_auth_method": "private_key_jwt", "jwks_uri": "http://app.example.com/jwks"}`,
+			ErrorInvalidClientMetadata},
+		{"jwks and jwks_uri", `{"redirect_uris": ["https://app.example.com/cb"], "jwks": {"keys": []},
+			"jwks_uri": "https://app.example.com/jwks"}`, ErrorInvalidClientMetadata},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", TestIssuer+ClientsPath, strings.NewReader(test.body))
+		metadata, isValid := controller.readMetadata(w, r)
+
+		if test.expected == "" {
+			if !isValid || metadata == nil {
+				t.Errorf("Metadata %s rejected: %s", test.name, w.Body.String())
+			}
+			continue
+		}
+		var output map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &output)
+		if isValid || output["error"] != test.expected {
+			t.Errorf("Wrong result for metadata %s. Expected: %s Actual: %v", test.name, test.expected, output["error"])
+		}
+	}
+}
+
+func TestReadMetadataNonRegistrableGrantTypes(t *testing.T) {
+	//The grant types of the config are checked again, these can't be registered even if the config allowed them
+	controller := &ClientRegistrationController{
+		allowedGrantTypes:        []string{"password", osin.ASSERTION, GrantTypeJwtBearer, GrantTypeTokenExchange},
+		tokenEndpointAuthMethods: tokenEndpointAuthMethods,
+	}
+
+	for _, grantType := range []string{osin.ASSERTION, GrantTypeJwtBearer, GrantTypeTokenExchange} {
+		body := `{"redirect_uris": ["https://app.example.com/cb"], "grant_types": ["password", "` + grantType + `"]}`
+		r, _ := http.NewRequest("POST", TestIssuer+ClientsPath, strings.NewReader(body))
+		if _, isValid := controller.readMetadata(httptest.NewRecorder(), r); isValid {
+			t.Error("Grant type registered:", grantType)
+		}
+	}
+}
+
+func TestReadMetadataDefaults(t *testing.T) {
+	controller := &ClientRegistrationController{
+		allowedGrantTypes:        []string{"password", "refresh_token"},
+		tokenEndpointAuthMethods: tokenEndpointAuthMethods,
+	}
+
+	r, _ := http.NewRequest("POST", TestIssuer+ClientsPath, strings.NewReader(`{"redirect_uris": ["http://app.example.com/cb"]}`))
+	metadata, isValid := controller.readMetadata(httptest.NewRecorder(), r)
+	if !isValid {
+		t.Fatal("Valid metadata rejected")
+	}
+	if !reflect.DeepEqual(metadata.GrantTypes, controller.allowedGrantTypes) {
+		t.Error("All the allowed grant types expected by default. Actual:", metadata.GrantTypes)
+	}
+	if metadata.TokenEndpointAuthMethod != TokenEndpointAuthMethodClientSecretBasic {
+		t.Error("client_secret_basic expected by default. Actual:", metadata.TokenEndpointAuthMethod)
+	}
+}
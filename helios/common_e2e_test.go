@@ -3,6 +3,8 @@ package helios
 import (
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -30,3 +32,27 @@ func getResponse(address string, t *testing.T) []byte {
 
 	return body
 }
+
+func postResponse(address string, form url.Values, headers map[string]string, t *testing.T) (int, []byte) {
+	req, err := http.NewRequest("POST", address, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal("Error creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error getting response", err)
+	}
+
+	var body []byte
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal("Error reading response body", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, body
+}
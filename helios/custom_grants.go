@@ -0,0 +1,103 @@
+package helios
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+const (
+	GrantTypeMfaOtp = "urn:wikia:params:oauth:grant-type:mfa-otp"
+)
+
+//Grant types osin doesn't know about, their access requests are parsed by handleCustomAccessRequest
+var customGrantTypes = map[string]bool{
+	GrantTypeMfaOtp:        true,
+	GrantTypeDeviceCode:    true,
+	GrantTypeTokenExchange: true,
+	GrantTypeJwtBearer:     true,
+}
+
+func isCustomGrantType(r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		return false
+	}
+	return customGrantTypes[r.Form.Get("grant_type")]
+}
+
+//Does what osin.Server.HandleAccessRequest does for its own grant types: checks the request method
+//and authenticates the client. The grant specific parameters are left to the token handlers.
+func handleCustomAccessRequest(
+	server *osin.Server, clientAuthenticator *ClientAuthenticator, resp *osin.Response, r *http.Request) *osin.AccessRequest {
+
+	if r.Method == "GET" {
+		if !server.Config.AllowGetAccessRequest {
+			resp.SetError(osin.E_INVALID_REQUEST, "")
+			resp.InternalError = errors.New("Request must be POST")
+			return nil
+		}
+	} else if r.Method != "POST" {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+		return nil
+	}
+
+	grantType := osin.AccessRequestType(r.Form.Get("grant_type"))
+	if !server.Config.AllowedAccessTypes.Exists(grantType) {
+		resp.SetError(osin.E_UNSUPPORTED_GRANT_TYPE, "")
+		return nil
+	}
+
+	client := clientAuthenticator.Authenticate(resp, r)
+	if client == nil {
+		return nil
+	}
+
+	ar := &osin.AccessRequest{
+		Type:            grantType,
+		Client:          client,
+		Scope:           r.Form.Get("scope"),
+		GenerateRefresh: true,
+		Expiration:      server.Config.AccessExpiration,
+		HttpRequest:     r,
+		RedirectUri:     osin.FirstUri(client.GetRedirectUri(), server.Config.RedirectUriSeparator),
+	}
+	//Handled like osin's assertion grant, which doesn't generate refresh tokens either
+	if ar.Type == GrantTypeJwtBearer {
+		ar.AssertionType = GrantTypeJwtBearer
+		ar.Assertion = r.Form.Get("assertion")
+		ar.GenerateRefresh = false
+	}
+	return ar
+}
+
+//Generates the tokens the way osin.Server.FinishAccessRequest does, for the grants which have to store data
+//under the access token before osin saves it. The result is passed to osin in ar.ForceAccessData.
+func generateAccessData(server *osin.Server, ar *osin.AccessRequest) (*osin.AccessData, error) {
+	accessData := &osin.AccessData{
+		Client:        ar.Client,
+		AuthorizeData: ar.AuthorizeData,
+		AccessData:    ar.AccessData,
+		RedirectUri:   ar.RedirectUri,
+		CreatedAt:     time.Now(),
+		ExpiresIn:     ar.Expiration,
+		UserData:      ar.UserData,
+		Scope:         ar.Scope,
+	}
+	if accessData.RedirectUri == "" && ar.HttpRequest != nil {
+		accessData.RedirectUri = ar.HttpRequest.Form.Get("redirect_uri")
+	}
+
+	var err error
+	accessData.AccessToken, accessData.RefreshToken, err = server.AccessTokenGen.GenerateAccessToken(accessData,
+		ar.GenerateRefresh)
+	return accessData, err
+}
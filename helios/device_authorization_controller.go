@@ -0,0 +1,259 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	GrantTypeDeviceCode     = "urn:ietf:params:oauth:grant-type:device_code"
+	DeviceAuthorizationPath = "/device_authorization"
+)
+
+const (
+	ErrorDeviceAuthorizationDisabled = "device_authorization_disabled"
+	ErrorInvalidUserCode             = "invalid_user_code"
+	ErrorAuthorizationPending        = "authorization_pending"
+	ErrorSlowDown                    = "slow_down"
+	ErrorExpiredToken                = "expired_token"
+	ErrorTooManyUserCodes            = "too_many_attempts"
+)
+
+const (
+	//By how much the polling interval of a device grows every time it gets slow_down, as required by RFC 8628
+	slowDownIntervalIncreaseInSec = 5
+	//How many times a new user code is generated when the one drawn is in use
+	maxUserCodeAttempts = 3
+)
+
+//Lets devices without a comfortable keyboard, like TVs and consoles, get tokens for the user who approves
+//them on another device (RFC 8628). The tokens are polled for at /token with the device_code grant.
+type DeviceAuthorizationController struct {
+	server               *ReloadableServer
+	redisStorage         *storage.RedisStorage
+	tokenBinding         *TokenBinding
+	clientAuthenticator  *ClientAuthenticator
+	verificationUri      string
+	expirationInSec      int
+	pollingIntervalInSec int
+	maxUserCodeAttempts  int
+	influxdbClient       *client.Client
+}
+
+func NewDeviceAuthorizationController(
+	influxdbClient *client.Client,
+	server *ReloadableServer,
+	redisStorage *storage.RedisStorage,
+	tokenBinding *TokenBinding,
+	clientAuthenticator *ClientAuthenticator,
+	deviceAuthorizationConfig *config.DeviceAuthorizationConfig) *DeviceAuthorizationController {
+
+	controller := new(DeviceAuthorizationController)
+	controller.influxdbClient = influxdbClient
+	controller.server = server
+	controller.redisStorage = redisStorage
+	controller.tokenBinding = tokenBinding
+	controller.clientAuthenticator = clientAuthenticator
+	controller.verificationUri = deviceAuthorizationConfig.VerificationUri
+	controller.expirationInSec = deviceAuthorizationConfig.DeviceCodeExpirationInSec
+	controller.pollingIntervalInSec = deviceAuthorizationConfig.PollingIntervalInSec
+	controller.maxUserCodeAttempts = deviceAuthorizationConfig.MaxUserCodeAttempts
+
+	http.HandleFunc(DeviceAuthorizationPath, controller.deviceAuthorizationHandler)
+	http.HandleFunc("/device/verify", controller.verifyHandler)
+
+	return controller
+}
+
+func (controller *DeviceAuthorizationController) isEnabled() bool {
+	return controller.verificationUri != ""
+}
+
+//Issues the device code the device polls with and the user code shown to the user
+func (controller *DeviceAuthorizationController) deviceAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "deviceAuthorizationHandler")
+	defer closeTimer(timer)
+
+	if !controller.isEnabled() {
+		outputError(w, http.StatusNotFound, ErrorDeviceAuthorizationDisabled, "The device authorization grant is disabled")
+		return
+	}
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+
+	resp := controller.server.Get().NewResponse()
+	defer resp.Close()
+
+	if err := r.ParseForm(); err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+	} else if client := controller.clientAuthenticator.Authenticate(resp, r); client != nil {
+		controller.issueCodes(resp, client, r.Form.Get("scope"))
+	}
+
+	if resp.InternalError != nil {
+		logger.GetLogger().ErrorErr(resp.InternalError)
+	}
+	osin.OutputJSON(resp, w, r)
+}
+
+func (controller *DeviceAuthorizationController) issueCodes(resp *osin.Response, client osin.Client, scope string) {
+	deviceCode, err := models.NewDeviceCode()
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	authorization := &storage.DeviceAuthorization{
+		ClientId:  client.GetId(),
+		Scope:     scope,
+		Interval:  controller.pollingIntervalInSec,
+		ExpiresAt: time.Now().Add(time.Duration(controller.expirationInSec) * time.Second),
+	}
+	userCode := ""
+	for attempt := 0; attempt < maxUserCodeAttempts && userCode == ""; attempt++ {
+		var code string
+		if code, err = models.NewUserCode(); err != nil {
+			break
+		}
+		authorization.UserCode = models.NormalizeUserCode(code)
+
+		var isSaved bool
+		if isSaved, err = controller.redisStorage.SaveDeviceAuthorization(deviceCode, authorization); err != nil {
+			break
+		}
+		if isSaved {
+			userCode = code
+		}
+	}
+	if userCode == "" {
+		if err == nil {
+			err = fmt.Errorf("No free user code found in %d attempts", maxUserCodeAttempts)
+		}
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	resp.Output["device_code"] = deviceCode
+	resp.Output["user_code"] = userCode
+	resp.Output["verification_uri"] = controller.verificationUri
+	resp.Output["verification_uri_complete"] = controller.verificationUriComplete(userCode)
+	resp.Output["expires_in"] = controller.expirationInSec
+	resp.Output["interval"] = controller.pollingIntervalInSec
+}
+
+func (controller *DeviceAuthorizationController) verificationUriComplete(userCode string) string {
+	link, err := url.Parse(controller.verificationUri)
+	if err != nil {
+		return controller.verificationUri
+	}
+	query := link.Query()
+	query.Set("user_code", userCode)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+//Called by the verification page with the access token of the logged in user and the user code they entered.
+//The device gets the tokens of the user on the next poll, unless deny is true.
+func (controller *DeviceAuthorizationController) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "deviceVerifyHandler")
+	defer closeTimer(timer)
+
+	if !controller.isEnabled() {
+		outputError(w, http.StatusNotFound, ErrorDeviceAuthorizationDisabled, "The device authorization grant is disabled")
+		return
+	}
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+
+	_, userId, isAuthenticated := authenticateBearer(controller.redisStorage, controller.tokenBinding, w, r)
+	if !isAuthenticated {
+		return
+	}
+
+	userIdString := strconv.FormatInt(userId, 10)
+	userCode := models.NormalizeUserCode(r.FormValue("user_code"))
+	deviceCode, authorization, isFound := controller.findPendingAuthorization(w, userIdString, userCode)
+	if !isFound {
+		return
+	}
+
+	isDenied := r.FormValue("deny") == "true"
+	if isDenied {
+		authorization.IsDenied = true
+	} else {
+		authorization.UserId = userIdString
+	}
+	//Another request could have decided on the code since it was loaded
+	isUpdated, err := controller.redisStorage.UpdatePendingDeviceAuthorization(deviceCode, authorization)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if !isUpdated {
+		outputError(w, http.StatusBadRequest, ErrorInvalidUserCode, "The code is invalid, has expired or has been used")
+		return
+	}
+	controller.redisStorage.DeleteUserCode(userCode)
+	controller.redisStorage.RemoveUserCodeAttempt(userIdString)
+
+	logger.GetLogger().Info(fmt.Sprintf("Device authorization for client %s approved: %t by user %d",
+		authorization.ClientId, !isDenied, userId))
+	outputJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id": authorization.ClientId,
+		"scope":     authorization.Scope,
+		"approved":  !isDenied,
+	})
+}
+
+//Returns the device code and the authorization waiting for the user code. The codes a user enters are counted,
+//so the short user codes of others can't be guessed (RFC 8628 section 5.1). Outputs an error and returns false
+//if the code isn't found or the user has entered too many codes.
+func (controller *DeviceAuthorizationController) findPendingAuthorization(
+	w http.ResponseWriter, userId string, userCode string) (string, *storage.DeviceAuthorization, bool) {
+
+	attempts, err := controller.redisStorage.AddUserCodeAttempt(userId, controller.expirationInSec)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return "", nil, false
+	}
+	if attempts > controller.maxUserCodeAttempts {
+		logger.GetLogger().Warn(fmt.Sprintf("findPendingAuthorization: too many user codes entered by user %s", userId))
+		outputError(w, http.StatusTooManyRequests, ErrorTooManyUserCodes, "Too many codes have been entered, try again later")
+		return "", nil, false
+	}
+
+	deviceCode, err := controller.redisStorage.FindDeviceCode(userCode)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return "", nil, false
+	}
+	var authorization *storage.DeviceAuthorization
+	if deviceCode != "" {
+		if authorization, err = controller.redisStorage.LoadDeviceAuthorization(deviceCode); err != nil {
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+			return "", nil, false
+		}
+	}
+	if authorization == nil || authorization.UserId != "" || authorization.IsDenied {
+		outputError(w, http.StatusBadRequest, ErrorInvalidUserCode, "The code is invalid, has expired or has been used")
+		return "", nil, false
+	}
+	return deviceCode, authorization, true
+}
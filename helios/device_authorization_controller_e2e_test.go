@@ -0,0 +1,25 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestE2eDeviceCodeGrantUnknownCode(t *testing.T) {
+	skipInShortMode(t)
+
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":    {GrantTypeDeviceCode},
+		"client_id":     {TestClientId},
+		"client_secret": {TestClientSecret},
+		"device_code":   {"InvalidCode"},
+	}, nil, t)
+	//The grant is unsupported when the device authorization is disabled
+	if errorCode := getJsonString(unmarshall(body, t), "error", t); errorCode != ErrorExpiredToken &&
+		errorCode != osin.E_UNSUPPORTED_GRANT_TYPE {
+		t.Fatal(fmt.Sprintf("Unknown device_code accepted: %s", string(body)))
+	}
+}
@@ -0,0 +1,51 @@
+package helios
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Wikia/helios/storage"
+)
+
+func TestFindPendingAuthorizationAttemptsLimit(t *testing.T) {
+	redisStorage := newTestRedisStorage(t)
+	controller := &DeviceAuthorizationController{redisStorage: redisStorage, expirationInSec: 600, maxUserCodeAttempts: 3}
+	authorization := &storage.DeviceAuthorization{ClientId: "client", UserCode: "BCDFGHJK", Interval: 5,
+		ExpiresAt: time.Now().Add(10 * time.Minute)}
+	if isSaved, err := redisStorage.SaveDeviceAuthorization("device-code", authorization); err != nil || !isSaved {
+		t.Fatal("Error saving the device authorization", err)
+	}
+
+	tests := []struct {
+		name       string
+		userId     string
+		userCode   string
+		statusCode int
+	}{
+		{"valid code", "1", "BCDFGHJK", http.StatusOK},
+		{"1st wrong code", "1", "BCDFGHJL", http.StatusBadRequest},
+		{"2nd wrong code", "1", "BCDFGHJM", http.StatusBadRequest},
+		{"valid code within the limit", "1", "BCDFGHJK", http.StatusOK},
+		{"3rd wrong code", "1", "BCDFGHJN", http.StatusBadRequest},
+		{"valid code after the attempts are used up", "1", "BCDFGHJK", http.StatusTooManyRequests},
+		{"valid code of another user", "2", "BCDFGHJK", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		deviceCode, _, isFound := controller.findPendingAuthorization(w, test.userId, test.userCode)
+		if isFound {
+			//As the handler does once the user has decided
+			redisStorage.RemoveUserCodeAttempt(test.userId)
+		}
+		if test.statusCode == http.StatusOK {
+			if !isFound || deviceCode != "device-code" {
+				t.Errorf("The authorization hasn't been found for the %s: %s", test.name, w.Body.String())
+			}
+		} else if isFound || w.Code != test.statusCode {
+			t.Errorf("Wrong response for the %s. Expected: %d Actual: %d", test.name, test.statusCode, w.Code)
+		}
+	}
+}
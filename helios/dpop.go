@@ -0,0 +1,120 @@
+package helios
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/jwt"
+)
+
+const (
+	DpopHeader    = "DPoP"
+	DpopProofType = "dpop+jwt"
+	TokenTypeDpop = "DPoP"
+)
+
+const (
+	ErrorInvalidDpopProof = "invalid_dpop_proof"
+)
+
+//Returns the DPoP proof of the request, it has to be sent in exactly one header
+func getDpopProof(r *http.Request) string {
+	if proofs := r.Header[DpopHeader]; len(proofs) == 1 {
+		return proofs[0]
+	}
+	return ""
+}
+
+//The htu of the proofs sent to helios, the URL of the request without the query
+func (binding *TokenBinding) getRequestUri(r *http.Request) string {
+	return getBaseUrl(binding.issuer, r) + r.URL.Path
+}
+
+//Checks the DPoP proof of the token request, which the token is bound to. Returns the JWK thumbprint
+//of its key, or an empty string if the request has no proof or DPoP is disabled.
+//Sets an error on the response if the proof isn't valid.
+func (binding *TokenBinding) CheckTokenRequest(resp *osin.Response, r *http.Request) string {
+	if !binding.isDpopEnabled || len(r.Header[DpopHeader]) == 0 {
+		return ""
+	}
+
+	jkt, isValid, err := binding.VerifyDpopProof(getDpopProof(r), r.Method, binding.getRequestUri(r), "")
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+	} else if !isValid {
+		resp.SetError(ErrorInvalidDpopProof, "The DPoP proof is invalid")
+	}
+	return jkt
+}
+
+//Checks the DPoP proof (RFC 9449 section 4.3) of a request with the method and the URI. The proofs sent with an
+//access token have to be bound to it with ath. Returns the JWK thumbprint of the key the proof is signed with.
+func (binding *TokenBinding) VerifyDpopProof(proof string, method string, uri string, accessToken string) (string, bool, error) {
+	jkt, jti, isValid := binding.checkDpopProof(proof, method, uri, accessToken, time.Now())
+	if !isValid {
+		return "", false, nil
+	}
+
+	//The jti is remembered until the proof is too old anyway, the jti of each key is tracked separately
+	expireInSec := int((binding.dpopProofMaxAge + 2*binding.dpopProofClockSkew).Seconds()) + 1
+	isFirstUse, err := binding.redisStorage.MarkJwtIdUsed("dpop:"+jkt, jti, expireInSec)
+	if err != nil || !isFirstUse {
+		return "", false, err
+	}
+	return jkt, true, nil
+}
+
+//Checks everything about the proof but the reuse of its jti. Returns the JWK thumbprint of its key and its jti.
+func (binding *TokenBinding) checkDpopProof(
+	proof string, method string, uri string, accessToken string, now time.Time) (string, string, bool) {
+
+	token, err := jwt.Parse(proof)
+	//The proofs are signed with the private key of the JWK, never with a shared secret
+	if err != nil || token.Header.Typ != DpopProofType || jwt.IsSymmetric(token.Header.Alg) ||
+		token.Header.Jwk == nil || token.Header.Jwk.IsPrivate() {
+		return "", "", false
+	}
+	key, err := token.Header.Jwk.PublicKey()
+	if err != nil || token.Verify(key) != nil {
+		return "", "", false
+	}
+	jkt, err := token.Header.Jwk.Thumbprint()
+	if err != nil {
+		return "", "", false
+	}
+
+	claims := token.Claims
+	jti := claims.String("jti")
+	if jti == "" || claims.String("htm") != method || !isSameUri(claims.String("htu"), uri) {
+		return "", "", false
+	}
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		if claims.String("ath") != base64.RawURLEncoding.EncodeToString(hash[:]) {
+			return "", "", false
+		}
+	}
+
+	issuedAt, hasIssuedAt := claims.Time("iat")
+	if !hasIssuedAt || issuedAt.After(now.Add(binding.dpopProofClockSkew)) ||
+		issuedAt.Before(now.Add(-binding.dpopProofMaxAge-binding.dpopProofClockSkew)) {
+		return "", "", false
+	}
+	return jkt, jti, true
+}
+
+//Compares the URIs without the query and the fragment (RFC 9449 section 4.3)
+func isSameUri(htu string, uri string) bool {
+	parsed, err := url.Parse(htu)
+	if err != nil || htu == "" {
+		return false
+	}
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String() == uri
+}
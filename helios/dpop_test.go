@@ -0,0 +1,128 @@
+package helios
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/Wikia/helios/jwt"
+)
+
+const (
+	TestDpopUri         = TestIssuer + TokenEndpointPath
+	TestDpopAccessToken = "access-token"
+)
+
+func TestCheckDpopProof(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := jwt.NewJWK("", &key.PublicKey)
+	otherJwk, _ := jwt.NewJWK("", &otherKey.PublicKey)
+	expectedJkt, _ := jwk.Thumbprint()
+	hash := sha256.Sum256([]byte(TestDpopAccessToken))
+	ath := base64.RawURLEncoding.EncodeToString(hash[:])
+	binding := &TokenBinding{isDpopEnabled: true, dpopProofMaxAge: time.Minute, dpopProofClockSkew: 5 * time.Second}
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		change      func(header *jwt.Header, claims jwt.Claims)
+		accessToken string
+		expected    bool
+	}{
+		{"valid", func(header *jwt.Header, claims jwt.Claims) {}, "", true},
+		{"valid with ath", func(header *jwt.Header, claims jwt.Claims) { claims["ath"] = ath }, TestDpopAccessToken, true},
+		{"htu with a query", func(header *jwt.Header, claims jwt.Claims) { claims["htu"] = TestDpopUri + "?a=b" }, "", true},
+		{"issued within the clock skew", func(header *jwt.Header, claims jwt.Claims) {
+			claims["iat"] = now.Add(3 * time.Second).Unix()
+		}, "", true},
+		{"without typ", func(header *jwt.Header, claims jwt.Claims) { header.Typ = "" }, "", false},
+		{"other typ", func(header *jwt.Header, claims jwt.Claims) { header.Typ = "JWT" }, "", false},
+		{"signed with HMAC", func(header *jwt.Header, claims jwt.Claims) { header.Alg = "HS256" }, "", false},
+		{"without jwk", func(header *jwt.Header, claims jwt.Claims) { header.Jwk = nil }, "", false},
+		{"with a private jwk", func(header *jwt.Header, claims jwt.Claims) {
+			privateJwk := *jwk
+			privateJwk.D = "private"
+			header.Jwk = &privateJwk
+		}, "", false},
+		{"signed with another key", func(header *jwt.Header, claims jwt.Claims) { header.Jwk = otherJwk }, "", false},
+		{"without jti", func(header *jwt.Header, claims jwt.Claims) { delete(claims, "jti") }, "", false},
+		{"other htm", func(header *jwt.Header, claims jwt.Claims) { claims["htm"] = "GET" }, "", false},
+		{"other htu", func(header *jwt.Header, claims jwt.Claims) { claims["htu"] = TestIssuer + "/info" }, "", false},
+		{"without htu", func(header *jwt.Header, claims jwt.Claims) { delete(claims, "htu") }, "", false},
+		{"without ath", func(header *jwt.Header, claims jwt.Claims) {}, TestDpopAccessToken, false},
+		{"ath of another token", func(header *jwt.Header, claims jwt.Claims) { claims["ath"] = ath }, "other-token", false},
+		{"without iat", func(header *jwt.Header, claims jwt.Claims) { delete(claims, "iat") }, "", false},
+		{"issued in the future", func(header *jwt.Header, claims jwt.Claims) {
+			claims["iat"] = now.Add(time.Minute).Unix()
+		}, "", false},
+		{"too old", func(header *jwt.Header, claims jwt.Claims) {
+			claims["iat"] = now.Add(-2 * time.Minute).Unix()
+		}, "", false},
+	}
+
+	for _, test := range tests {
+		header := jwt.Header{Alg: "ES256", Typ: DpopProofType, Jwk: jwk}
+		claims := jwt.Claims{"jti": "abc", "htm": "POST", "htu": TestDpopUri, "iat": now.Unix()}
+		test.change(&header, claims)
+		var signingKey interface{} = key
+		if header.Alg == "HS256" {
+			signingKey = []byte("secret")
+		}
+		proof, err := jwt.SignWithHeader(header, claims, signingKey)
+		if err != nil {
+			t.Fatal("Error signing the proof", err)
+		}
+
+		jkt, jti, isValid := binding.checkDpopProof(proof, "POST", TestDpopUri, test.accessToken, now)
+		if isValid != test.expected {
+			t.Errorf("Wrong result for the proof %s. Expected: %t", test.name, test.expected)
+		}
+		if isValid && (jkt != expectedJkt || jti != "abc") {
+			t.Errorf("Wrong jkt or jti of the proof %s: %s %s", test.name, jkt, jti)
+		}
+	}
+
+	if _, _, isValid := binding.checkDpopProof("malformed", "POST", TestDpopUri, "", now); isValid {
+		t.Error("Malformed proof accepted")
+	}
+}
+
+func TestVerifyDpopProofRejects(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := jwt.NewJWK("", &key.PublicKey)
+	binding := &TokenBinding{isDpopEnabled: true, dpopProofMaxAge: time.Minute, dpopProofClockSkew: 5 * time.Second}
+	proof, _ := jwt.SignWithHeader(jwt.Header{Alg: "ES256", Typ: DpopProofType, Jwk: jwk},
+		jwt.Claims{"jti": "abc", "htm": "POST", "htu": TestDpopUri, "iat": time.Now().Unix()}, key)
+
+	//The invalid proofs are rejected before their jti is recorded
+	jkt, isValid, err := binding.VerifyDpopProof(proof, "GET", TestDpopUri, "")
+	if isValid || jkt != "" || err != nil {
+		t.Errorf("Proof for another method accepted: %s %t %v", jkt, isValid, err)
+	}
+}
+
+func TestIsSameUri(t *testing.T) {
+	tests := []struct {
+		htu      string
+		expected bool
+	}{
+		{TestDpopUri, true},
+		{TestDpopUri + "?a=b", true},
+		{TestDpopUri + "#a", true},
+		{TestDpopUri + "/", false},
+		{"http://helios.example.com" + TokenEndpointPath, false},
+		{"", false},
+		{"%", false},
+	}
+
+	for _, test := range tests {
+		if isSameUri(test.htu, TestDpopUri) != test.expected {
+			t.Errorf("Wrong result for %q. Expected: %t", test.htu, test.expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/models"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	ErrorInvalidConfirmationToken = "invalid_confirmation_token"
+)
+
+//Confirms the email addresses with the tokens MediaWiki or helios stored in user_email_token
+type EmailConfirmationController struct {
+	userStorage        *models.UserStorage
+	emailStatusChecker *models.EmailStatusChecker
+	influxdbClient     *client.Client
+}
+
+func NewEmailConfirmationController(
+	influxdbClient *client.Client,
+	storageFactory *models.StorageFactory) *EmailConfirmationController {
+
+	controller := new(EmailConfirmationController)
+	controller.influxdbClient = influxdbClient
+	controller.userStorage = storageFactory.GetUserStorage()
+	controller.emailStatusChecker = storageFactory.GetEmailStatusChecker()
+
+	http.HandleFunc("/email/confirm", controller.confirmHandler)
+
+	return controller
+}
+
+func (controller *EmailConfirmationController) confirmHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "confirmHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+
+	userId, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		outputError(w, http.StatusBadRequest, "invalid_request", "user_id is required")
+		return
+	}
+
+	//The token could have been written just before
+	user, err := controller.userStorage.FindByIdOnMaster(userId)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if user == nil || user.Email == "" || !user.IsValidEmailToken(r.FormValue("token")) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidConfirmationToken,
+			"The email confirmation token is invalid or has expired")
+		return
+	}
+
+	if err = controller.userStorage.ConfirmEmail(userId); err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	controller.emailStatusChecker.Invalidate(userId)
+
+	logger.GetLogger().Info(fmt.Sprintf("Email of user %d confirmed", userId))
+	outputJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":         userId,
+		"email":           user.Email,
+		"email_confirmed": true,
+	})
+}
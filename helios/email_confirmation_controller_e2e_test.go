@@ -0,0 +1,23 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	EmailConfirmEndpoint = "/email/confirm"
+)
+
+func TestE2eConfirmEmailInvalidToken(t *testing.T) {
+	skipInShortMode(t)
+
+	statusCode, body := postResponse(ServerAddress+EmailConfirmEndpoint,
+		url.Values{"user_id": {TestUserId}, "token": {"InvalidToken"}}, nil, t)
+	if statusCode != http.StatusBadRequest ||
+		getJsonString(unmarshall(body, t), "error", t) != ErrorInvalidConfirmationToken {
+		t.Fatal(fmt.Sprintf("Invalid confirmation token accepted: %d %s", statusCode, string(body)))
+	}
+}
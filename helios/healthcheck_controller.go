@@ -47,10 +47,12 @@ func (controller *HealthCheckController) healthCheckNagios(w http.ResponseWriter
 		message = "Service status: MySQL Slave Down"
 	case status == StatusRedisAndMySQLDown:
 		message = "Service status: Redis and MySQL Down"
+	case status == StatusShuttingDown:
+		message = "Service status: Shutting Down"
 	}
 
 	if status == StatusOk {
-		fmt.Fprintf(w, message)
+		fmt.Fprint(w, message)
 	} else {
 		http.Error(w, message, http.StatusServiceUnavailable)
 	}
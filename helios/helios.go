@@ -1,44 +1,113 @@
 package helios
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/RangelReale/osin"
 	"github.com/Wikia/go-commons/logger"
 	"github.com/Wikia/go-commons/perfmonitoring"
 	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/mail"
 	"github.com/Wikia/helios/models"
 	"github.com/Wikia/helios/storage"
 )
 
 const (
 	AppName = "helios"
+	//Separates the redirect uris of a client, spaces can't be a part of them
+	RedirectUriSeparator = " "
 )
 
 type Helios struct {
-	server                *osin.Server
-	oauthController       *OAuthController
-	healthCheckController *HealthCheckController
+	configPath                    string
+	conf                          *config.Config
+	reloadLock                    sync.Mutex
+	server                        *ReloadableServer
+	httpServer                    *http.Server
+	redisStorage                  *storage.RedisStorage
+	statusManager                 *StatusManager
+	oauthController               *OAuthController
+	healthCheckController         *HealthCheckController
+	adminController               *AdminController
+	passwordResetController       *PasswordResetController
+	registrationController        *RegistrationController
+	emailConfirmationController   *EmailConfirmationController
+	passwordChangeController      *PasswordChangeController
+	mfaController                 *MfaController
+	deviceAuthorizationController *DeviceAuthorizationController
+	clientRegistrationController  *ClientRegistrationController
+	metadataController            *MetadataController
 }
 
 func NewHelios() *Helios {
 	return new(Helios)
 }
 
-func (helios *Helios) initServer(redisStorage *storage.RedisStorage, serverConfig *config.ServerConfig) {
+//Holds the osin server for the request handlers. Osin reads its config without any synchronization,
+//so a reload publishes a new server with the new config instead of changing the config of the current one.
+type ReloadableServer struct {
+	server atomic.Value
+}
+
+func NewReloadableServer(osinConfig *osin.ServerConfig, storage osin.Storage) *ReloadableServer {
+	reloadableServer := new(ReloadableServer)
+	reloadableServer.server.Store(osin.NewServer(osinConfig, storage))
+	return reloadableServer
+}
+
+//Returns the current server, a request should use the same one from the start to the end
+func (reloadableServer *ReloadableServer) Get() *osin.Server {
+	return reloadableServer.server.Load().(*osin.Server)
+}
+
+func (reloadableServer *ReloadableServer) SetConfig(osinConfig *osin.ServerConfig) {
+	server := *reloadableServer.Get()
+	server.Config = osinConfig
+	reloadableServer.server.Store(&server)
+}
+
+//The grant types in the config are the ones /token accepts, the metadata document lists them too
+func newOsinConfig(conf *config.Config) *osin.ServerConfig {
 	osinConfig := osin.NewServerConfig()
-	osinConfig.AllowedAccessTypes = osin.AllowedAccessType{osin.PASSWORD, osin.REFRESH_TOKEN}
+	osinConfig.AllowedAccessTypes = osin.AllowedAccessType{osin.PASSWORD, osin.REFRESH_TOKEN, GrantTypeTokenExchange}
+	if conf.Mfa.EncryptionKey != "" {
+		osinConfig.AllowedAccessTypes = append(osinConfig.AllowedAccessTypes, GrantTypeMfaOtp)
+	}
+	if conf.DeviceAuthorization.VerificationUri != "" {
+		osinConfig.AllowedAccessTypes = append(osinConfig.AllowedAccessTypes, GrantTypeDeviceCode)
+	}
+	//The JWT bearer assertions have to be issued for helios, so they can't be checked without its URL
+	if conf.Server.Issuer != "" {
+		osinConfig.AllowedAccessTypes = append(osinConfig.AllowedAccessTypes, osin.ASSERTION, GrantTypeJwtBearer)
+	}
+	//Helios has no authorization endpoint, the users log in at /token
+	osinConfig.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{}
+	osinConfig.RedirectUriSeparator = RedirectUriSeparator
 	osinConfig.AllowGetAccessRequest = true
 	osinConfig.AllowClientSecretInParams = true
-	osinConfig.AccessExpiration = int32(serverConfig.AccessTokenExpirationInSec)
+	osinConfig.AccessExpiration = int32(conf.Server.AccessTokenExpirationInSec)
 
-	helios.server = osin.NewServer(osinConfig, redisStorage)
+	return osinConfig
+}
+
+func (helios *Helios) initServer(redisStorage *storage.RedisStorage, conf *config.Config) {
+	helios.server = NewReloadableServer(newOsinConfig(conf), redisStorage)
 }
 
 func (helios *Helios) Run(configPath string) {
 
 	conf := config.LoadConfig(configPath)
+	helios.configPath = configPath
+	helios.conf = conf
 	logger.InitLogger(AppName, logger.LogLevelDebug)
 	logger.GetLogger().Info(fmt.Sprintf("Starting %s", AppName))
 
@@ -51,19 +120,160 @@ func (helios *Helios) Run(configPath string) {
 	storageFactory := models.NewStorageFactory(&conf.Db)
 	redisStorage := storage.NewRedisStorage(&conf.RedisGeneral, &conf.RedisMaster, &conf.RedisSlave, &conf.Server)
 	statusManager := NewStatusManager(&conf.Server, redisStorage, storageFactory)
+	helios.redisStorage = redisStorage
+	helios.statusManager = statusManager
 
-	defer statusManager.Close()
-	defer redisStorage.DoClose()
-	defer storageFactory.Close()
-
-	helios.initServer(redisStorage, &conf.Server)
+	helios.initServer(redisStorage, conf)
 
-	helios.oauthController = NewOAuthController(influxdbClient, helios.server, storageFactory, redisStorage, &conf.Server)
+	mfaManager, err := NewMfaManager(redisStorage, &conf.Mfa)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		panic(err)
+	}
+	tokenBinding := NewTokenBinding(redisStorage, &conf.Server, &conf.Dpop)
+	clientKeyResolver := NewClientKeyResolver(&conf.JwtAssertion)
+	clientAuthenticator := NewClientAuthenticator(helios.server, redisStorage, clientKeyResolver, &conf.Server,
+		&conf.JwtAssertion)
+	helios.oauthController = NewOAuthController(influxdbClient, helios.server, storageFactory, redisStorage,
+		mfaManager, clientKeyResolver, clientAuthenticator, tokenBinding, &conf.Server, &conf.JwtAssertion)
 	helios.healthCheckController = NewHealthCheckController(statusManager)
-
-	err = http.ListenAndServe(conf.Server.Address, nil)
+	helios.adminController = NewAdminController(influxdbClient, redisStorage, &conf.Admin)
+	passwordPolicy, err := models.NewPasswordPolicy(&conf.PasswordPolicy)
 	if err != nil {
 		logger.GetLogger().ErrorErr(err)
 		panic(err)
 	}
+	mailer := mail.NewMailer(&conf.Mail)
+	helios.passwordResetController = NewPasswordResetController(influxdbClient, storageFactory, redisStorage,
+		mailer, passwordPolicy, &conf.PasswordReset)
+	helios.registrationController = NewRegistrationController(influxdbClient, storageFactory, mailer,
+		passwordPolicy, &conf.EmailConfirmation)
+	helios.emailConfirmationController = NewEmailConfirmationController(influxdbClient, storageFactory)
+	helios.passwordChangeController = NewPasswordChangeController(influxdbClient, storageFactory, redisStorage,
+		tokenBinding, passwordPolicy)
+	helios.mfaController = NewMfaController(influxdbClient, storageFactory, redisStorage, tokenBinding, mfaManager)
+	helios.deviceAuthorizationController = NewDeviceAuthorizationController(influxdbClient, helios.server,
+		redisStorage, tokenBinding, clientAuthenticator, &conf.DeviceAuthorization)
+	helios.clientRegistrationController = NewClientRegistrationController(influxdbClient, redisStorage, &conf.Server,
+		&conf.ClientRegistration)
+	//Created last, the metadata lists the endpoints the other controllers have registered
+	helios.metadataController = NewMetadataController(influxdbClient, helios.server, helios.deviceAuthorizationController,
+		helios.clientRegistrationController, &conf.Server, &conf.Dpop)
+
+	helios.httpServer = &http.Server{Addr: conf.Server.Address}
+	if conf.Server.TlsCertFile != "" {
+		if helios.httpServer.TLSConfig, err = newTlsConfig(&conf.Server); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			panic(err)
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if conf.Server.TlsCertFile != "" {
+			serveErr <- helios.httpServer.ListenAndServeTLS(conf.Server.TlsCertFile, conf.Server.TlsKeyFile)
+		} else {
+			serveErr <- helios.httpServer.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	for {
+		select {
+		case err = <-serveErr:
+			logger.GetLogger().ErrorErr(err)
+			statusManager.Close()
+			redisStorage.DoClose()
+			storageFactory.Close()
+			panic(err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				helios.Reload()
+				continue
+			}
+			logger.GetLogger().Info(fmt.Sprintf("Received %s, shutting down %s", sig, AppName))
+		}
+		break
+	}
+	signal.Stop(signals)
+
+	helios.shutdown(&helios.conf.Server, statusManager, redisStorage, storageFactory)
+}
+
+//Re-reads the config file and applies the settings which can be changed without a restart.
+//Nothing is applied if the new config is not valid, changes of the other settings are only reported.
+func (helios *Helios) Reload() error {
+	helios.reloadLock.Lock()
+	defer helios.reloadLock.Unlock()
+
+	conf, err := config.ReadConfig(helios.configPath)
+	if err != nil {
+		logger.GetLogger().Error(fmt.Sprintf("Config not reloaded: %s", err.Error()))
+		return err
+	}
+
+	reloadable, restartRequired := config.Diff(helios.conf, conf)
+	applied := applyReloadableSettings(helios.conf, conf)
+
+	helios.server.SetConfig(newOsinConfig(&applied))
+	helios.redisStorage.SetTokenSettings(&applied.Server)
+	helios.statusManager.SetForceReadOnly(applied.Server.ForceReadOnly, helios.redisStorage)
+	if helios.conf.RedisMaster != applied.RedisMaster || helios.conf.RedisSlave != applied.RedisSlave {
+		helios.redisStorage.ReconfigurePools(&applied.RedisMaster, &applied.RedisSlave)
+	}
+	helios.conf = &applied
+
+	logger.GetLogger().Info(fmt.Sprintf("Config reloaded, changed settings: [%s]", strings.Join(reloadable, ", ")))
+	if len(restartRequired) > 0 {
+		logger.GetLogger().Warn(fmt.Sprintf("Changed settings which require a restart: [%s]",
+			strings.Join(restartRequired, ", ")))
+	}
+	return nil
+}
+
+//Returns the current config with the reloadable settings taken from the reloaded one, the settings which need
+//a restart keep their current values. ReadConfig has already mapped allow-multiple-access-tokens of the reloaded
+//config to the default token reuse policy, so both are taken over together.
+func applyReloadableSettings(current *config.Config, reloaded *config.Config) config.Config {
+	applied := *current
+	applied.Server.AccessTokenExpirationInSec = reloaded.Server.AccessTokenExpirationInSec
+	applied.Server.RefreshTokenExpirationInSec = reloaded.Server.RefreshTokenExpirationInSec
+	applied.Server.DefaultTokenReusePolicy = reloaded.Server.DefaultTokenReusePolicy
+	applied.Server.DefaultMaxSessions = reloaded.Server.DefaultMaxSessions
+	applied.Server.AllowMultipleAccessTokens = reloaded.Server.AllowMultipleAccessTokens
+	applied.Server.RotateRefreshTokens = reloaded.Server.RotateRefreshTokens
+	applied.Server.ForceReadOnly = reloaded.Server.ForceReadOnly
+	applied.Server.ShutdownDrainPeriodInSec = reloaded.Server.ShutdownDrainPeriodInSec
+	applied.Server.ShutdownTimeoutInSec = reloaded.Server.ShutdownTimeoutInSec
+	applied.RedisMaster.MaxIdleConn = reloaded.RedisMaster.MaxIdleConn
+	applied.RedisMaster.IdleTimeoutSec = reloaded.RedisMaster.IdleTimeoutSec
+	applied.RedisSlave.MaxIdleConn = reloaded.RedisSlave.MaxIdleConn
+	applied.RedisSlave.IdleTimeoutSec = reloaded.RedisSlave.IdleTimeoutSec
+	return applied
+}
+
+//Stops accepting new requests and waits for the in-flight ones before the Redis and MySQL pools are closed.
+//The instance is reported as not ready on /heartbeat for the drain period first, so that the load balancer
+//has the time to take it out of rotation.
+func (helios *Helios) shutdown(serverConfig *config.ServerConfig, statusManager *StatusManager,
+	redisStorage *storage.RedisStorage, storageFactory *models.StorageFactory) {
+
+	statusManager.SetShuttingDown()
+	time.Sleep(time.Duration(serverConfig.ShutdownDrainPeriodInSec) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(serverConfig.ShutdownTimeoutInSec)*time.Second)
+	defer cancel()
+
+	if err := helios.httpServer.Shutdown(ctx); err != nil {
+		logger.GetLogger().Error(fmt.Sprintf("Not all requests finished before the shutdown deadline: %s", err.Error()))
+	}
+
+	statusManager.Close()
+	redisStorage.DoClose()
+	storageFactory.Close()
+
+	logger.GetLogger().Info(fmt.Sprintf("%s stopped", AppName))
 }
@@ -0,0 +1,153 @@
+package helios
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	TestIssuer       = "https://helios.example.com"
+	TestRedisAddress = "localhost:6379"
+)
+
+//Returns the storage using the Redis running at TestRedisAddress. Its keys are prefixed uniquely for the test
+//and removed once the test finishes.
+func newTestRedisStorage(t *testing.T) *storage.RedisStorage {
+	if testing.Short() {
+		t.Skip("Skipping Redis test in short mode.")
+	}
+	//Fails where there's no syslog, only the errors would be logged then
+	logger.InitLogger("helios", logger.LogLevelError)
+
+	prefix := "test." + uuid.New() + "."
+	redisStorage := storage.NewRedisStorage(&config.RedisGeneralConfig{Prefix: prefix},
+		&config.RedisInstanceConfig{UseThisInstance: true, Address: TestRedisAddress, MaxIdleConn: 10},
+		&config.RedisInstanceConfig{}, &config.ServerConfig{RefreshTokenExpirationInSec: 3600})
+	t.Cleanup(func() {
+		keys, err := redisStorage.ScanKeys(prefix + "*")
+		if err != nil {
+			t.Error("Error removing the test keys", err)
+			return
+		}
+		for _, key := range keys {
+			redisStorage.DeleteKey(key)
+		}
+	})
+	return redisStorage
+}
+
+//Serves the clients from memory, the tests don't use the other methods of the storage
+type testClientStorage struct {
+	osin.Storage
+	clients map[string]osin.Client
+}
+
+func (storage *testClientStorage) Clone() osin.Storage {
+	return storage
+}
+
+func (storage *testClientStorage) GetClient(id string) (osin.Client, error) {
+	return storage.clients[id], nil
+}
+
+//Claims of a JWT issued by the client for helios, which are valid for the next 5 minutes
+func newTestJwtClaims(issuer string, subject string) jwt.Claims {
+	now := time.Now()
+	return jwt.Claims{
+		"iss": issuer,
+		"sub": subject,
+		"aud": TestIssuer,
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"iat": now.Unix(),
+		"jti": "abc",
+	}
+}
+
+func TestReloadableServerSetConfig(t *testing.T) {
+	oldConfig := osin.NewServerConfig()
+	oldConfig.AccessExpiration = 100
+	reloadableServer := NewReloadableServer(oldConfig, nil)
+	oldServer := reloadableServer.Get()
+
+	newConfig := osin.NewServerConfig()
+	newConfig.AccessExpiration = 200
+	reloadableServer.SetConfig(newConfig)
+	newServer := reloadableServer.Get()
+
+	if newServer == oldServer {
+		t.Fatal("A new server should be published")
+	}
+	if oldServer.Config.AccessExpiration != 100 {
+		t.Errorf("The config of the old server changed to %d", oldServer.Config.AccessExpiration)
+	}
+	if newServer.Config.AccessExpiration != 200 {
+		t.Errorf("Expected the new config, got %d", newServer.Config.AccessExpiration)
+	}
+	if newServer.AccessTokenGen != oldServer.AccessTokenGen {
+		t.Error("The token generator should be kept")
+	}
+}
+
+const TestReloadConfig = `
+[server]
+address = ":8080"
+access-token-expiration-in-sec = 3600
+refresh-token-expiration-in-sec = 15552000
+allow-multiple-access-tokens = %t
+
+[db]
+connection-string-master = "user:pass@tcp(master:3306)/wikicities"
+connection-string-slave = "user:pass@tcp(slave:3306)/wikicities"
+type = "mysql"
+user-table = "user"
+user-table-key = "Id"
+
+[redis-master]
+use-this-instance = true
+address = "%s"
+`
+
+func readTestConfig(content string, t *testing.T) *config.Config {
+	file, err := ioutil.TempFile("", "helios")
+	if err != nil {
+		t.Fatal("Error creating temp file", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(content)
+	file.Close()
+
+	conf, err := config.ReadConfig(file.Name())
+	if err != nil {
+		t.Fatal("Error reading the config", err)
+	}
+	return conf
+}
+
+func TestApplyReloadableSettings(t *testing.T) {
+	current := readTestConfig(fmt.Sprintf(TestReloadConfig, false, "localhost:6379"), t)
+	reloaded := readTestConfig(fmt.Sprintf(TestReloadConfig, true, "other:6379"), t)
+
+	applied := applyReloadableSettings(current, reloaded)
+	if !applied.Server.AllowMultipleAccessTokens || applied.Server.DefaultTokenReusePolicy != config.TokenReusePolicyNew {
+		t.Error("The deprecated allow-multiple-access-tokens not applied. Policy:", applied.Server.DefaultTokenReusePolicy)
+	}
+	if applied.RedisMaster.Address != "localhost:6379" {
+		t.Error("A setting which requires a restart applied:", applied.RedisMaster.Address)
+	}
+	//The next reload of the same file reports nothing but the settings which require a restart
+	reloadable, restartRequired := config.Diff(&applied, reloaded)
+	if len(reloadable) != 0 || !reflect.DeepEqual(restartRequired, []string{"redis-master.address"}) {
+		t.Error("Wrong settings left after the reload:", reloadable, restartRequired)
+	}
+}
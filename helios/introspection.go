@@ -0,0 +1,95 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/go-commons/logger"
+)
+
+//Token introspection (RFC 7662) for the resource servers. For the tokens bound to a DPoP key the resource
+//server passes the proof it got in the DPoP header, with the method and the URL of its request in htm and htu;
+//the token is reported active only if the proof is valid and signed with the key. Without htm and htu the proof
+//is checked against the introspection request itself. The thumbprint of the certificate the token is bound to is
+//returned in cnf, for the resource server to compare with the certificate of its connection (RFC 8705).
+//The resource servers authenticate like the clients do at /token (RFC 7662 section 2.1).
+func (controller *OAuthController) introspectHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "introspectHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		outputError(w, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+
+	resp := controller.server.Get().NewResponse()
+	defer resp.Close()
+	if client := controller.clientAuthenticator.Authenticate(resp, r); client == nil {
+		if resp.InternalError != nil {
+			logger.GetLogger().ErrorErr(resp.InternalError)
+		}
+		osin.OutputJSON(resp, w, r)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		outputError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	inactive := map[string]interface{}{"active": false}
+	accessData, err := controller.redisStorage.LoadAccess(token)
+	if err != nil || accessData == nil || accessData.Client == nil || accessData.IsExpired() {
+		outputJSON(w, http.StatusOK, inactive)
+		return
+	}
+
+	confirmation, err := controller.redisStorage.LoadTokenConfirmation(token)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	tokenType := controller.server.Get().Config.TokenType
+	if confirmation != nil && confirmation.Jkt != "" {
+		method, uri := r.PostForm.Get("htm"), r.PostForm.Get("htu")
+		if method == "" && uri == "" {
+			method, uri = r.Method, controller.tokenBinding.getRequestUri(r)
+		}
+		isConfirmed, err := controller.tokenBinding.checkDpopBinding(confirmation, token, getDpopProof(r), method, uri)
+		if err != nil {
+			logger.GetLogger().ErrorErr(err)
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		if !isConfirmed {
+			outputJSON(w, http.StatusOK, inactive)
+			return
+		}
+		tokenType = TokenTypeDpop
+	}
+
+	output := map[string]interface{}{
+		"active":     true,
+		"client_id":  accessData.Client.GetId(),
+		"token_type": tokenType,
+		"iat":        accessData.CreatedAt.Unix(),
+		"exp":        accessData.ExpireAt().Unix(),
+	}
+	if accessData.UserData != nil {
+		output["sub"] = fmt.Sprintf("%v", accessData.UserData)
+	}
+	if accessData.Scope != "" {
+		output["scope"] = accessData.Scope
+	}
+	if confirmation != nil {
+		output["cnf"] = confirmation
+	}
+	outputJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,30 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestE2eIntrospectInvalidToken(t *testing.T) {
+	skipInShortMode(t)
+
+	form := url.Values{
+		"token":         {"InvalidToken"},
+		"client_id":     {TestClientId},
+		"client_secret": {TestClientSecret},
+	}
+	_, body := postResponse(ServerAddress+IntrospectPath, form, nil, t)
+	if string(*unmarshall(body, t)["active"]) != "false" {
+		t.Fatal(fmt.Sprintf("Invalid token reported active: %s", string(body)))
+	}
+}
+
+func TestE2eIntrospectWithoutClientAuthentication(t *testing.T) {
+	skipInShortMode(t)
+
+	_, body := postResponse(ServerAddress+IntrospectPath, url.Values{"token": {"InvalidToken"}}, nil, t)
+	if _, hasActive := unmarshall(body, t)["active"]; hasActive {
+		t.Fatal(fmt.Sprintf("Token introspected without client authentication: %s", string(body)))
+	}
+}
@@ -0,0 +1,107 @@
+package helios
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	GrantTypeJwtBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+//JWT bearer assertion grant (RFC 7523): a trusted partner signs a JWT with the id of the user in sub and
+//gets a token for the user. It's accepted both as osin's assertion grant with assertion_type set to the
+//jwt-bearer URN and with the jwt-bearer URN as the grant_type.
+func (controller *OAuthController) tokenHandlerAssertion(resp *osin.Response, r *http.Request, ar *osin.AccessRequest) error {
+	if controller.issuer == "" {
+		resp.SetError(osin.E_UNSUPPORTED_GRANT_TYPE, "")
+		return nil
+	}
+	if ar.AssertionType != GrantTypeJwtBearer {
+		resp.SetError(osin.E_INVALID_REQUEST, "assertion_type must be "+GrantTypeJwtBearer)
+		return nil
+	}
+	if ar.Assertion == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "assertion is required")
+		return nil
+	}
+
+	client, isHeliosClient := ar.Client.(*storage.Client)
+	if !isHeliosClient {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return nil
+	}
+	keys, err := controller.clientKeyResolver.GetKeys(client)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	if len(keys) == 0 {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "The client has no keys for signing assertions")
+		return nil
+	}
+
+	token, err := controller.verifyAssertion(ar.Assertion, keys, client.GetJwtIssuer())
+	if err != nil {
+		resp.SetError(osin.E_INVALID_GRANT, err.Error())
+		return nil
+	}
+
+	if isFirstUse, err := markJwtUsed(controller.redisStorage, token, controller.jwtClockSkew); err != nil || !isFirstUse {
+		if err == nil {
+			resp.SetError(osin.E_INVALID_GRANT, "The assertion has already been used")
+		} else {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+		}
+		return err
+	}
+
+	userId, err := strconv.ParseInt(token.Claims.String("sub"), 10, 64)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_GRANT, "sub must be the id of a user")
+		return nil
+	}
+	if isAllowed, err := controller.checkUserStatus(resp, userId); !isAllowed {
+		return err
+	}
+	if isAllowed, err := controller.checkBlocks(resp, r, userId); !isAllowed {
+		return err
+	}
+
+	return controller.grantUser(ar, userId)
+}
+
+//Checks the signature and the claims of the assertion, its jti is checked against the used ones separately
+func (controller *OAuthController) verifyAssertion(assertion string, keys []jwt.PublicKey, issuer string) (*jwt.Token, error) {
+	token, err := jwt.Parse(assertion)
+	if err == nil {
+		err = token.VerifyWithKeys(keys)
+	}
+	if err == nil {
+		err = token.Claims.Validate(controller.newJwtValidation(issuer, "sub", "exp", "jti"))
+	}
+	return token, err
+}
+
+//The JWTs presented at the token endpoint have to be issued for helios or for the token endpoint itself
+func (controller *OAuthController) newJwtValidation(issuer string, required ...string) *jwt.Validation {
+	return &jwt.Validation{
+		Issuer:      issuer,
+		Audiences:   []string{controller.issuer, controller.issuer + TokenEndpointPath},
+		MaxLifetime: controller.jwtMaxLifetime,
+		ClockSkew:   controller.jwtClockSkew,
+		Required:    required,
+	}
+}
+
+//Records the jti of the JWT until it expires, returns false if it has been used before
+func markJwtUsed(redisStorage *storage.RedisStorage, token *jwt.Token, clockSkew time.Duration) (bool, error) {
+	expiresAt, _ := token.Claims.Time("exp")
+	expireInSec := int(expiresAt.Add(clockSkew).Sub(time.Now()).Seconds()) + 1
+	return redisStorage.MarkJwtIdUsed(token.Claims.String("iss"), token.Claims.String("jti"), expireInSec)
+}
@@ -0,0 +1,24 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestE2eJwtBearerGrantForgedAssertion(t *testing.T) {
+	skipInShortMode(t)
+
+	//{"alg":"none"}.{"iss":"123456","sub":"1"}
+	assertion := "eyJhbGciOiJub25lIn0.eyJpc3MiOiIxMjM0NTYiLCJzdWIiOiIxIn0."
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":    {GrantTypeJwtBearer},
+		"client_id":     {TestClientId},
+		"client_secret": {TestClientSecret},
+		"assertion":     {assertion},
+	}, nil, t)
+	objMap := unmarshall(body, t)
+	if getJsonString(objMap, "access_token", t) != "" || getJsonString(objMap, "error", t) == "" {
+		t.Fatal(fmt.Sprintf("Unsigned assertion accepted: %s", string(body)))
+	}
+}
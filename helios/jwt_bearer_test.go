@@ -0,0 +1,108 @@
+package helios
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/Wikia/helios/storage"
+)
+
+func newTestOAuthController(issuer string) *OAuthController {
+	return &OAuthController{
+		issuer:            issuer,
+		jwtMaxLifetime:    10 * time.Minute,
+		jwtClockSkew:      time.Minute,
+		clientKeyResolver: NewClientKeyResolver(&config.JwtAssertionConfig{}),
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := []jwt.PublicKey{{Key: &key.PublicKey}}
+	controller := newTestOAuthController(TestIssuer)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		change   func(claims jwt.Claims)
+		key      *ecdsa.PrivateKey
+		expected error
+	}{
+		{"valid", func(claims jwt.Claims) {}, key, nil},
+		{"issued for the token endpoint", func(claims jwt.Claims) { claims["aud"] = TestIssuer + TokenEndpointPath }, key, nil},
+		{"signed with another key", func(claims jwt.Claims) {}, otherKey, jwt.InvalidSignatureError},
+		{"issued by someone else", func(claims jwt.Claims) { claims["iss"] = "other" }, key, jwt.InvalidIssuerError},
+		{"issued for someone else", func(claims jwt.Claims) { claims["aud"] = "https://other.example.com" }, key,
+			jwt.InvalidAudienceError},
+		{"expired", func(claims jwt.Claims) { claims["exp"] = now.Add(-2 * time.Minute).Unix() }, key, jwt.ExpiredError},
+		{"valid for too long", func(claims jwt.Claims) { claims["exp"] = now.Add(time.Hour).Unix() }, key,
+			jwt.LifetimeTooLongError},
+		{"without sub", func(claims jwt.Claims) { delete(claims, "sub") }, key, jwt.MissingClaimError},
+		{"without jti", func(claims jwt.Claims) { delete(claims, "jti") }, key, jwt.MissingClaimError},
+	}
+
+	for _, test := range tests {
+		claims := newTestJwtClaims("partner", "1")
+		test.change(claims)
+		assertion, err := jwt.Sign("ES256", "", claims, test.key)
+		if err != nil {
+			t.Fatal("Error signing the assertion", err)
+		}
+		if _, err = controller.verifyAssertion(assertion, keys, "partner"); err != test.expected {
+			t.Errorf("Wrong result for the assertion %s. Expected: %v Actual: %v", test.name, test.expected, err)
+		}
+	}
+
+	if _, err := controller.verifyAssertion("malformed", keys, "partner"); err != jwt.MalformedTokenError {
+		t.Error("Malformed assertion not rejected. Actual:", err)
+	}
+}
+
+func TestTokenHandlerAssertionRejects(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))
+	assertion, _ := jwt.Sign("ES256", "", newTestJwtClaims("partner", "1"), key)
+	clientWithKeys := &storage.Client{Id: "partner", JwtPublicKeys: publicKeyPEM}
+
+	tests := []struct {
+		name          string
+		issuer        string
+		client        osin.Client
+		assertionType string
+		assertion     string
+		expected      string
+	}{
+		{"helios without an issuer", "", clientWithKeys, GrantTypeJwtBearer, assertion, osin.E_UNSUPPORTED_GRANT_TYPE},
+		{"wrong assertion type", TestIssuer, clientWithKeys, "urn:other", assertion, osin.E_INVALID_REQUEST},
+		{"no assertion", TestIssuer, clientWithKeys, GrantTypeJwtBearer, "", osin.E_INVALID_REQUEST},
+		{"client of another storage", TestIssuer, &osin.DefaultClient{Id: "partner"}, GrantTypeJwtBearer, assertion,
+			osin.E_UNAUTHORIZED_CLIENT},
+		{"client without keys", TestIssuer, &storage.Client{Id: "partner"}, GrantTypeJwtBearer, assertion,
+			osin.E_UNAUTHORIZED_CLIENT},
+		{"assertion of another client", TestIssuer, &storage.Client{Id: "other", JwtPublicKeys: publicKeyPEM},
+			GrantTypeJwtBearer, assertion, osin.E_INVALID_GRANT},
+	}
+
+	for _, test := range tests {
+		resp := osin.NewResponse(&testClientStorage{})
+		ar := &osin.AccessRequest{Client: test.client, AssertionType: test.assertionType, Assertion: test.assertion}
+		r, _ := http.NewRequest("POST", TestIssuer+TokenEndpointPath, nil)
+		if err := newTestOAuthController(test.issuer).tokenHandlerAssertion(resp, r, ar); err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.name, err)
+		}
+		if resp.Output["error"] != test.expected || ar.Authorized {
+			t.Errorf("Wrong result for %s. Expected: %s Actual: %s", test.name, test.expected, resp.Output["error"])
+		}
+	}
+}
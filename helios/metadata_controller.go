@@ -0,0 +1,113 @@
+package helios
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/jwt"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	MetadataPath = "/.well-known/oauth-authorization-server"
+)
+
+//Publishes the authorization server metadata (RFC 8414), so the client libraries can discover the endpoints,
+//grant types and client authentication methods instead of having them configured by hand.
+//The document is built for every request from the live osin config and the registered handlers,
+//so it always matches what the server really supports, also after a config reload.
+type MetadataController struct {
+	server                        *ReloadableServer
+	deviceAuthorizationController *DeviceAuthorizationController
+	clientRegistrationController  *ClientRegistrationController
+	issuer                        string
+	tokenEndpointAuthMethods      []string
+	isTlsEnabled                  bool
+	isDpopEnabled                 bool
+	influxdbClient                *client.Client
+}
+
+func NewMetadataController(
+	influxdbClient *client.Client,
+	server *ReloadableServer,
+	deviceAuthorizationController *DeviceAuthorizationController,
+	clientRegistrationController *ClientRegistrationController,
+	serverConfig *config.ServerConfig,
+	dpopConfig *config.DpopConfig) *MetadataController {
+
+	controller := new(MetadataController)
+	controller.influxdbClient = influxdbClient
+	controller.server = server
+	controller.deviceAuthorizationController = deviceAuthorizationController
+	controller.clientRegistrationController = clientRegistrationController
+	controller.issuer = serverConfig.Issuer
+	controller.tokenEndpointAuthMethods = getTokenEndpointAuthMethods(serverConfig)
+	controller.isTlsEnabled = serverConfig.TlsCertFile != ""
+	controller.isDpopEnabled = dpopConfig.Enabled
+
+	http.HandleFunc(MetadataPath, controller.metadataHandler)
+
+	return controller
+}
+
+func (controller *MetadataController) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "metadataHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "GET" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be GET")
+		return
+	}
+
+	//RFC 8414 requires the issuer to match the URL the metadata is published under
+	issuer := getBaseUrl(controller.issuer, r)
+	osinConfig := controller.server.Get().Config
+
+	grantTypes := make([]string, 0, len(osinConfig.AllowedAccessTypes))
+	for _, grantType := range osinConfig.AllowedAccessTypes {
+		grantTypes = append(grantTypes, string(grantType))
+	}
+	responseTypes := make([]string, 0, len(osinConfig.AllowedAuthorizeTypes))
+	for _, responseType := range osinConfig.AllowedAuthorizeTypes {
+		responseTypes = append(responseTypes, string(responseType))
+	}
+
+	metadata := map[string]interface{}{
+		"issuer":                                     issuer,
+		"response_types_supported":                   responseTypes,
+		"grant_types_supported":                      grantTypes,
+		"token_endpoint_auth_methods_supported":      controller.tokenEndpointAuthMethods,
+		"token_types_supported":                      []string{osinConfig.TokenType},
+		"tls_client_certificate_bound_access_tokens": controller.isTlsEnabled,
+	}
+	if isHandled(TokenEndpointPath) {
+		metadata["token_endpoint"] = issuer + TokenEndpointPath
+	}
+	if isHandled(UserInfoPath) {
+		metadata["userinfo_endpoint"] = issuer + UserInfoPath
+	}
+	if isHandled(IntrospectPath) {
+		metadata["introspection_endpoint"] = issuer + IntrospectPath
+	}
+	if containsString(controller.tokenEndpointAuthMethods, TokenEndpointAuthMethodPrivateKeyJwt) {
+		metadata["token_endpoint_auth_signing_alg_values_supported"] = append(jwt.Algorithms(false), jwt.Algorithms(true)...)
+	}
+	if controller.isDpopEnabled {
+		metadata["dpop_signing_alg_values_supported"] = jwt.Algorithms(false)
+	}
+	if isHandled(DeviceAuthorizationPath) && controller.deviceAuthorizationController.isEnabled() {
+		metadata["device_authorization_endpoint"] = issuer + DeviceAuthorizationPath
+	}
+	if isHandled(ClientsPath) && controller.clientRegistrationController.isEnabled {
+		metadata["registration_endpoint"] = issuer + ClientsPath
+	}
+
+	outputJSON(w, http.StatusOK, metadata)
+}
+
+//Whether a controller has registered a handler for the path
+func isHandled(path string) bool {
+	_, pattern := http.DefaultServeMux.Handler(&http.Request{Method: "GET", URL: &url.URL{Path: path}})
+	return pattern == path
+}
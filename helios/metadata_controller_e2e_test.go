@@ -0,0 +1,28 @@
+package helios
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestE2eMetadata(t *testing.T) {
+	skipInShortMode(t)
+
+	body := getResponse(ServerAddress+MetadataPath, t)
+	objMap := unmarshall(body, t)
+
+	if getJsonString(objMap, "token_endpoint", t) != getJsonString(objMap, "issuer", t)+TokenEndpointPath {
+		t.Fatal(fmt.Sprintf("Token endpoint missing from the metadata: %s", string(body)))
+	}
+
+	var grantTypes []string
+	if err := json.Unmarshal(*objMap["grant_types_supported"], &grantTypes); err != nil {
+		t.Fatal("Error reading grant types", err)
+	}
+	if !containsString(grantTypes, string(osin.PASSWORD)) || !containsString(grantTypes, string(osin.REFRESH_TOKEN)) {
+		t.Fatal(fmt.Sprintf("Grant types missing from the metadata: %s", string(body)))
+	}
+}
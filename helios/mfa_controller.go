@@ -0,0 +1,158 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	ErrorMfaDisabled       = "mfa_disabled"
+	ErrorMfaNotEnrolled    = "mfa_not_enrolled"
+	ErrorMfaAlreadyEnabled = "mfa_already_enabled"
+	ErrorInvalidMfaCode    = "invalid_mfa_code"
+)
+
+//Lets the users logged in through helios set up and turn off two-factor authentication
+type MfaController struct {
+	userStorage    *models.UserStorage
+	redisStorage   *storage.RedisStorage
+	tokenBinding   *TokenBinding
+	mfaManager     *MfaManager
+	influxdbClient *client.Client
+}
+
+func NewMfaController(
+	influxdbClient *client.Client,
+	storageFactory *models.StorageFactory,
+	redisStorage *storage.RedisStorage,
+	tokenBinding *TokenBinding,
+	mfaManager *MfaManager) *MfaController {
+
+	controller := new(MfaController)
+	controller.influxdbClient = influxdbClient
+	controller.userStorage = storageFactory.GetUserStorage()
+	controller.redisStorage = redisStorage
+	controller.tokenBinding = tokenBinding
+	controller.mfaManager = mfaManager
+
+	http.HandleFunc("/mfa/enroll", controller.enrollHandler)
+	http.HandleFunc("/mfa/confirm", controller.confirmHandler)
+	http.HandleFunc("/mfa/recovery_codes", controller.recoveryCodesHandler)
+	http.HandleFunc("/mfa/disable", controller.disableHandler)
+
+	return controller
+}
+
+//Returns a new secret and the otpauth:// URI for the authenticator app
+func (controller *MfaController) enrollHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "mfaEnrollHandler")
+	defer closeTimer(timer)
+
+	userId, isAuthorized := controller.checkRequest(w, r)
+	if !isAuthorized {
+		return
+	}
+
+	user, err := controller.userStorage.FindById(userId)
+	if err != nil || user == nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	secret, uri, err := controller.mfaManager.Enroll(strconv.FormatInt(userId, 10), user.Name)
+	if err != nil {
+		outputMfaError(w, err)
+		return
+	}
+	outputJSON(w, http.StatusOK, map[string]interface{}{"secret": secret, "otpauth_uri": uri})
+}
+
+//Enables two-factor authentication with the first code from the authenticator app and returns the recovery codes
+func (controller *MfaController) confirmHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "mfaConfirmHandler")
+	defer closeTimer(timer)
+
+	userId, isAuthorized := controller.checkRequest(w, r)
+	if !isAuthorized {
+		return
+	}
+
+	recoveryCodes, err := controller.mfaManager.Confirm(strconv.FormatInt(userId, 10), r.FormValue("code"))
+	if err != nil {
+		outputMfaError(w, err)
+		return
+	}
+
+	logger.GetLogger().Info(fmt.Sprintf("Two-factor authentication enabled for user %d", userId))
+	outputJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": recoveryCodes})
+}
+
+func (controller *MfaController) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "mfaRecoveryCodesHandler")
+	defer closeTimer(timer)
+
+	userId, isAuthorized := controller.checkRequest(w, r)
+	if !isAuthorized {
+		return
+	}
+
+	recoveryCodes, err := controller.mfaManager.RegenerateRecoveryCodes(strconv.FormatInt(userId, 10), r.FormValue("code"))
+	if err != nil {
+		outputMfaError(w, err)
+		return
+	}
+	outputJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": recoveryCodes})
+}
+
+func (controller *MfaController) disableHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "mfaDisableHandler")
+	defer closeTimer(timer)
+
+	userId, isAuthorized := controller.checkRequest(w, r)
+	if !isAuthorized {
+		return
+	}
+
+	if err := controller.mfaManager.Disable(strconv.FormatInt(userId, 10), r.FormValue("code")); err != nil {
+		outputMfaError(w, err)
+		return
+	}
+
+	logger.GetLogger().Info(fmt.Sprintf("Two-factor authentication disabled for user %d", userId))
+	outputJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+func (controller *MfaController) checkRequest(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if !controller.mfaManager.IsEnabled() {
+		outputMfaError(w, MfaDisabledError)
+		return 0, false
+	}
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return 0, false
+	}
+
+	_, userId, isAuthenticated := authenticateBearer(controller.redisStorage, controller.tokenBinding, w, r)
+	return userId, isAuthenticated
+}
+
+func outputMfaError(w http.ResponseWriter, err error) {
+	switch err {
+	case MfaDisabledError:
+		outputError(w, http.StatusNotFound, ErrorMfaDisabled, err.Error())
+	case MfaNotEnrolledError:
+		outputError(w, http.StatusBadRequest, ErrorMfaNotEnrolled, err.Error())
+	case MfaAlreadyEnabledError:
+		outputError(w, http.StatusConflict, ErrorMfaAlreadyEnabled, err.Error())
+	case InvalidMfaCodeError:
+		outputError(w, http.StatusBadRequest, ErrorInvalidMfaCode, err.Error())
+	default:
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+	}
+}
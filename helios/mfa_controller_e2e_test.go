@@ -0,0 +1,41 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestE2eMfaOtpGrantInvalidToken(t *testing.T) {
+	skipInShortMode(t)
+
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":    {GrantTypeMfaOtp},
+		"client_id":     {TestClientId},
+		"client_secret": {TestClientSecret},
+		"mfa_token":     {"InvalidToken"},
+		"otp":           {"123456"},
+	}, nil, t)
+	//The grant is unsupported when two-factor authentication is disabled
+	if errorCode := getJsonString(unmarshall(body, t), "error", t); errorCode != osin.E_INVALID_GRANT &&
+		errorCode != osin.E_UNSUPPORTED_GRANT_TYPE {
+		t.Fatal(fmt.Sprintf("Invalid mfa_token accepted: %s", string(body)))
+	}
+}
+
+func TestE2eMfaOtpGrantMissingCode(t *testing.T) {
+	skipInShortMode(t)
+
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":    {GrantTypeMfaOtp},
+		"client_id":     {TestClientId},
+		"client_secret": {TestClientSecret},
+		"mfa_token":     {"InvalidToken"},
+	}, nil, t)
+	if errorCode := getJsonString(unmarshall(body, t), "error", t); errorCode != osin.E_INVALID_REQUEST &&
+		errorCode != osin.E_UNSUPPORTED_GRANT_TYPE {
+		t.Fatal(fmt.Sprintf("Missing otp accepted: %s", string(body)))
+	}
+}
@@ -0,0 +1,264 @@
+package helios
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/mfa"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	MfaTokenLength = 32
+)
+
+var (
+	MfaDisabledError         = errors.New("Two-factor authentication is disabled")
+	MfaNotEnrolledError      = errors.New("Two-factor authentication has not been set up")
+	MfaAlreadyEnabledError   = errors.New("Two-factor authentication is already enabled")
+	InvalidMfaCodeError      = errors.New("The code is invalid or has already been used")
+	InvalidMfaTokenError     = errors.New("The mfa_token is invalid, has expired or has been used too many times")
+	MfaAttemptsExceededError = errors.New("Too many codes have been tried, try again later")
+)
+
+//TOTP enrollment and verification of the second factor. All the operations fail with MfaDisabledError
+//if no encryption key is configured.
+type MfaManager struct {
+	redisStorage             *storage.RedisStorage
+	cipher                   *mfa.Cipher
+	issuer                   string
+	challengeExpirationInSec int
+	maxChallengeAttempts     int
+	recoveryCodeCount        int
+}
+
+func NewMfaManager(redisStorage *storage.RedisStorage, mfaConfig *config.MfaConfig) (*MfaManager, error) {
+	manager := &MfaManager{
+		redisStorage:             redisStorage,
+		issuer:                   mfaConfig.Issuer,
+		challengeExpirationInSec: mfaConfig.MfaTokenExpirationInSec,
+		maxChallengeAttempts:     mfaConfig.MaxMfaTokenAttempts,
+		recoveryCodeCount:        mfaConfig.RecoveryCodeCount,
+	}
+	if mfaConfig.EncryptionKey != "" {
+		cipher, err := mfa.NewCipher(mfaConfig.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		manager.cipher = cipher
+	}
+	return manager, nil
+}
+
+func (manager *MfaManager) IsEnabled() bool {
+	return manager.cipher != nil
+}
+
+//Users have to pass the second factor at login once they have confirmed the enrollment
+func (manager *MfaManager) IsRequired(userId string) (bool, error) {
+	if !manager.IsEnabled() {
+		return false, nil
+	}
+	enrollment, err := manager.redisStorage.GetMfaEnrollment(userId)
+	if err != nil {
+		return false, err
+	}
+	return enrollment != nil && enrollment.IsConfirmed, nil
+}
+
+//Generates a new secret for the user and returns it together with the otpauth:// URI for the authenticator apps.
+//The enrollment has to be confirmed with a code before it's used.
+func (manager *MfaManager) Enroll(userId string, accountName string) (string, string, error) {
+	if !manager.IsEnabled() {
+		return "", "", MfaDisabledError
+	}
+	if isRequired, err := manager.IsRequired(userId); err != nil || isRequired {
+		if err == nil {
+			err = MfaAlreadyEnabledError
+		}
+		return "", "", err
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encryptedSecret, err := manager.cipher.Encrypt(secret)
+	if err != nil {
+		return "", "", err
+	}
+	if err = manager.redisStorage.SaveMfaEnrollment(userId, &storage.MfaEnrollment{Secret: encryptedSecret}); err != nil {
+		return "", "", err
+	}
+	return secret, mfa.KeyURI(manager.issuer, accountName, secret), nil
+}
+
+//Enables the second factor once the user has proven to have set up the authenticator app.
+//Returns the recovery codes.
+func (manager *MfaManager) Confirm(userId string, code string) ([]string, error) {
+	enrollment, secret, err := manager.loadEnrollment(userId)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.IsConfirmed {
+		return nil, MfaAlreadyEnabledError
+	}
+	if isValid, err := manager.verifyTotp(userId, secret, code); err != nil || !isValid {
+		return nil, manager.invalidCodeError(err)
+	}
+
+	enrollment.IsConfirmed = true
+	if err = manager.redisStorage.SaveMfaEnrollment(userId, enrollment); err != nil {
+		return nil, err
+	}
+	return manager.setRecoveryCodes(userId)
+}
+
+//Replaces the recovery codes of the user, a valid code or recovery code is required
+func (manager *MfaManager) RegenerateRecoveryCodes(userId string, code string) ([]string, error) {
+	if isValid, err := manager.Verify(userId, code); err != nil || !isValid {
+		return nil, manager.invalidCodeError(err)
+	}
+	return manager.setRecoveryCodes(userId)
+}
+
+//Turns the second factor off, a valid code or recovery code is required
+func (manager *MfaManager) Disable(userId string, code string) error {
+	if isValid, err := manager.Verify(userId, code); err != nil || !isValid {
+		return manager.invalidCodeError(err)
+	}
+	return manager.redisStorage.DeleteMfaEnrollment(userId)
+}
+
+//Checks the TOTP code or one of the recovery codes of the user. Each code can be used only once.
+func (manager *MfaManager) Verify(userId string, code string) (bool, error) {
+	enrollment, secret, err := manager.loadEnrollment(userId)
+	if err != nil {
+		return false, err
+	}
+	if !enrollment.IsConfirmed {
+		return false, MfaNotEnrolledError
+	}
+
+	if mfa.IsRecoveryCode(code) {
+		return manager.redisStorage.UseMfaRecoveryCode(userId, mfa.HashRecoveryCode(code))
+	}
+	return manager.verifyTotp(userId, secret, code)
+}
+
+//Starts the second step of the login and returns the mfa_token identifying it
+func (manager *MfaManager) NewChallenge(userId string, clientId string) (string, error) {
+	tokenBytes := make([]byte, MfaTokenLength/2)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	challenge := &storage.MfaChallenge{UserId: userId, ClientId: clientId}
+	if err := manager.redisStorage.SaveMfaChallenge(token, challenge, manager.challengeExpirationInSec); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+//Completes the login started for the client with the code. Returns the id of the user logging in.
+func (manager *MfaManager) CompleteChallenge(token string, clientId string, code string) (string, error) {
+	challenge, err := manager.redisStorage.LoadMfaChallenge(token)
+	if err != nil {
+		return "", err
+	}
+	if challenge == nil || challenge.ClientId != clientId {
+		return "", InvalidMfaTokenError
+	}
+
+	attempts, err := manager.redisStorage.AddMfaChallengeAttempt(token, manager.challengeExpirationInSec)
+	if err != nil {
+		return "", err
+	}
+	if !manager.isChallengeAttemptAllowed(attempts) {
+		manager.redisStorage.DeleteMfaChallenge(token)
+		return "", InvalidMfaTokenError
+	}
+
+	if isValid, err := manager.VerifyLogin(challenge.UserId, code); err != nil || !isValid {
+		return "", manager.invalidCodeError(err)
+	}
+	if err = manager.redisStorage.DeleteMfaChallenge(token); err != nil {
+		return "", err
+	}
+	return challenge.UserId, nil
+}
+
+//Checks the code the user logs in with, passed with the password or completing the challenge. The codes
+//which haven't been accepted are counted per user, so new mfa_tokens don't give more attempts. Once
+//max-mfa-token-attempts of them are used up, MfaAttemptsExceededError is returned until no code has been
+//tried for mfa-token-expiration-in-sec.
+func (manager *MfaManager) VerifyLogin(userId string, code string) (bool, error) {
+	attempts, err := manager.redisStorage.AddMfaAttempt(userId, manager.challengeExpirationInSec)
+	if err != nil {
+		return false, err
+	}
+	if !manager.isChallengeAttemptAllowed(attempts) {
+		return false, MfaAttemptsExceededError
+	}
+
+	isValid, err := manager.Verify(userId, code)
+	if err == nil && isValid {
+		err = manager.redisStorage.RemoveMfaAttempt(userId)
+	}
+	return isValid, err
+}
+
+//The attempts count the current one too, the challenge is dropped once they are used up
+func (manager *MfaManager) isChallengeAttemptAllowed(attempts int) bool {
+	return attempts <= manager.maxChallengeAttempts
+}
+
+func (manager *MfaManager) loadEnrollment(userId string) (*storage.MfaEnrollment, string, error) {
+	if !manager.IsEnabled() {
+		return nil, "", MfaDisabledError
+	}
+	enrollment, err := manager.redisStorage.GetMfaEnrollment(userId)
+	if err != nil {
+		return nil, "", err
+	}
+	if enrollment == nil {
+		return nil, "", MfaNotEnrolledError
+	}
+
+	secret, err := manager.cipher.Decrypt(enrollment.Secret)
+	if err != nil {
+		return nil, "", err
+	}
+	return enrollment, secret, nil
+}
+
+func (manager *MfaManager) verifyTotp(userId string, secret string, code string) (bool, error) {
+	counter, isValid := mfa.ValidateCode(secret, code, time.Now())
+	if !isValid {
+		return false, nil
+	}
+	//The code is accepted during the periods around its own one
+	return manager.redisStorage.MarkMfaCodeUsed(userId, counter, (2*mfa.AllowedSkew+1)*mfa.PeriodInSec)
+}
+
+func (manager *MfaManager) setRecoveryCodes(userId string) ([]string, error) {
+	codes, hashes, err := mfa.GenerateRecoveryCodes(manager.recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err = manager.redisStorage.SetMfaRecoveryCodes(userId, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (manager *MfaManager) invalidCodeError(err error) error {
+	if err != nil {
+		return err
+	}
+	return InvalidMfaCodeError
+}
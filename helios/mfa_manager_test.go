@@ -0,0 +1,33 @@
+package helios
+
+import (
+	"testing"
+
+	"github.com/Wikia/helios/config"
+)
+
+func TestMfaManagerChallengeAttempts(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		attempts    int
+		expected    bool
+	}{
+		{3, 1, true},
+		{3, 3, true},
+		{3, 4, false},
+		{3, 10, false},
+		{1, 1, true},
+		{1, 2, false},
+	}
+
+	for _, test := range tests {
+		manager, err := NewMfaManager(nil, &config.MfaConfig{MaxMfaTokenAttempts: test.maxAttempts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manager.isChallengeAttemptAllowed(test.attempts) != test.expected {
+			t.Errorf("Wrong result for attempt %d of %d. Expected: %t", test.attempts, test.maxAttempts,
+				test.expected)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package helios
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	TokenEndpointAuthMethodTlsClientAuth           = "tls_client_auth"
+	TokenEndpointAuthMethodSelfSignedTlsClientAuth = "self_signed_tls_client_auth"
+)
+
+//Serves TLS with optional client certificates. With the client CAs the certificates have to be issued
+//by them, otherwise any certificate is requested and only its thumbprint can be trusted.
+func newTlsConfig(serverConfig *config.ServerConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ClientAuth: tls.RequestClientCert}
+	if serverConfig.TlsClientCaFile == "" {
+		return tlsConfig, nil
+	}
+
+	caPEM, err := ioutil.ReadFile(serverConfig.TlsClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("No certificates in " + serverConfig.TlsClientCaFile)
+	}
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+//The mutual TLS methods are offered only when helios serves TLS itself, the client assertions
+//only when helios knows its URL they have to be issued for
+func getTokenEndpointAuthMethods(serverConfig *config.ServerConfig) []string {
+	methods := append([]string{}, tokenEndpointAuthMethods...)
+	if serverConfig.TlsCertFile != "" {
+		methods = append(methods, TokenEndpointAuthMethodTlsClientAuth, TokenEndpointAuthMethodSelfSignedTlsClientAuth)
+	}
+	if serverConfig.Issuer != "" {
+		methods = append(methods, TokenEndpointAuthMethodPrivateKeyJwt, TokenEndpointAuthMethodClientSecretJwt)
+	}
+	return methods
+}
+
+func getClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+//x5t#S256 of the certificate: base64url encoded SHA-256 of its DER encoding
+func getCertificateThumbprint(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func usesTlsClientAuth(client osin.Client) bool {
+	method := getTokenEndpointAuthMethod(client)
+	return method == TokenEndpointAuthMethodTlsClientAuth || method == TokenEndpointAuthMethodSelfSignedTlsClientAuth
+}
+
+//Checks the certificate of the request against the one registered for the client (RFC 8705 section 2)
+func isCertificateAuthenticated(client osin.Client, r *http.Request) bool {
+	heliosClient, isHeliosClient := client.(*storage.Client)
+	certificate := getClientCertificate(r)
+	if !isHeliosClient || certificate == nil {
+		return false
+	}
+
+	switch heliosClient.TokenEndpointAuthMethod {
+	case TokenEndpointAuthMethodTlsClientAuth:
+		//The subject is trusted only when the certificate was issued by one of the client CAs
+		return len(r.TLS.VerifiedChains) > 0 && heliosClient.TlsClientAuthSubjectDn != "" &&
+			certificate.Subject.String() == heliosClient.TlsClientAuthSubjectDn
+	case TokenEndpointAuthMethodSelfSignedTlsClientAuth:
+		return heliosClient.TlsClientCertificateThumbprint != "" &&
+			getCertificateThumbprint(certificate) == heliosClient.TlsClientCertificateThumbprint
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestE2eClientIdWithoutCertificate(t *testing.T) {
+	skipInShortMode(t)
+
+	//Only the clients presenting a certificate may leave out the secret
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type": {"password"},
+		"client_id":  {TestClientId},
+		"username":   {"test"},
+		"password":   {"test"},
+	}, nil, t)
+	objMap := unmarshall(body, t)
+	if getJsonString(objMap, "access_token", t) != "" || getJsonString(objMap, "error", t) != osin.E_INVALID_REQUEST {
+		t.Fatal(fmt.Sprintf("Client authenticated without the secret: %s", string(body)))
+	}
+}
@@ -0,0 +1,80 @@
+package helios
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/storage"
+)
+
+func newTestCertificate(commonName string, t *testing.T) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error creating the certificate", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Error parsing the certificate", err)
+	}
+	return certificate
+}
+
+func TestIsCertificateAuthenticated(t *testing.T) {
+	certificate := newTestCertificate("app", t)
+	otherCertificate := newTestCertificate("other", t)
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate},
+		VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	selfSigned := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	tlsClient := &storage.Client{Id: "app", TokenEndpointAuthMethod: TokenEndpointAuthMethodTlsClientAuth,
+		TlsClientAuthSubjectDn: "CN=app"}
+	selfSignedClient := &storage.Client{Id: "app", TokenEndpointAuthMethod: TokenEndpointAuthMethodSelfSignedTlsClientAuth,
+		TlsClientCertificateThumbprint: getCertificateThumbprint(certificate)}
+
+	tests := []struct {
+		name     string
+		client   osin.Client
+		tls      *tls.ConnectionState
+		expected bool
+	}{
+		{"tls_client_auth", tlsClient, verified, true},
+		{"tls_client_auth without a certificate", tlsClient, nil, false},
+		{"tls_client_auth with an unverified certificate", tlsClient, selfSigned, false},
+		{"tls_client_auth with another subject", tlsClient, &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{otherCertificate},
+			VerifiedChains:   [][]*x509.Certificate{{otherCertificate}}}, false},
+		{"tls_client_auth without a subject DN", &storage.Client{Id: "app",
+			TokenEndpointAuthMethod: TokenEndpointAuthMethodTlsClientAuth}, verified, false},
+		{"self_signed_tls_client_auth", selfSignedClient, selfSigned, true},
+		{"self_signed_tls_client_auth without a certificate", selfSignedClient, nil, false},
+		{"self_signed_tls_client_auth with another certificate", selfSignedClient, &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{otherCertificate}}, false},
+		{"self_signed_tls_client_auth without a thumbprint", &storage.Client{Id: "app",
+			TokenEndpointAuthMethod: TokenEndpointAuthMethodSelfSignedTlsClientAuth}, selfSigned, false},
+		{"client_secret_basic", &storage.Client{Id: "app", TlsClientAuthSubjectDn: "CN=app"}, verified, false},
+		{"client of another storage", &osin.DefaultClient{Id: "app"}, verified, false},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", TestIssuer+TokenEndpointPath, nil)
+		r.TLS = test.tls
+		if isCertificateAuthenticated(test.client, r) != test.expected {
+			t.Errorf("Wrong result for %s. Expected: %t", test.name, test.expected)
+		}
+	}
+}
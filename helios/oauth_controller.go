@@ -3,6 +3,8 @@ package helios
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/RangelReale/osin"
 	"github.com/Wikia/go-commons/logger"
@@ -12,31 +14,70 @@ import (
 	"github.com/influxdb/influxdb/client"
 )
 
-type OAuthController struct {
-	server         *osin.Server
-	userStorage    *models.UserStorage
-	redisStorage   *storage.RedisStorage
-	influxdbClient *client.Client
+const (
+	TokenEndpointPath = "/token"
+	UserInfoPath      = "/userinfo"
+	IntrospectPath    = "/info/introspect"
+)
 
-	allowMultipleAccessTokens bool
+const (
+	ErrorUserBlocked = "user_blocked"
+	ErrorIPBlocked   = "ip_blocked"
+	ErrorMfaRequired = "mfa_required"
+	ErrorInvalidOtp  = "invalid_otp"
+)
+
+type OAuthController struct {
+	server              *ReloadableServer
+	userStorage         *models.UserStorage
+	userStatusChecker   *models.UserStatusChecker
+	blockChecker        *models.BlockChecker
+	emailStatusChecker  *models.EmailStatusChecker
+	clientIpHeader      string
+	redisStorage        *storage.RedisStorage
+	mfaManager          *MfaManager
+	clientKeyResolver   *ClientKeyResolver
+	clientAuthenticator *ClientAuthenticator
+	tokenBinding        *TokenBinding
+	issuer              string
+	jwtMaxLifetime      time.Duration
+	jwtClockSkew        time.Duration
+	influxdbClient      *client.Client
 }
 
 func NewOAuthController(
 	influxdbClient *client.Client,
-	server *osin.Server,
+	server *ReloadableServer,
 	storageFactory *models.StorageFactory,
 	redisStorage *storage.RedisStorage,
-	serverConfig *config.ServerConfig) *OAuthController {
+	mfaManager *MfaManager,
+	clientKeyResolver *ClientKeyResolver,
+	clientAuthenticator *ClientAuthenticator,
+	tokenBinding *TokenBinding,
+	serverConfig *config.ServerConfig,
+	jwtAssertionConfig *config.JwtAssertionConfig) *OAuthController {
 
 	controller := new(OAuthController)
 	controller.influxdbClient = influxdbClient
 	controller.userStorage = storageFactory.GetUserStorage()
+	controller.userStatusChecker = storageFactory.GetUserStatusChecker()
+	controller.blockChecker = storageFactory.GetBlockChecker()
+	controller.emailStatusChecker = storageFactory.GetEmailStatusChecker()
+	controller.clientIpHeader = serverConfig.ClientIpHeader
 	controller.redisStorage = redisStorage
+	controller.mfaManager = mfaManager
+	controller.clientKeyResolver = clientKeyResolver
+	controller.clientAuthenticator = clientAuthenticator
+	controller.tokenBinding = tokenBinding
+	controller.issuer = serverConfig.Issuer
+	controller.jwtMaxLifetime = time.Duration(jwtAssertionConfig.MaxLifetimeInSec) * time.Second
+	controller.jwtClockSkew = time.Duration(jwtAssertionConfig.ClockSkewInSec) * time.Second
 	controller.server = server
-	controller.allowMultipleAccessTokens = serverConfig.AllowMultipleAccessTokens
 
 	http.HandleFunc("/info", controller.infoHandler)
-	http.HandleFunc("/token", controller.tokenHandler)
+	http.HandleFunc(TokenEndpointPath, controller.tokenHandler)
+	http.HandleFunc(UserInfoPath, controller.userInfoHandler)
+	http.HandleFunc(IntrospectPath, controller.introspectHandler)
 
 	return controller
 }
@@ -45,33 +86,219 @@ func (controller *OAuthController) infoHandler(w http.ResponseWriter, r *http.Re
 	timer := createTimerForAPICall(controller.influxdbClient, "infoHandler")
 	defer closeTimer(timer)
 
-	resp := controller.server.NewResponse()
+	server := controller.server.Get()
+	resp := server.NewResponse()
 	defer resp.Close()
 
-	if ir := controller.server.HandleInfoRequest(resp, r); ir != nil {
-		controller.server.FinishInfoRequest(resp, r, ir)
+	if ir := server.HandleInfoRequest(resp, r); ir != nil {
+		server.FinishInfoRequest(resp, r, ir)
 		resp.Output["user_id"] = ir.AccessData.UserData
+		controller.addConfirmation(resp, r, ir.AccessData)
+		if !resp.IsError {
+			controller.addTokenExchange(resp, ir.AccessData)
+		}
+		if !resp.IsError {
+			//The token is valid without the MySQL data, so its lookups don't fail the request
+			controller.addEmailStatus(resp, ir.AccessData)
+			controller.addBlockStatus(resp, ir.AccessData)
+		}
+	}
+	if resp.InternalError != nil {
+		logger.GetLogger().ErrorErr(resp.InternalError)
+	}
+	osin.OutputJSON(resp, w, r)
+}
+
+//Returns the user the bearer access token was issued to
+func (controller *OAuthController) userInfoHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "userInfoHandler")
+	defer closeTimer(timer)
+
+	resp := controller.server.Get().NewResponse()
+	defer resp.Close()
+
+	if accessData := controller.loadBearerAccess(resp, r); accessData != nil {
+		userId, err := strconv.ParseInt(fmt.Sprintf("%v", accessData.UserData), 10, 64)
+		if err != nil {
+			resp.SetError(osin.E_INVALID_REQUEST, "The access token wasn't issued to a user")
+		} else if user, err := controller.userStorage.FindById(userId); err != nil {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+			resp.InternalError = err
+		} else if user == nil {
+			resp.SetError(osin.E_INVALID_GRANT, models.UserStatusDescription(models.UserStatusDeleted))
+		} else {
+			resp.Output["sub"] = accessData.UserData
+			resp.Output["name"] = user.Name
+			resp.Output["email"] = user.Email
+			resp.Output["email_verified"] = user.IsEmailConfirmed()
+			controller.addBlockStatus(resp, accessData)
+		}
+	}
+	if resp.InternalError != nil {
+		logger.GetLogger().ErrorErr(resp.InternalError)
 	}
 	osin.OutputJSON(resp, w, r)
 }
 
-func (controller *OAuthController) tokenHandlerPassword(ar *osin.AccessRequest) error {
-	user, err := controller.userStorage.FindByName(ar.Username, false)
+//Loads the access token passed in the Authorization header or the access_token parameter
+func (controller *OAuthController) loadBearerAccess(resp *osin.Response, r *http.Request) *osin.AccessData {
+	token := getBearerToken(r)
+	if token == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		return nil
+	}
+
+	accessData, err := resp.Storage.LoadAccess(token)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+		return nil
+	}
+	if accessData == nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		return nil
+	}
+	if accessData.IsExpired() {
+		resp.SetError(osin.E_INVALID_GRANT, "")
+		return nil
+	}
+	if _, isConfirmed, err := controller.tokenBinding.CheckConfirmation(token, r); !isConfirmed {
+		controller.setConfirmationError(resp, err)
+		return nil
+	}
+	return accessData
+}
+
+//Adds the key the token is bound to, the token info is given only to the holder of the key
+func (controller *OAuthController) addConfirmation(resp *osin.Response, r *http.Request, accessData *osin.AccessData) {
+	confirmation, isConfirmed, err := controller.tokenBinding.CheckConfirmation(accessData.AccessToken, r)
+	if !isConfirmed {
+		controller.setConfirmationError(resp, err)
+		return
+	}
+	if confirmation != nil {
+		resp.Output["cnf"] = confirmation
+	}
+}
+
+func (controller *OAuthController) setConfirmationError(resp *osin.Response, err error) {
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+	resp.SetError(ErrorInvalidToken, "The access token is bound to another key")
+}
+
+//Adds whether the user the token was issued to has confirmed the email address, left out when it can't be loaded
+func (controller *OAuthController) addEmailStatus(resp *osin.Response, accessData *osin.AccessData) {
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", accessData.UserData), 10, 64)
+	if err != nil {
+		return
+	}
+
+	isConfirmed, err := controller.emailStatusChecker.IsEmailConfirmed(userId)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return
+	}
+	resp.Output["email_confirmed"] = isConfirmed
+}
+
+//Adds the block of the user the token was issued to, the IP blocks are only checked when tokens are granted.
+//The block is left out when it can't be loaded.
+func (controller *OAuthController) addBlockStatus(resp *osin.Response, accessData *osin.AccessData) {
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", accessData.UserData), 10, 64)
+	if err != nil {
+		return
+	}
+
+	block, err := controller.blockChecker.GetUserBlock(userId)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return
+	}
+
+	resp.Output["blocked"] = block != nil
+	if block != nil {
+		resp.Output["block"] = map[string]interface{}{
+			"by":     block.ByText,
+			"reason": block.Reason,
+			"expiry": block.FormattedExpiry(),
+		}
+	}
+}
+
+//Denies the request if the user has been deleted or disabled, or the password has expired, since the token
+//it's based on was issued.
+//Returns true if the request may proceed.
+func (controller *OAuthController) checkUserStatus(resp *osin.Response, userId int64) (bool, error) {
+	status, err := controller.userStatusChecker.GetStatus(userId)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return false, err
+	}
+	if status != models.UserStatusOk {
+		logger.GetLogger().Debug(fmt.Sprintf("checkUserStatus: user %d not allowed to get tokens: %s",
+			userId, models.UserStatusDescription(status)))
+		resp.SetError(osin.E_INVALID_GRANT, models.UserStatusDescription(status))
+		return false, nil
+	}
+	return true, nil
+}
+
+//Denies the request if the user or the IP it comes from is blocked. Returns true if the request may proceed.
+func (controller *OAuthController) checkBlocks(resp *osin.Response, r *http.Request, userId int64) (bool, error) {
+	block, err := controller.blockChecker.GetUserBlock(userId)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return false, err
+	}
+	if block != nil {
+		logger.GetLogger().Debug(fmt.Sprintf("checkBlocks: user %d is blocked", userId))
+		resp.SetError(ErrorUserBlocked, block.Description())
+		return false, nil
+	}
+
+	clientIP := getClientIP(r, controller.clientIpHeader)
+	block, err = controller.blockChecker.GetIPBlock(clientIP)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return false, err
+	}
+	if block != nil {
+		logger.GetLogger().Debug(fmt.Sprintf("checkBlocks: IP %s of user %d is blocked", clientIP, userId))
+		resp.SetError(ErrorIPBlocked, block.Description())
+		return false, nil
+	}
+	return true, nil
+}
+
+func (controller *OAuthController) tokenHandlerPassword(resp *osin.Response, r *http.Request, ar *osin.AccessRequest) error {
+	user, err := controller.userStorage.FindByLogin(ar.Username)
+	if err == models.MultipleAccountsForEmailError {
+		resp.SetError(osin.E_INVALID_REQUEST, err.Error())
+		return nil
+	}
 	if err == nil && user != nil && user.IsValidPassword(ar.Password) {
-		ar.UserData = fmt.Sprintf("%d", user.Id)
-		ar.Authorized = true
-		if !controller.allowMultipleAccessTokens {
-			var accessData *osin.AccessData
-			userId := fmt.Sprintf("%d", user.Id)
-			accessData, err = controller.redisStorage.GetAccessForUserId(userId)
-			if err == nil && accessData != nil {
-				ar.ForceAccessData = accessData //Reuse previous token if it exists
-			}
+		if user.IsPasswordResetRequired() {
+			logger.GetLogger().Debug(fmt.Sprintf("tokenHandlerPassword: password of user %d has expired", user.Id))
+			resp.SetError(osin.E_INVALID_GRANT, models.UserStatusDescription(models.UserStatusPasswordResetRequired))
+			return nil
+		}
+		var isAllowed bool
+		if isAllowed, err = controller.checkBlocks(resp, r, user.Id); !isAllowed {
+			return err
+		}
+		if isAllowed, err = controller.checkMfa(resp, r, ar, user.Id); !isAllowed {
+			return err
 		}
+
+		err = controller.grantUser(ar, user.Id)
 	} else {
 		ar.Authorized = false
 		if user == nil && err == nil {
-			logger.GetLogger().Debug("tokenHandlerPassword: user with the given name not found")
+			logger.GetLogger().Debug("tokenHandlerPassword: user with the given name or confirmed email not found")
 		} else if user != nil {
 			logger.GetLogger().Debug("tokenHandlerPassword: incorrect password provided")
 		}
@@ -80,23 +307,300 @@ func (controller *OAuthController) tokenHandlerPassword(ar *osin.AccessRequest)
 	return err
 }
 
+//Users with two-factor authentication have to pass the otp parameter with the password, or complete
+//the login with the mfa-otp grant and the mfa_token returned in the mfa_required error.
+//Returns true if the request may proceed.
+func (controller *OAuthController) checkMfa(resp *osin.Response, r *http.Request, ar *osin.AccessRequest, userId int64) (bool, error) {
+	userIdString := strconv.FormatInt(userId, 10)
+	isRequired, err := controller.mfaManager.IsRequired(userIdString)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return false, err
+	}
+	if !isRequired {
+		return true, nil
+	}
+
+	if otp := r.Form.Get("otp"); otp != "" {
+		isValid, err := controller.mfaManager.VerifyLogin(userIdString, otp)
+		if err == MfaAttemptsExceededError {
+			resp.SetError(ErrorInvalidOtp, err.Error())
+			return false, nil
+		}
+		if err != nil {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+			return false, err
+		}
+		if !isValid {
+			logger.GetLogger().Debug(fmt.Sprintf("checkMfa: invalid code provided for user %d", userId))
+			resp.SetError(ErrorInvalidOtp, InvalidMfaCodeError.Error())
+		}
+		return isValid, nil
+	}
+
+	token, err := controller.mfaManager.NewChallenge(userIdString, ar.Client.GetId())
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return false, err
+	}
+	resp.SetError(ErrorMfaRequired, "The second factor is required")
+	resp.Output["mfa_token"] = token
+	return false, nil
+}
+
+//Completes the login of a user with two-factor authentication
+func (controller *OAuthController) tokenHandlerMfaOtp(resp *osin.Response, r *http.Request, ar *osin.AccessRequest) error {
+	token, otp := r.Form.Get("mfa_token"), r.Form.Get("otp")
+	if token == "" || otp == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "mfa_token and otp are required")
+		return nil
+	}
+
+	userIdString, err := controller.mfaManager.CompleteChallenge(token, ar.Client.GetId(), otp)
+	switch err {
+	case nil:
+	case InvalidMfaTokenError:
+		resp.SetError(osin.E_INVALID_GRANT, err.Error())
+		return nil
+	case InvalidMfaCodeError, MfaAttemptsExceededError:
+		resp.SetError(ErrorInvalidOtp, err.Error())
+		return nil
+	default:
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+
+	userId, err := strconv.ParseInt(userIdString, 10, 64)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	if isAllowed, err := controller.checkBlocks(resp, r, userId); !isAllowed {
+		return err
+	}
+
+	return controller.grantUser(ar, userId)
+}
+
+//Polling of the device authorization grant, the device gets the tokens once the user has approved it
+func (controller *OAuthController) tokenHandlerDeviceCode(resp *osin.Response, r *http.Request, ar *osin.AccessRequest) error {
+	deviceCode := r.Form.Get("device_code")
+	if deviceCode == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "device_code is required")
+		return nil
+	}
+
+	authorization, err := controller.redisStorage.LoadDeviceAuthorization(deviceCode)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+
+	switch getDevicePollState(authorization, ar.Client.GetId()) {
+	case devicePollExpired:
+		resp.SetError(ErrorExpiredToken, "The device_code has expired")
+		return nil
+	case devicePollOtherClient:
+		resp.SetError(osin.E_INVALID_GRANT, "")
+		return nil
+	case devicePollDenied:
+		controller.redisStorage.ClaimDeviceAuthorization(deviceCode, authorization)
+		resp.SetError(osin.E_ACCESS_DENIED, "The user has denied the authorization")
+		return nil
+	case devicePollPending:
+		isInTime, err := controller.redisStorage.MarkDevicePolled(deviceCode, authorization.Interval)
+		if err != nil {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+			return err
+		}
+		if !isInTime {
+			authorization.Interval += slowDownIntervalIncreaseInSec
+			resp.SetError(ErrorSlowDown, fmt.Sprintf("Poll at most every %d seconds", authorization.Interval))
+			//The interval isn't raised if the user has decided in the meantime, the next poll gets the result
+			_, err = controller.redisStorage.UpdatePendingDeviceAuthorization(deviceCode, authorization)
+			return err
+		}
+		resp.SetError(ErrorAuthorizationPending, "")
+		return nil
+	}
+
+	//The device code is used up, whether the user may get tokens or not. Of concurrent polls only
+	//the one which has removed it gets the tokens.
+	isClaimed, err := controller.redisStorage.ClaimDeviceAuthorization(deviceCode, authorization)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	if !isClaimed {
+		resp.SetError(osin.E_INVALID_GRANT, "The device_code has already been used")
+		return nil
+	}
+
+	userId, err := strconv.ParseInt(authorization.UserId, 10, 64)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	if isAllowed, err := controller.checkUserStatus(resp, userId); !isAllowed {
+		return err
+	}
+	if isAllowed, err := controller.checkBlocks(resp, r, userId); !isAllowed {
+		return err
+	}
+
+	ar.Scope = authorization.Scope
+	return controller.grantUser(ar, userId)
+}
+
+//What the poll of the device gets, depending on the decision of the user
+type devicePollState int
+
+const (
+	devicePollExpired devicePollState = iota
+	devicePollOtherClient
+	devicePollDenied
+	devicePollPending
+	devicePollApproved
+)
+
+func getDevicePollState(authorization *storage.DeviceAuthorization, clientId string) devicePollState {
+	switch {
+	case authorization == nil:
+		return devicePollExpired
+	case authorization.ClientId != clientId:
+		return devicePollOtherClient
+	case authorization.IsDenied:
+		return devicePollDenied
+	case authorization.UserId == "":
+		return devicePollPending
+	}
+	return devicePollApproved
+}
+
+func (controller *OAuthController) grantUser(ar *osin.AccessRequest, userId int64) error {
+	ar.UserData = fmt.Sprintf("%d", userId)
+	ar.Authorized = true
+	//The token requested with a DPoP proof is bound to its key, it can't be one issued before
+	if controller.tokenBinding.isDpopEnabled && getDpopProof(ar.HttpRequest) != "" {
+		return nil
+	}
+	if policy, _ := controller.redisStorage.GetTokenReusePolicy(ar.Client); policy == config.TokenReusePolicyReuse {
+		accessData, err := controller.redisStorage.GetAccessForUserId(fmt.Sprintf("%d", userId), ar.Client.GetId())
+		if err != nil || accessData == nil {
+			return err
+		}
+		confirmation, err := controller.redisStorage.LoadTokenConfirmation(accessData.AccessToken)
+		if err != nil {
+			return err
+		}
+		exchange, err := controller.redisStorage.LoadTokenExchange(accessData.AccessToken)
+		if err != nil {
+			return err
+		}
+		if isReusableToken(confirmation, exchange) {
+			ar.ForceAccessData = accessData //Reuse previous token if it exists
+		}
+	}
+	return nil
+}
+
+//Only the plain tokens of a login can be handed out again. The tokens bound to a certificate or a DPoP key
+//work only for their holder, and the exchanged ones are limited to an audience and carry the acting parties.
+func isReusableToken(confirmation *storage.TokenConfirmation, exchange *storage.TokenExchange) bool {
+	return confirmation == nil && exchange == nil
+}
+
+//The user could have been deleted, disabled or blocked since the refresh token was issued.
+//The refresh token bound to a key has to be presented with it, jkt is the key of the request's DPoP proof.
+func (controller *OAuthController) tokenHandlerRefresh(
+	resp *osin.Response, r *http.Request, ar *osin.AccessRequest, jkt string) error {
+
+	if !controller.tokenBinding.CheckRefreshToken(resp, r, ar.AccessData.RefreshToken, jkt) {
+		return resp.InternalError
+	}
+
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", ar.UserData), 10, 64)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		resp.SetError(osin.E_INVALID_GRANT, "")
+		return err
+	}
+
+	if isAllowed, err := controller.checkUserStatus(resp, userId); !isAllowed {
+		return err
+	}
+	if isAllowed, err := controller.checkBlocks(resp, r, userId); !isAllowed {
+		return err
+	}
+
+	ar.Authorized = true
+	return nil
+}
+
+//Registered clients may use only the grant types they were registered for
+func (controller *OAuthController) isGrantTypeAllowed(resp *osin.Response, ar *osin.AccessRequest) bool {
+	if client, isHeliosClient := ar.Client.(*storage.Client); isHeliosClient && !client.IsGrantTypeAllowed(string(ar.Type)) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "The client may not use the grant type")
+		return false
+	}
+	return true
+}
+
 func (controller *OAuthController) tokenHandler(w http.ResponseWriter, r *http.Request) {
 	timer := createTimerForAPICall(controller.influxdbClient, "tokenHandler")
 	defer closeTimer(timer)
 
-	resp := controller.server.NewResponse()
+	server := controller.server.Get()
+	resp := server.NewResponse()
 	defer resp.Close()
 
-	if ar := controller.server.HandleAccessRequest(resp, r); ar != nil {
+	var ar *osin.AccessRequest
+	if isCustomGrantType(r) {
+		ar = handleCustomAccessRequest(server, controller.clientAuthenticator, resp, r)
+	} else if controller.clientAuthenticator.AuthenticateOsinAccessRequest(resp, r) {
+		ar = server.HandleAccessRequest(resp, r)
+	}
+	if ar != nil && !controller.isGrantTypeAllowed(resp, ar) {
+		ar = nil
+	}
+	var jkt string
+	if ar != nil {
+		if jkt = controller.tokenBinding.CheckTokenRequest(resp, r); resp.IsError {
+			ar = nil
+		}
+	}
+
+	if ar != nil {
 		var err error
 		switch ar.Type {
 		case osin.PASSWORD:
-			err = controller.tokenHandlerPassword(ar)
+			err = controller.tokenHandlerPassword(resp, r, ar)
 		case osin.REFRESH_TOKEN:
-			ar.Authorized = true
+			err = controller.tokenHandlerRefresh(resp, r, ar, jkt)
+		case GrantTypeMfaOtp:
+			err = controller.tokenHandlerMfaOtp(resp, r, ar)
+		case GrantTypeDeviceCode:
+			err = controller.tokenHandlerDeviceCode(resp, r, ar)
+		case GrantTypeTokenExchange:
+			err = controller.tokenHandlerTokenExchange(resp, r, ar, jkt)
+		case osin.ASSERTION, GrantTypeJwtBearer:
+			err = controller.tokenHandlerAssertion(resp, r, ar)
 		}
 
-		controller.server.FinishAccessRequest(resp, r, ar)
+		if !resp.IsError && ar.Authorized {
+			controller.tokenBinding.Bind(server, resp, r, ar, jkt)
+		}
+		server.FinishAccessRequest(resp, r, ar)
+		if resp.InternalError == storage.RefreshTokenReusedError {
+			//The token family has been revoked by a concurrent reuse of the refresh token
+			resp.SetError(osin.E_INVALID_GRANT, "")
+		}
+		if !resp.IsError && jkt != "" {
+			resp.Output["token_type"] = TokenTypeDpop
+		}
+		if ar.Type == GrantTypeTokenExchange && !resp.IsError {
+			resp.Output["issued_token_type"] = TokenTypeAccessToken
+		}
 		if resp.InternalError != nil {
 			logger.GetLogger().ErrorErr(resp.InternalError)
 		} else if err == nil {
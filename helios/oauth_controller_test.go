@@ -0,0 +1,132 @@
+package helios
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/mfa"
+	"github.com/Wikia/helios/storage"
+)
+
+func TestGetDevicePollState(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization *storage.DeviceAuthorization
+		expected      devicePollState
+	}{
+		{"expired", nil, devicePollExpired},
+		{"other client", &storage.DeviceAuthorization{ClientId: "other"}, devicePollOtherClient},
+		{"other client approved", &storage.DeviceAuthorization{ClientId: "other", UserId: "1"}, devicePollOtherClient},
+		{"pending", &storage.DeviceAuthorization{ClientId: "client"}, devicePollPending},
+		{"denied", &storage.DeviceAuthorization{ClientId: "client", IsDenied: true}, devicePollDenied},
+		{"denied by user", &storage.DeviceAuthorization{ClientId: "client", UserId: "1", IsDenied: true}, devicePollDenied},
+		{"approved", &storage.DeviceAuthorization{ClientId: "client", UserId: "1"}, devicePollApproved},
+	}
+
+	for _, test := range tests {
+		if state := getDevicePollState(test.authorization, "client"); state != test.expected {
+			t.Errorf("Wrong state for %s. Expected: %d Actual: %d", test.name, test.expected, state)
+		}
+	}
+}
+
+func TestIsReusableToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		confirmation *storage.TokenConfirmation
+		exchange     *storage.TokenExchange
+		expected     bool
+	}{
+		{"plain token", nil, nil, true},
+		{"DPoP bound token", &storage.TokenConfirmation{Jkt: "jkt"}, nil, false},
+		{"certificate bound token", &storage.TokenConfirmation{X5tS256: "x5t"}, nil, false},
+		{"exchanged token", nil, &storage.TokenExchange{Audience: "service"}, false},
+		{"exchanged bound token", &storage.TokenConfirmation{Jkt: "jkt"}, &storage.TokenExchange{Audience: "service"}, false},
+	}
+
+	for _, test := range tests {
+		if isReusableToken(test.confirmation, test.exchange) != test.expected {
+			t.Errorf("Wrong result for %s. Expected: %t", test.name, test.expected)
+		}
+	}
+}
+
+//Returns the controller and the TOTP secret of the user with two-factor authentication
+func newTestMfaController(userId string, maxAttempts int, t *testing.T) (*OAuthController, string) {
+	redisStorage := newTestRedisStorage(t)
+	manager, err := NewMfaManager(redisStorage, &config.MfaConfig{EncryptionKey: strings.Repeat("0f", 32),
+		MfaTokenExpirationInSec: 300, MaxMfaTokenAttempts: maxAttempts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSecret, err := manager.cipher.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = redisStorage.SaveMfaEnrollment(userId, &storage.MfaEnrollment{Secret: encryptedSecret, IsConfirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	return &OAuthController{mfaManager: manager}, secret
+}
+
+func TestCheckMfaAttemptsLimit(t *testing.T) {
+	controller, secret := newTestMfaController("1", 3, t)
+	ar := &osin.AccessRequest{Client: &storage.Client{Id: "client"}}
+	now := time.Now()
+	validCode := func(periods int) string {
+		code, err := mfa.GenerateCode(secret, now.Add(time.Duration(periods*mfa.PeriodInSec)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name        string
+		otp         string
+		isAllowed   bool
+		description string
+	}{
+		//Accepted codes don't use up the attempts
+		{"valid code", validCode(0), true, ""},
+		{"1st wrong code", "000000", false, InvalidMfaCodeError.Error()},
+		{"2nd wrong code", "000000", false, InvalidMfaCodeError.Error()},
+		{"3rd wrong code", "000000", false, InvalidMfaCodeError.Error()},
+		{"valid code after the attempts are used up", validCode(1), false, MfaAttemptsExceededError.Error()},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", TestIssuer+TokenEndpointPath, nil)
+		r.Form = url.Values{"otp": {test.otp}}
+		resp := osin.NewResponse(&testClientStorage{})
+		isAllowed, err := controller.checkMfa(resp, r, ar, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAllowed != test.isAllowed {
+			t.Errorf("Wrong result for the %s. Expected: %t", test.name, test.isAllowed)
+		}
+		if !test.isAllowed && (resp.Output["error"] != ErrorInvalidOtp || resp.Output["error_description"] != test.description) {
+			t.Errorf("Wrong error for the %s: %v", test.name, resp.Output)
+		}
+	}
+
+	//The mfa_token of a new login doesn't give more attempts
+	token, err := controller.mfaManager.NewChallenge("1", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = controller.mfaManager.CompleteChallenge(token, "client", validCode(-1)); err != MfaAttemptsExceededError {
+		t.Errorf("Wrong error for the mfa_token after the attempts are used up: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	ErrorInvalidToken           = "invalid_token"
+	ErrorInvalidCurrentPassword = "invalid_current_password"
+)
+
+//Lets the users logged in through helios change their password
+type PasswordChangeController struct {
+	userStorage       *models.UserStorage
+	userStatusChecker *models.UserStatusChecker
+	redisStorage      *storage.RedisStorage
+	tokenBinding      *TokenBinding
+	passwordPolicy    *models.PasswordPolicy
+	influxdbClient    *client.Client
+}
+
+func NewPasswordChangeController(
+	influxdbClient *client.Client,
+	storageFactory *models.StorageFactory,
+	redisStorage *storage.RedisStorage,
+	tokenBinding *TokenBinding,
+	passwordPolicy *models.PasswordPolicy) *PasswordChangeController {
+
+	controller := new(PasswordChangeController)
+	controller.influxdbClient = influxdbClient
+	controller.userStorage = storageFactory.GetUserStorage()
+	controller.userStatusChecker = storageFactory.GetUserStatusChecker()
+	controller.redisStorage = redisStorage
+	controller.tokenBinding = tokenBinding
+	controller.passwordPolicy = passwordPolicy
+
+	http.HandleFunc("/password/change", controller.changeHandler)
+
+	return controller
+}
+
+//Sets the new password and revokes the other tokens of the user. The token used for the request is
+//revoked too unless keep_current_session is true.
+func (controller *PasswordChangeController) changeHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "changeHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+
+	accessData, userId, isAuthenticated := authenticateBearer(controller.redisStorage, controller.tokenBinding, w, r)
+	if !isAuthenticated {
+		return
+	}
+
+	user, err := controller.userStorage.FindByIdOnMaster(userId)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if user == nil {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, models.UserStatusDescription(models.UserStatusDeleted))
+		return
+	}
+	if !user.IsValidPassword(r.FormValue("current_password")) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidCurrentPassword, "The current password is not correct")
+		return
+	}
+
+	password := r.FormValue("new_password")
+	if err = controller.passwordPolicy.Validate(password, user.Name, user.Email); err != nil {
+		outputPasswordError(w, err)
+		return
+	}
+
+	if err = controller.userStorage.SetPassword(userId, password); err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	controller.userStatusChecker.Invalidate(userId)
+
+	keepCurrentSession, _ := strconv.ParseBool(r.FormValue("keep_current_session"))
+	if !keepCurrentSession {
+		accessData = nil
+	}
+	revoked, err := controller.redisStorage.RevokeUserTokensExcept(strconv.FormatInt(userId, 10), accessData)
+	if err != nil {
+		//The password has been changed already, the old tokens expire eventually
+		logger.GetLogger().ErrorErr(err)
+	}
+
+	logger.GetLogger().Info(fmt.Sprintf("Password of user %d changed, revoked %d token(s)", userId, revoked))
+	outputJSON(w, http.StatusOK, map[string]interface{}{"user_id": userId, "revoked_tokens": revoked})
+}
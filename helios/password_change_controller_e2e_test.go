@@ -0,0 +1,38 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	PasswordChangeEndpoint = "/password/change"
+)
+
+func changePassword(accessToken string, currentPassword string, newPassword string, t *testing.T) (int, []byte) {
+	return postResponse(ServerAddress+PasswordChangeEndpoint,
+		url.Values{"current_password": {currentPassword}, "new_password": {newPassword}},
+		map[string]string{"Authorization": "Bearer " + accessToken}, t)
+}
+
+func TestE2eChangePasswordInvalidToken(t *testing.T) {
+	skipInShortMode(t)
+
+	statusCode, body := changePassword("InvalidToken", TestPassword, "NewPassword1", t)
+	if statusCode != http.StatusUnauthorized {
+		t.Fatal(fmt.Sprintf("Invalid access token accepted: %d %s", statusCode, string(body)))
+	}
+}
+
+func TestE2eChangePasswordInvalidCurrentPassword(t *testing.T) {
+	skipInShortMode(t)
+
+	accessToken := getJsonString(getTokenResponse(TestUserName, TestPassword, t), "access_token", t)
+	statusCode, body := changePassword(accessToken, "InvalidPassword", "NewPassword1", t)
+	if statusCode != http.StatusBadRequest ||
+		getJsonString(unmarshall(body, t), "error", t) != ErrorInvalidCurrentPassword {
+		t.Fatal(fmt.Sprintf("Invalid current password accepted: %d %s", statusCode, string(body)))
+	}
+}
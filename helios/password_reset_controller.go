@@ -0,0 +1,193 @@
+package helios
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/mail"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	PasswordResetTokenLength = 32
+
+	ErrorPasswordResetDisabled = "password_reset_disabled"
+	ErrorInvalidResetToken     = "invalid_reset_token"
+	ErrorInvalidPassword       = "invalid_password"
+)
+
+const (
+	passwordResetSubject = "Reset your password"
+	passwordResetBody    = `Hi %s,
+
+someone, probably you, has requested a new password for your account.
+To set it, open the link below within %s:
+
+%s
+
+If you didn't request it, you can ignore this email and keep using your current password.`
+)
+
+//Password reset in two steps: the reset token is emailed to the confirmed address of the user
+//and then exchanged for a new password. The token is stored hashed in user_newpassword.
+type PasswordResetController struct {
+	userStorage       *models.UserStorage
+	userStatusChecker *models.UserStatusChecker
+	redisStorage      *storage.RedisStorage
+	mailer            mail.Mailer
+	passwordPolicy    *models.PasswordPolicy
+	resetUrl          string
+	tokenExpiration   time.Duration
+	influxdbClient    *client.Client
+}
+
+func NewPasswordResetController(
+	influxdbClient *client.Client,
+	storageFactory *models.StorageFactory,
+	redisStorage *storage.RedisStorage,
+	mailer mail.Mailer,
+	passwordPolicy *models.PasswordPolicy,
+	passwordResetConfig *config.PasswordResetConfig) *PasswordResetController {
+
+	controller := new(PasswordResetController)
+	controller.influxdbClient = influxdbClient
+	controller.userStorage = storageFactory.GetUserStorage()
+	controller.userStatusChecker = storageFactory.GetUserStatusChecker()
+	controller.redisStorage = redisStorage
+	controller.mailer = mailer
+	controller.passwordPolicy = passwordPolicy
+	controller.resetUrl = passwordResetConfig.Url
+	controller.tokenExpiration = time.Duration(passwordResetConfig.TokenExpirationInSec) * time.Second
+
+	http.HandleFunc("/password/reset_request", controller.resetRequestHandler)
+	http.HandleFunc("/password/reset", controller.resetHandler)
+
+	return controller
+}
+
+//Emails the reset token. The response doesn't reveal whether the account exists.
+func (controller *PasswordResetController) resetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "resetRequestHandler")
+	defer closeTimer(timer)
+
+	if !controller.checkRequest(w, r) {
+		return
+	}
+
+	login := r.FormValue("login")
+	if login == "" {
+		outputError(w, http.StatusBadRequest, "invalid_request", "login is required")
+		return
+	}
+
+	user, err := controller.userStorage.FindByLogin(login)
+	if err != nil && err != models.MultipleAccountsForEmailError {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	if user == nil || user.EmailAuthenticated == nil || user.Email == "" {
+		logger.GetLogger().Debug("resetRequestHandler: user not found or without a confirmed email")
+	} else if err = controller.sendResetToken(user); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	outputJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+func (controller *PasswordResetController) sendResetToken(user *models.User) error {
+	tokenBytes := make([]byte, PasswordResetTokenLength/2)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	link, err := url.Parse(controller.resetUrl)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("user_id", strconv.FormatInt(user.Id, 10))
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	if err = controller.userStorage.SetNewPassword(user.Id, token); err != nil {
+		return err
+	}
+
+	return controller.mailer.Send(&mail.Message{
+		To:      user.Email,
+		Subject: passwordResetSubject,
+		Body:    fmt.Sprintf(passwordResetBody, user.Name, controller.tokenExpiration.String(), link.String()),
+	})
+}
+
+//Sets the new password and revokes all the tokens of the user
+func (controller *PasswordResetController) resetHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "resetHandler")
+	defer closeTimer(timer)
+
+	if !controller.checkRequest(w, r) {
+		return
+	}
+
+	userId, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		outputError(w, http.StatusBadRequest, "invalid_request", "user_id is required")
+		return
+	}
+	//The token has just been written to the master
+	user, err := controller.userStorage.FindByIdOnMaster(userId)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if user == nil || !user.IsValidNewPassword(r.FormValue("token")) || user.IsNewPasswordExpired(controller.tokenExpiration) {
+		outputError(w, http.StatusBadRequest, ErrorInvalidResetToken, "The password reset token is invalid or has expired")
+		return
+	}
+
+	password := r.FormValue("password")
+	if err = controller.passwordPolicy.Validate(password, user.Name, user.Email); err != nil {
+		outputPasswordError(w, err)
+		return
+	}
+
+	if err = controller.userStorage.SetPassword(userId, password); err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	controller.userStatusChecker.Invalidate(userId)
+
+	revoked, err := controller.redisStorage.RevokeUserTokens(strconv.FormatInt(userId, 10))
+	if err != nil {
+		//The password has been changed already, the old tokens expire eventually
+		logger.GetLogger().ErrorErr(err)
+	}
+
+	logger.GetLogger().Info(fmt.Sprintf("Password of user %d reset, revoked %d token(s)", userId, revoked))
+	outputJSON(w, http.StatusOK, map[string]interface{}{"user_id": userId, "revoked_tokens": revoked})
+}
+
+func (controller *PasswordResetController) checkRequest(w http.ResponseWriter, r *http.Request) bool {
+	if controller.mailer == nil {
+		outputError(w, http.StatusNotFound, ErrorPasswordResetDisabled, "Password reset is disabled")
+		return false
+	}
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return false
+	}
+	return true
+}
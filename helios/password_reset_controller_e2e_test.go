@@ -0,0 +1,37 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	PasswordResetRequestEndpoint = "/password/reset_request"
+	PasswordResetEndpoint        = "/password/reset"
+)
+
+func TestE2ePasswordResetRequestForUnknownUser(t *testing.T) {
+	skipInShortMode(t)
+
+	//The response mustn't reveal whether the account exists
+	statusCode, body := postResponse(ServerAddress+PasswordResetRequestEndpoint,
+		url.Values{"login": {"NonExistingUserName"}}, nil, t)
+	if statusCode != http.StatusOK {
+		t.Fatal(fmt.Sprintf("Unexpected response to a reset request: %d %s", statusCode, string(body)))
+	}
+}
+
+func TestE2ePasswordResetInvalidToken(t *testing.T) {
+	skipInShortMode(t)
+
+	statusCode, body := postResponse(ServerAddress+PasswordResetEndpoint,
+		url.Values{"user_id": {TestUserId}, "token": {"InvalidToken"}, "password": {"NewPassword"}}, nil, t)
+	if statusCode != http.StatusBadRequest || getJsonString(unmarshall(body, t), "error", t) != ErrorInvalidResetToken {
+		t.Fatal(fmt.Sprintf("Invalid reset token accepted: %d %s", statusCode, string(body)))
+	}
+
+	//The password must not have been changed
+	getTokenResponse(TestUserName, TestPassword, t)
+}
@@ -0,0 +1,166 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/mail"
+	"github.com/Wikia/helios/models"
+	"github.com/influxdb/influxdb/client"
+)
+
+const (
+	ErrorInvalidUserName = "invalid_username"
+	ErrorUserNameTaken   = "username_taken"
+	ErrorInvalidEmail    = "invalid_email"
+)
+
+const (
+	emailConfirmationSubject = "Confirm your email address"
+	emailConfirmationBody    = `Hi %s,
+
+please confirm that this is your email address by opening the link below within %s:
+
+%s
+
+If you didn't create the account, you can ignore this email.`
+)
+
+//Creates MediaWiki accounts
+type RegistrationController struct {
+	userStorage            *models.UserStorage
+	mailer                 mail.Mailer
+	passwordPolicy         *models.PasswordPolicy
+	confirmationUrl        string
+	confirmationExpiration time.Duration
+	influxdbClient         *client.Client
+}
+
+func NewRegistrationController(
+	influxdbClient *client.Client,
+	storageFactory *models.StorageFactory,
+	mailer mail.Mailer,
+	passwordPolicy *models.PasswordPolicy,
+	emailConfirmationConfig *config.EmailConfirmationConfig) *RegistrationController {
+
+	controller := new(RegistrationController)
+	controller.influxdbClient = influxdbClient
+	controller.userStorage = storageFactory.GetUserStorage()
+	controller.mailer = mailer
+	controller.passwordPolicy = passwordPolicy
+	controller.confirmationUrl = emailConfirmationConfig.Url
+	controller.confirmationExpiration = time.Duration(emailConfirmationConfig.TokenExpirationInSec) * time.Second
+
+	http.HandleFunc("/register", controller.registerHandler)
+
+	return controller
+}
+
+func (controller *RegistrationController) isConfirmationEnabled() bool {
+	return controller.mailer != nil && controller.confirmationUrl != ""
+}
+
+func (controller *RegistrationController) registerHandler(w http.ResponseWriter, r *http.Request) {
+	timer := createTimerForAPICall(controller.influxdbClient, "registerHandler")
+	defer closeTimer(timer)
+
+	if r.Method != "POST" {
+		outputError(w, http.StatusMethodNotAllowed, "invalid_request", "Request must be POST")
+		return
+	}
+
+	userName := models.NormalizeUserName(r.FormValue("username"))
+	if err := models.ValidateUserName(userName); err != nil {
+		outputError(w, http.StatusBadRequest, ErrorInvalidUserName, err.Error())
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" {
+		if err := models.ValidateEmail(email); err != nil {
+			outputError(w, http.StatusBadRequest, ErrorInvalidEmail, err.Error())
+			return
+		}
+	}
+	password := r.FormValue("password")
+	if err := controller.passwordPolicy.Validate(password, userName, email); err != nil {
+		outputPasswordError(w, err)
+		return
+	}
+
+	isTaken, err := controller.userStorage.IsNameTaken(userName)
+	if err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if isTaken {
+		outputError(w, http.StatusConflict, ErrorUserNameTaken, models.UserNameTakenError.Error())
+		return
+	}
+
+	user, err := models.NewUser(userName, email, password)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	confirmationToken := ""
+	if email != "" && controller.isConfirmationEnabled() {
+		var tokenHash string
+		if confirmationToken, tokenHash, err = models.NewEmailToken(); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		user.SetEmailToken(tokenHash, controller.confirmationExpiration)
+	}
+
+	if err = controller.userStorage.CreateUser(user); err != nil {
+		if err == models.UserNameTakenError {
+			outputError(w, http.StatusConflict, ErrorUserNameTaken, err.Error())
+		} else {
+			outputError(w, http.StatusInternalServerError, "server_error", "")
+		}
+		return
+	}
+	logger.GetLogger().Info(fmt.Sprintf("Registered user %d", user.Id))
+
+	//The account exists already, so a failed email doesn't fail the registration
+	isConfirmationSent := false
+	if confirmationToken != "" {
+		if err = controller.sendEmailConfirmation(user, confirmationToken); err != nil {
+			logger.GetLogger().ErrorErr(err)
+		} else {
+			isConfirmationSent = true
+		}
+	}
+
+	outputJSON(w, http.StatusCreated, map[string]interface{}{
+		"user_id":                 user.Id,
+		"user_name":               user.Name,
+		"email_confirmation_sent": isConfirmationSent,
+	})
+}
+
+func (controller *RegistrationController) sendEmailConfirmation(user *models.User, token string) error {
+	link, err := url.Parse(controller.confirmationUrl)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("user_id", strconv.FormatInt(user.Id, 10))
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return controller.mailer.Send(&mail.Message{
+		To:      user.Email,
+		Subject: emailConfirmationSubject,
+		Body:    fmt.Sprintf(emailConfirmationBody, user.Name, controller.confirmationExpiration.String(), link.String()),
+	})
+}
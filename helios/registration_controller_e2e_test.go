@@ -0,0 +1,51 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	RegisterEndpoint = "/register"
+)
+
+func register(userName string, email string, password string, t *testing.T) (int, []byte) {
+	return postResponse(ServerAddress+RegisterEndpoint,
+		url.Values{"username": {userName}, "email": {email}, "password": {password}}, nil, t)
+}
+
+func TestE2eRegisterTakenUserName(t *testing.T) {
+	skipInShortMode(t)
+
+	statusCode, body := register(TestUserName, "", "SomePassword", t)
+	if statusCode != http.StatusConflict || getJsonString(unmarshall(body, t), "error", t) != ErrorUserNameTaken {
+		t.Fatal(fmt.Sprintf("Taken user name accepted: %d %s", statusCode, string(body)))
+	}
+}
+
+func TestE2eRegisterInvalidInput(t *testing.T) {
+	skipInShortMode(t)
+
+	tests := []struct {
+		userName string
+		email    string
+		password string
+		error    string
+	}{
+		{"", "", "SomePassword", ErrorInvalidUserName},
+		{"127.0.0.1", "", "SomePassword", ErrorInvalidUserName},
+		{"Foo#bar", "", "SomePassword", ErrorInvalidUserName},
+		{"NewTestUser", "not an email", "SomePassword", ErrorInvalidEmail},
+		{"NewTestUser", "", "", ErrorInvalidPassword},
+		{"NewTestUser", "", "newtestuser", ErrorInvalidPassword},
+	}
+
+	for _, test := range tests {
+		statusCode, body := register(test.userName, test.email, test.password, t)
+		if statusCode != http.StatusBadRequest || getJsonString(unmarshall(body, t), "error", t) != test.error {
+			t.Error(fmt.Sprintf("Expected %s for %q, got: %d %s", test.error, test.userName, statusCode, string(body)))
+		}
+	}
+}
@@ -2,6 +2,7 @@ package helios
 
 import (
 	"os"
+	"sync"
 	"time"
 
 	"github.com/Wikia/go-commons/logger"
@@ -27,20 +28,24 @@ const (
 	StatusMySQLMasterDown   = iota
 	StatusMySQLSlaveDown    = iota
 	StatusRedisAndMySQLDown = iota
+	StatusShuttingDown      = iota
 )
 
 type StatusManager struct {
 	run                      bool
+	isShuttingDown           bool
 	isHardBlocked            bool
 	isTokenStorageMasterDown bool
 	isTokenStorageSlaveDown  bool
 	isMySQLMasterDown        bool
 	isMySQLSlaveDown         bool
-	forceReadOnly            bool
+	//Held while forceReadOnly or isTokenStorageMasterDown change, together they decide if the Redis slave is used
+	readOnlyLock  sync.Mutex
+	forceReadOnly bool
 }
 
 func (statusManager *StatusManager) AllowTraffic() bool {
-	if statusManager.isHardBlocked {
+	if statusManager.isHardBlocked || statusManager.isShuttingDown {
 		return false
 	}
 
@@ -54,6 +59,10 @@ func (statusManager *StatusManager) GetStatus() int {
 		return StatusHardblocked
 	}
 
+	if statusManager.isShuttingDown {
+		return StatusShuttingDown
+	}
+
 	if (statusManager.isTokenStorageMasterDown || statusManager.isTokenStorageSlaveDown) &&
 		(statusManager.isMySQLMasterDown || statusManager.isMySQLSlaveDown) {
 		return StatusRedisAndMySQLDown
@@ -138,12 +147,12 @@ func (statusManager *StatusManager) runRedisMasterPing(redisStorage *storage.Red
 
 		timeout, err := runWithTimeout(RedisTimeout, pingChan)
 
-		statusManager.isTokenStorageMasterDown = timeout || err != nil && err.(*storage.StorageDisabledError) == nil
-		statusManager.logStatusChange(
-			"Redis Master Ok", "No Ping response from Redis Master", prevStatus, statusManager.isTokenStorageMasterDown)
-		if !statusManager.forceReadOnly {
-			redisStorage.SetForceUseSlave(statusManager.isTokenStorageMasterDown)
-		}
+		isDown := timeout || err != nil && err.(*storage.StorageDisabledError) == nil
+		statusManager.readOnlyLock.Lock()
+		statusManager.isTokenStorageMasterDown = isDown
+		redisStorage.SetForceUseSlave(statusManager.forceReadOnly || isDown)
+		statusManager.readOnlyLock.Unlock()
+		statusManager.logStatusChange("Redis Master Ok", "No Ping response from Redis Master", prevStatus, isDown)
 	}
 }
 
@@ -223,6 +232,23 @@ func NewStatusManager(serverConfig *config.ServerConfig, redisStorage *storage.R
 	return statusManager
 }
 
+//Marks the instance as not ready, so the load balancer stops sending new requests
+//while the in-flight ones are drained
+func (statusManager *StatusManager) SetShuttingDown() {
+	if !statusManager.isShuttingDown {
+		logger.GetLogger().Info("Service shutting down - marked as not ready")
+	}
+	statusManager.isShuttingDown = true
+}
+
+func (statusManager *StatusManager) SetForceReadOnly(forceReadOnly bool, redisStorage *storage.RedisStorage) {
+	statusManager.readOnlyLock.Lock()
+	defer statusManager.readOnlyLock.Unlock()
+
+	statusManager.forceReadOnly = forceReadOnly
+	redisStorage.SetForceUseSlave(forceReadOnly || statusManager.isTokenStorageMasterDown)
+}
+
 func (statusManager *StatusManager) Close() {
 	statusManager.run = false
 }
@@ -0,0 +1,144 @@
+package helios
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/Wikia/helios/storage"
+)
+
+//Binds the access tokens to the keys of their holders, the client certificate (RFC 8705) or the DPoP key
+//(RFC 9449), and checks that the tokens are presented by the holders
+type TokenBinding struct {
+	redisStorage       *storage.RedisStorage
+	issuer             string
+	isDpopEnabled      bool
+	dpopProofMaxAge    time.Duration
+	dpopProofClockSkew time.Duration
+}
+
+func NewTokenBinding(
+	redisStorage *storage.RedisStorage,
+	serverConfig *config.ServerConfig,
+	dpopConfig *config.DpopConfig) *TokenBinding {
+
+	binding := new(TokenBinding)
+	binding.redisStorage = redisStorage
+	binding.issuer = serverConfig.Issuer
+	binding.isDpopEnabled = dpopConfig.Enabled
+	binding.dpopProofMaxAge = time.Duration(dpopConfig.ProofMaxAgeInSec) * time.Second
+	binding.dpopProofClockSkew = time.Duration(dpopConfig.ProofClockSkewInSec) * time.Second
+	return binding
+}
+
+//Binds the access and refresh tokens about to be issued to the certificate of the clients authenticated with it
+//and to the DPoP key with the thumbprint, if the tokens were requested with a DPoP proof. The tokens are generated
+//here and their binding stored before osin saves them, so that no token is ever stored without its binding.
+//Sets an error on the response if the binding can't be stored.
+func (binding *TokenBinding) Bind(server *osin.Server, resp *osin.Response, r *http.Request, ar *osin.AccessRequest, jkt string) {
+	confirmation := &storage.TokenConfirmation{Jkt: jkt}
+	if usesTlsClientAuth(ar.Client) {
+		confirmation.X5tS256 = getCertificateThumbprint(getClientCertificate(r))
+	}
+	if confirmation.X5tS256 == "" && confirmation.Jkt == "" {
+		return
+	}
+
+	var err error
+	if ar.ForceAccessData == nil {
+		if ar.ForceAccessData, err = generateAccessData(server, ar); err != nil {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+			resp.InternalError = err
+			return
+		}
+	}
+	accessData := ar.ForceAccessData
+	err = binding.redisStorage.SaveTokenConfirmation(accessData.AccessToken, confirmation, int(accessData.ExpiresIn))
+	//A refresh token can't outlive refresh-token-expiration-in-sec, also when it's rotated
+	if err == nil && accessData.RefreshToken != "" {
+		err = binding.redisStorage.SaveRefreshConfirmation(accessData.RefreshToken, confirmation,
+			binding.redisStorage.GetTokenSettings().RefreshTokenExpirationInSec)
+	}
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+	}
+}
+
+//Checks that the refresh token is presented with the certificate and the DPoP key it's bound to (RFC 8705
+//section 4, RFC 9449 section 5), jkt is the thumbprint of the key of the token request's proof.
+//Sets an error on the response and returns false if it isn't.
+func (binding *TokenBinding) CheckRefreshToken(resp *osin.Response, r *http.Request, refreshToken string, jkt string) bool {
+	confirmation, err := binding.redisStorage.LoadRefreshConfirmation(refreshToken)
+	return checkTokenHolder(resp, r, confirmation, err, jkt, "refresh token")
+}
+
+//Checks that the access token passed to the token request, as the subject_token or the actor_token of a token
+//exchange, is presented with the certificate and the DPoP key it's bound to. Otherwise a stolen bound token could
+//be exchanged for a token which isn't bound. Sets an error on the response and returns false if it isn't.
+func (binding *TokenBinding) CheckExchangedToken(
+	resp *osin.Response, r *http.Request, accessToken string, jkt string, param string) bool {
+
+	confirmation, err := binding.redisStorage.LoadTokenConfirmation(accessToken)
+	return checkTokenHolder(resp, r, confirmation, err, jkt, param)
+}
+
+func checkTokenHolder(
+	resp *osin.Response, r *http.Request, confirmation *storage.TokenConfirmation, err error, jkt string, name string) bool {
+
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return false
+	}
+	if confirmation == nil {
+		return true
+	}
+	if confirmation.X5tS256 != "" {
+		certificate := getClientCertificate(r)
+		if certificate == nil || getCertificateThumbprint(certificate) != confirmation.X5tS256 {
+			resp.SetError(osin.E_INVALID_GRANT, "The "+name+" is bound to another certificate")
+			return false
+		}
+	}
+	if confirmation.Jkt != "" && jkt != confirmation.Jkt {
+		if jkt == "" {
+			resp.SetError(ErrorInvalidDpopProof, "The "+name+" is bound to a DPoP key, a proof is required")
+		} else {
+			resp.SetError(osin.E_INVALID_GRANT, "The "+name+" is bound to another DPoP key")
+		}
+		return false
+	}
+	return true
+}
+
+//Checks that the request is sent with the certificate and the DPoP proof of the key the access token is bound to.
+//Returns the confirmation of the token, nil if the token isn't bound.
+func (binding *TokenBinding) CheckConfirmation(accessToken string, r *http.Request) (*storage.TokenConfirmation, bool, error) {
+	confirmation, err := binding.redisStorage.LoadTokenConfirmation(accessToken)
+	if err != nil || confirmation == nil {
+		return nil, err == nil, err
+	}
+	if confirmation.X5tS256 != "" {
+		certificate := getClientCertificate(r)
+		if certificate == nil || getCertificateThumbprint(certificate) != confirmation.X5tS256 {
+			return confirmation, false, nil
+		}
+	}
+	if confirmation.Jkt != "" {
+		isConfirmed, err := binding.checkDpopBinding(confirmation, accessToken, getDpopProof(r), r.Method,
+			binding.getRequestUri(r))
+		return confirmation, isConfirmed, err
+	}
+	return confirmation, true, nil
+}
+
+//Checks that the proof is signed with the key the access token is bound to
+func (binding *TokenBinding) checkDpopBinding(
+	confirmation *storage.TokenConfirmation, accessToken string, proof string, method string, uri string) (bool, error) {
+
+	jkt, isValid, err := binding.VerifyDpopProof(proof, method, uri, accessToken)
+	return isValid && jkt == confirmation.Jkt, err
+}
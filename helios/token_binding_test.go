@@ -0,0 +1,50 @@
+package helios
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/storage"
+)
+
+func TestCheckTokenHolder(t *testing.T) {
+	certificate := newTestCertificate("app", t)
+	otherCertificate := newTestCertificate("other", t)
+	certificateBound := &storage.TokenConfirmation{X5tS256: getCertificateThumbprint(certificate)}
+	dpopBound := &storage.TokenConfirmation{Jkt: "jkt"}
+
+	tests := []struct {
+		name         string
+		confirmation *storage.TokenConfirmation
+		err          error
+		certificate  *x509.Certificate
+		jkt          string
+		expected     string
+	}{
+		{"unbound token", nil, nil, nil, "", ""},
+		{"unbound token with a proof", nil, nil, certificate, "jkt", ""},
+		{"storage error", nil, errors.New("down"), nil, "", osin.E_SERVER_ERROR},
+		{"certificate", certificateBound, nil, certificate, "", ""},
+		{"no certificate", certificateBound, nil, nil, "", osin.E_INVALID_GRANT},
+		{"other certificate", certificateBound, nil, otherCertificate, "", osin.E_INVALID_GRANT},
+		{"DPoP key", dpopBound, nil, nil, "jkt", ""},
+		{"no DPoP proof", dpopBound, nil, nil, "", ErrorInvalidDpopProof},
+		{"other DPoP key", dpopBound, nil, nil, "other", osin.E_INVALID_GRANT},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", TestIssuer+TokenEndpointPath, nil)
+		if test.certificate != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.certificate}}
+		}
+		resp := osin.NewResponse(&testClientStorage{})
+		isHolder := checkTokenHolder(resp, r, test.confirmation, test.err, test.jkt, "refresh token")
+		if isHolder != (test.expected == "") || (!isHolder && resp.Output["error"] != test.expected) {
+			t.Errorf("Wrong result for %s. Expected: %q Actual: %v", test.name, test.expected, resp.Output["error"])
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package helios
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/storage"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+const (
+	ErrorInvalidTarget = "invalid_target"
+)
+
+//Token exchange (RFC 8693): a service holding the access token of a user gets a token for calling another
+//service on the user's behalf. The new token is limited to the requested audience and at most the scope and
+//lifetime of the subject_token. With an actor_token the new token records the party acting for the user.
+//The tokens bound to a key have to be presented with it, jkt is the key of the request's DPoP proof.
+func (controller *OAuthController) tokenHandlerTokenExchange(
+	resp *osin.Response, r *http.Request, ar *osin.AccessRequest, jkt string) error {
+
+	client, isHeliosClient := ar.Client.(*storage.Client)
+	if !isHeliosClient || len(client.TokenExchangeAudiences) == 0 {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "The client may not exchange tokens")
+		return nil
+	}
+	if tokenType := r.Form.Get("requested_token_type"); tokenType != "" && tokenType != TokenTypeAccessToken {
+		resp.SetError(osin.E_INVALID_REQUEST, "Only access tokens can be requested")
+		return nil
+	}
+	//Every exchanged token is limited to an audience, the only one of the client is used if none is given
+	audience := r.Form.Get("audience")
+	if audience == "" && len(client.TokenExchangeAudiences) == 1 {
+		audience = client.TokenExchangeAudiences[0]
+	}
+	if audience == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "audience is required")
+		return nil
+	}
+	if !client.IsTokenExchangeAudienceAllowed(audience) {
+		resp.SetError(ErrorInvalidTarget, "The client may not exchange tokens for the audience")
+		return nil
+	}
+
+	subject := controller.loadExchangedToken(resp, r, "subject_token", jkt)
+	if subject == nil {
+		return nil
+	}
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", subject.UserData), 10, 64)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_GRANT, "The subject_token wasn't issued to a user")
+		return nil
+	}
+	subjectExchange, err := controller.redisStorage.LoadTokenExchange(subject.AccessToken)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+
+	//The parties which acted on the subject_token before are kept in the chain
+	var act *storage.Actor
+	if subjectExchange != nil {
+		act = subjectExchange.Act
+	}
+	if r.Form.Get("actor_token") != "" {
+		actor := controller.loadExchangedToken(resp, r, "actor_token", jkt)
+		if actor == nil {
+			return nil
+		}
+		act = &storage.Actor{Sub: actor.Client.GetId(), ClientId: actor.Client.GetId(), Act: act}
+		if actorUserId, isUserToken := actor.UserData.(string); isUserToken && actorUserId != "" {
+			act.Sub = actorUserId
+		}
+	} else if !client.TokenExchangeImpersonation {
+		resp.SetError(osin.E_INVALID_REQUEST, "actor_token is required")
+		return nil
+	}
+
+	if ar.Scope == "" {
+		ar.Scope = subject.Scope
+	} else if !isScopeSubset(ar.Scope, subject.Scope) {
+		resp.SetError(osin.E_INVALID_SCOPE, "The scope can't be wider than the scope of the subject_token")
+		return nil
+	}
+
+	if isAllowed, err := controller.checkUserStatus(resp, userId); !isAllowed {
+		return err
+	}
+	if isAllowed, err := controller.checkBlocks(resp, r, userId); !isAllowed {
+		return err
+	}
+
+	//The exchanged token can't outlive the subject_token, and isn't refreshable as the refreshed
+	//token wouldn't carry the audience and actor
+	ar.Expiration = getExchangedTokenExpiration(subject, ar.Expiration, time.Now())
+	//Redis can't store the token for less than a second
+	if ar.Expiration < 1 {
+		resp.SetError(osin.E_INVALID_GRANT, "The subject_token is invalid or has expired")
+		return nil
+	}
+	ar.GenerateRefresh = false
+	ar.UserData = fmt.Sprintf("%d", userId)
+	ar.Authorized = true
+
+	//The token is generated here, so the exchange can be stored under it before it's handed out
+	accessData, err := generateAccessData(controller.server.Get(), ar)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	exchange := &storage.TokenExchange{Audience: audience, Act: act}
+	if err = controller.redisStorage.SaveTokenExchange(accessData.AccessToken, exchange, int(ar.Expiration)); err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		return err
+	}
+	ar.ForceAccessData = accessData
+	return nil
+}
+
+//Loads the access token passed in the param, its type has to be given in the param with the _type suffix
+func (controller *OAuthController) loadExchangedToken(
+	resp *osin.Response, r *http.Request, param string, jkt string) *osin.AccessData {
+
+	token := r.Form.Get(param)
+	if token == "" {
+		resp.SetError(osin.E_INVALID_REQUEST, param+" is required")
+		return nil
+	}
+	if r.Form.Get(param+"_type") != TokenTypeAccessToken {
+		resp.SetError(osin.E_INVALID_REQUEST, param+"_type must be "+TokenTypeAccessToken)
+		return nil
+	}
+
+	accessData, err := controller.redisStorage.LoadAccess(token)
+	if err != nil || accessData == nil || accessData.IsExpired() {
+		resp.SetError(osin.E_INVALID_GRANT, "The "+param+" is invalid or has expired")
+		return nil
+	}
+	if !controller.tokenBinding.CheckExchangedToken(resp, r, token, jkt, param) {
+		return nil
+	}
+	return accessData
+}
+
+//Adds the audience and the actors of tokens issued by a token exchange
+func (controller *OAuthController) addTokenExchange(resp *osin.Response, accessData *osin.AccessData) {
+	exchange, err := controller.redisStorage.LoadTokenExchange(accessData.AccessToken)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+	if exchange == nil {
+		return
+	}
+
+	if exchange.Audience != "" {
+		resp.Output["aud"] = exchange.Audience
+	}
+	if exchange.Act != nil {
+		resp.Output["act"] = exchange.Act
+	}
+}
+
+//The expiration in seconds, cut down to the remaining lifetime of the subject_token. It's 0 or less when
+//the subject_token has less than a second left.
+func getExchangedTokenExpiration(subject *osin.AccessData, expiration int32, now time.Time) int32 {
+	if remaining := int32(subject.ExpireAt().Sub(now).Seconds()); remaining < expiration {
+		return remaining
+	}
+	return expiration
+}
+
+//An empty scope stands for all the access the user has
+func isScopeSubset(scope string, grantedScope string) bool {
+	if grantedScope == "" {
+		return true
+	}
+
+	granted := make(map[string]bool)
+	for _, value := range strings.Fields(grantedScope) {
+		granted[value] = true
+	}
+	for _, value := range strings.Fields(scope) {
+		if !granted[value] {
+			return false
+		}
+	}
+	return true
+}
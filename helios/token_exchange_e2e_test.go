@@ -0,0 +1,25 @@
+package helios
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestE2eTokenExchangeClientWithoutPolicy(t *testing.T) {
+	skipInShortMode(t)
+
+	accessToken := getJsonString(getTokenResponse(TestUserName, TestPassword, t), "access_token", t)
+	_, body := postResponse(ServerAddress+TokenEndpoint, url.Values{
+		"grant_type":         {GrantTypeTokenExchange},
+		"client_id":          {TestClientId},
+		"client_secret":      {TestClientSecret},
+		"subject_token":      {accessToken},
+		"subject_token_type": {TokenTypeAccessToken},
+	}, nil, t)
+	if getJsonString(unmarshall(body, t), "error", t) != osin.E_UNAUTHORIZED_CLIENT {
+		t.Fatal(fmt.Sprintf("Token exchanged by a client without exchange policy: %s", string(body)))
+	}
+}
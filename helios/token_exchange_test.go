@@ -0,0 +1,57 @@
+package helios
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestIsScopeSubset(t *testing.T) {
+	tests := []struct {
+		scope        string
+		grantedScope string
+		expected     bool
+	}{
+		{"", "", true},
+		{"read", "", true},
+		{"", "read write", true},
+		{"read", "read write", true},
+		{"write read", "read write", true},
+		{"read  write", "read write", true},
+		{"admin", "read write", false},
+		{"read admin", "read write", false},
+		{"rea", "read", false},
+	}
+
+	for _, test := range tests {
+		if isScopeSubset(test.scope, test.grantedScope) != test.expected {
+			t.Errorf("Wrong result for %q of %q. Expected: %t", test.scope, test.grantedScope, test.expected)
+		}
+	}
+}
+
+func TestGetExchangedTokenExpiration(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		createdAgo time.Duration
+		expiresIn  int32
+		expiration int32
+		expected   int32
+	}{
+		{"subject outlives the new token", 0, 7200, 3600, 3600},
+		{"subject expires first", time.Hour, 5400, 3600, 1800},
+		{"same lifetime", 0, 3600, 3600, 3600},
+		{"subject about to expire", 3599 * time.Second, 3600, 3600, 1},
+		{"subject with less than a second left", 3599*time.Second + 500*time.Millisecond, 3600, 3600, 0},
+		{"subject expired", 2 * time.Hour, 3600, 3600, -3600},
+	}
+
+	for _, test := range tests {
+		subject := &osin.AccessData{CreatedAt: now.Add(-test.createdAgo), ExpiresIn: test.expiresIn}
+		if expiration := getExchangedTokenExpiration(subject, test.expiration, now); expiration != test.expected {
+			t.Errorf("Wrong expiration when %s. Expected: %d Actual: %d", test.name, test.expected, expiration)
+		}
+	}
+}
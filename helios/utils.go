@@ -1,8 +1,18 @@
 package helios
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/RangelReale/osin"
 	"github.com/Wikia/go-commons/logger"
 	"github.com/Wikia/go-commons/perfmonitoring"
+	"github.com/Wikia/helios/models"
+	"github.com/Wikia/helios/storage"
 	"github.com/influxdb/influxdb/client"
 )
 
@@ -17,7 +27,95 @@ func createTimerForAPICall(influxdbClient *client.Client, methodName string) *pe
 	return timer
 }
 
+func outputJSON(w http.ResponseWriter, statusCode int, output map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		logger.GetLogger().ErrorErr(err)
+	}
+}
+
+//Outputs the error in the format of the OAuth errors
+func outputError(w http.ResponseWriter, statusCode int, errorCode string, description string) {
+	outputJSON(w, statusCode, map[string]interface{}{"error": errorCode, "error_description": description})
+}
+
+//Outputs the broken password policy rule with its code and params, so that clients can show a localized message
+func outputPasswordError(w http.ResponseWriter, err error) {
+	output := map[string]interface{}{"error": ErrorInvalidPassword, "error_description": err.Error()}
+	if policyErr, isPolicyErr := err.(*models.PasswordPolicyError); isPolicyErr {
+		output["password_error"] = policyErr.Code
+		if policyErr.Params != nil {
+			output["password_error_params"] = policyErr.Params
+		}
+	}
+	outputJSON(w, http.StatusBadRequest, output)
+}
+
+//The public URL of helios is the issuer, without the issuer in the config it's the address the request was sent to
+func getBaseUrl(issuer string, r *http.Request) string {
+	if issuer != "" {
+		return issuer
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+//Returns the access token passed in the Authorization header or the access_token parameter
+func getBearerToken(r *http.Request) string {
+	authorization := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	//The tokens bound to a DPoP key are sent with their own scheme
+	if len(authorization) == 2 && (authorization[0] == "Bearer" || authorization[0] == TokenTypeDpop) {
+		return authorization[1]
+	}
+	return r.FormValue("access_token")
+}
+
+//Loads the bearer access token of the request and returns it with the id of the user it was issued to.
+//Outputs the error and returns false if the token is invalid.
+func authenticateBearer(redisStorage *storage.RedisStorage, tokenBinding *TokenBinding,
+	w http.ResponseWriter, r *http.Request) (*osin.AccessData, int64, bool) {
+
+	accessData, err := redisStorage.LoadAccess(getBearerToken(r))
+	if err != nil || accessData == nil || accessData.IsExpired() {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, "The access token is invalid or has expired")
+		return nil, 0, false
+	}
+	if _, isConfirmed, err := tokenBinding.CheckConfirmation(accessData.AccessToken, r); err != nil {
+		outputError(w, http.StatusInternalServerError, "server_error", "")
+		return nil, 0, false
+	} else if !isConfirmed {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, "The access token is bound to another key")
+		return nil, 0, false
+	}
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", accessData.UserData), 10, 64)
+	if err != nil {
+		outputError(w, http.StatusUnauthorized, ErrorInvalidToken, "The access token wasn't issued to a user")
+		return nil, 0, false
+	}
+	return accessData, userId, true
+}
+
 func closeTimer(timer *perfmonitoring.Timer) {
 	err := timer.Close()
 	logger.GetLogger().ErrorErr(err)
 }
+
+//Returns the IP of the client from the configured header set by the load balancer, or the address
+//of the connection if the header isn't configured or present
+func getClientIP(r *http.Request, clientIpHeader string) string {
+	if clientIpHeader != "" {
+		if forwardedFor := r.Header.Get(clientIpHeader); forwardedFor != "" {
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
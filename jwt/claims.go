@@ -0,0 +1,127 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	MissingClaimError    = errors.New("A required claim is missing")
+	InvalidIssuerError   = errors.New("The token was issued by someone else")
+	InvalidSubjectError  = errors.New("The token was issued for someone else")
+	InvalidAudienceError = errors.New("The token is meant for someone else")
+	ExpiredError         = errors.New("The token has expired")
+	NotYetValidError     = errors.New("The token is not valid yet")
+	LifetimeTooLongError = errors.New("The token is valid for too long")
+)
+
+type Claims map[string]interface{}
+
+//Expected values of the registered claims, the empty ones aren't checked
+type Validation struct {
+	Issuer  string
+	Subject string
+	//The aud claim has to contain one of them
+	Audiences []string
+	//Max time between now and exp, exp is required if it's set
+	MaxLifetime time.Duration
+	//Tolerated difference between the clocks of the issuer and helios
+	ClockSkew time.Duration
+	//The claims which have to be present
+	Required []string
+	Now      time.Time
+}
+
+func (claims Claims) String(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+//Returns the NumericDate claim, or false if it's missing or not a number
+func (claims Claims) Time(name string) (time.Time, bool) {
+	var seconds float64
+	switch value := claims[name].(type) {
+	case json.Number:
+		var err error
+		if seconds, err = value.Float64(); err != nil {
+			return time.Time{}, false
+		}
+	case float64:
+		seconds = value
+	case int64:
+		seconds = float64(value)
+	case int:
+		seconds = float64(value)
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+//The aud claim may be a single string or an array of them
+func (claims Claims) Audiences() []string {
+	switch audience := claims["aud"].(type) {
+	case string:
+		return []string{audience}
+	case []interface{}:
+		audiences := make([]string, 0, len(audience))
+		for _, value := range audience {
+			if str, isString := value.(string); isString {
+				audiences = append(audiences, str)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+func (claims Claims) Validate(validation *Validation) error {
+	for _, name := range validation.Required {
+		if _, isPresent := claims[name]; !isPresent {
+			return MissingClaimError
+		}
+	}
+	if validation.Issuer != "" && claims.String("iss") != validation.Issuer {
+		return InvalidIssuerError
+	}
+	if validation.Subject != "" && claims.String("sub") != validation.Subject {
+		return InvalidSubjectError
+	}
+	if len(validation.Audiences) > 0 && !claims.hasAudience(validation.Audiences) {
+		return InvalidAudienceError
+	}
+
+	now := validation.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if expiresAt, hasExpiry := claims.Time("exp"); hasExpiry {
+		if !now.Before(expiresAt.Add(validation.ClockSkew)) {
+			return ExpiredError
+		}
+		if validation.MaxLifetime > 0 && expiresAt.Sub(now) > validation.MaxLifetime+validation.ClockSkew {
+			return LifetimeTooLongError
+		}
+	} else if validation.MaxLifetime > 0 {
+		return MissingClaimError
+	}
+	if notBefore, hasNotBefore := claims.Time("nbf"); hasNotBefore && now.Add(validation.ClockSkew).Before(notBefore) {
+		return NotYetValidError
+	}
+	if issuedAt, hasIssuedAt := claims.Time("iat"); hasIssuedAt && now.Add(validation.ClockSkew).Before(issuedAt) {
+		return NotYetValidError
+	}
+	return nil
+}
+
+func (claims Claims) hasAudience(expected []string) bool {
+	for _, audience := range claims.Audiences() {
+		for _, expectedAudience := range expected {
+			if audience == expectedAudience {
+				return true
+			}
+		}
+	}
+	return false
+}
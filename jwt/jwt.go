@@ -0,0 +1,244 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+var (
+	MalformedTokenError       = errors.New("The token is not a valid signed JWT")
+	UnsupportedAlgorithmError = errors.New("The signature algorithm is not supported")
+	InvalidSignatureError     = errors.New("The signature is invalid")
+	NoMatchingKeyError        = errors.New("None of the keys matches the token")
+)
+
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+	//Public key of the signer embedded in the token, like in the DPoP proofs
+	Jwk *JWK `json:"jwk,omitempty"`
+}
+
+//JWT signed with JWS compact serialization. The signature has to be checked with Verify before the claims are trusted.
+type Token struct {
+	Header       Header
+	Claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+//Public key the tokens can be verified with. Kid is empty for keys which weren't published with an id.
+type PublicKey struct {
+	Kid string
+	Key crypto.PublicKey
+}
+
+type algorithm struct {
+	hash crypto.Hash
+	//"HS", "RS", "PS" or "ES"
+	family string
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {crypto.SHA256, "HS"},
+	"HS384": {crypto.SHA384, "HS"},
+	"HS512": {crypto.SHA512, "HS"},
+	"RS256": {crypto.SHA256, "RS"},
+	"RS384": {crypto.SHA384, "RS"},
+	"RS512": {crypto.SHA512, "RS"},
+	"PS256": {crypto.SHA256, "PS"},
+	"PS384": {crypto.SHA384, "PS"},
+	"PS512": {crypto.SHA512, "PS"},
+	"ES256": {crypto.SHA256, "ES"},
+	"ES384": {crypto.SHA384, "ES"},
+	"ES512": {crypto.SHA512, "ES"},
+}
+
+func Parse(compact string) (*Token, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 3 {
+		return nil, MalformedTokenError
+	}
+
+	token := &Token{signingInput: parts[0] + "." + parts[1]}
+	if err := decodeJSONPart(parts[0], &token.Header); err != nil {
+		return nil, err
+	}
+	if err := decodeJSONPart(parts[1], &token.Claims); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, MalformedTokenError
+	}
+	token.signature = signature
+	return token, nil
+}
+
+func decodeJSONPart(part string, value interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return MalformedTokenError
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(decoded)))
+	//Numeric claims like exp are kept exact
+	decoder.UseNumber()
+	if err = decoder.Decode(value); err != nil {
+		return MalformedTokenError
+	}
+	return nil
+}
+
+//Checks the signature with the key, which has to match the algorithm of the token. The HS algorithms
+//are verified with a shared secret passed as []byte, never with a public key.
+func (token *Token) Verify(key crypto.PublicKey) error {
+	alg, isSupported := algorithms[token.Header.Alg]
+	if !isSupported {
+		return UnsupportedAlgorithmError
+	}
+	if secret, isSecret := key.([]byte); isSecret {
+		if alg.family != "HS" || !hmac.Equal(token.signature, signHMAC(alg.hash, secret, token.signingInput)) {
+			return InvalidSignatureError
+		}
+		return nil
+	}
+	hasher := alg.hash.New()
+	hasher.Write([]byte(token.signingInput))
+	digest := hasher.Sum(nil)
+
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg.family {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(publicKey, alg.hash, digest, token.signature)
+		case "PS":
+			err = rsa.VerifyPSS(publicKey, alg.hash, digest, token.signature,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return NoMatchingKeyError
+		}
+		if err != nil {
+			return InvalidSignatureError
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if alg.family != "ES" || len(token.signature) != 2*size {
+			return InvalidSignatureError
+		}
+		r := new(big.Int).SetBytes(token.signature[:size])
+		s := new(big.Int).SetBytes(token.signature[size:])
+		if !ecdsa.Verify(publicKey, digest, r, s) {
+			return InvalidSignatureError
+		}
+		return nil
+	}
+	return NoMatchingKeyError
+}
+
+//Checks the signature with the key of the same id, or with each key if the token has no key id
+func (token *Token) VerifyWithKeys(keys []PublicKey) error {
+	err := NoMatchingKeyError
+	for _, key := range keys {
+		if token.Header.Kid != "" && key.Kid != "" && key.Kid != token.Header.Kid {
+			continue
+		}
+		if err = token.Verify(key.Key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//Returns the names of the supported signature algorithms, the public key ones or the HS ones
+func Algorithms(isSymmetric bool) []string {
+	names := make([]string, 0, len(algorithms))
+	for name, alg := range algorithms {
+		if (alg.family == "HS") == isSymmetric {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func IsSymmetric(alg string) bool {
+	return algorithms[alg].family == "HS"
+}
+
+func signHMAC(hash crypto.Hash, secret []byte, signingInput string) []byte {
+	mac := hmac.New(hash.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+//Creates a signed JWT, for the HS, RS and ES algorithms
+func Sign(alg string, kid string, claims Claims, key crypto.PrivateKey) (string, error) {
+	return SignWithHeader(Header{Alg: alg, Kid: kid, Typ: "JWT"}, claims, key)
+}
+
+func SignWithHeader(header Header, claims Claims, key crypto.PrivateKey) (string, error) {
+	algorithm, isSupported := algorithms[header.Alg]
+	if !isSupported || algorithm.family == "PS" {
+		return "", UnsupportedAlgorithmError
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	hasher := algorithm.hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	switch privateKey := key.(type) {
+	case []byte:
+		if algorithm.family != "HS" {
+			return "", NoMatchingKeyError
+		}
+		signature = signHMAC(algorithm.hash, privateKey, signingInput)
+	case *rsa.PrivateKey:
+		if algorithm.family != "RS" {
+			return "", NoMatchingKeyError
+		}
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, algorithm.hash, digest); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm.family != "ES" {
+			return "", NoMatchingKeyError
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+		if err != nil {
+			return "", err
+		}
+		size := (privateKey.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	default:
+		return "", fmt.Errorf("Unsupported key type %T", key)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,221 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testClaims() Claims {
+	now := time.Now()
+	return Claims{
+		"iss": "partner",
+		"sub": "1",
+		"aud": "https://helios.example.com",
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"iat": now.Unix(),
+		"jti": "abc",
+	}
+}
+
+func signAndParse(alg string, kid string, claims Claims, key interface{}, t *testing.T) *Token {
+	signed, err := Sign(alg, kid, claims, key)
+	if err != nil {
+		t.Fatal("Error signing token", err)
+	}
+	token, err := Parse(signed)
+	if err != nil {
+		t.Fatal("Error parsing token", err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err := signAndParse("RS256", "", testClaims(), rsaKey, t).Verify(&rsaKey.PublicKey); err != nil {
+		t.Error("Valid RS256 signature rejected", err)
+	}
+	token := signAndParse("ES256", "", testClaims(), ecKey, t)
+	if err := token.Verify(&ecKey.PublicKey); err != nil {
+		t.Error("Valid ES256 signature rejected", err)
+	}
+	if token.Verify(&otherKey.PublicKey) != InvalidSignatureError {
+		t.Error("Signature of another key accepted")
+	}
+	if token.Verify(&rsaKey.PublicKey) != NoMatchingKeyError {
+		t.Error("Key of another type accepted")
+	}
+
+	token.Header.Alg = "none"
+	if token.Verify(&ecKey.PublicKey) != UnsupportedAlgorithmError {
+		t.Error("Unsigned token accepted")
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	token := signAndParse("HS256", "", testClaims(), secret, t)
+	if err := token.Verify(secret); err != nil {
+		t.Error("Valid HS256 signature rejected", err)
+	}
+	if token.Verify([]byte("another secret")) != InvalidSignatureError {
+		t.Error("Signature of another secret accepted")
+	}
+	if token.Verify(&rsaKey.PublicKey) == nil {
+		t.Error("HS256 signature verified with a public key")
+	}
+	if signAndParse("RS256", "", testClaims(), rsaKey, t).Verify(secret) != InvalidSignatureError {
+		t.Error("RS256 signature verified with a secret")
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, compact := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "e30.!!.", "e30.e30.!!"} {
+		if _, err := Parse(compact); err != MalformedTokenError {
+			t.Errorf("Malformed token %q parsed", compact)
+		}
+	}
+}
+
+func TestVerifyWithKeys(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := []PublicKey{{Kid: "other", Key: &otherKey.PublicKey}, {Kid: "key", Key: &key.PublicKey}}
+
+	if err := signAndParse("ES256", "key", testClaims(), key, t).VerifyWithKeys(keys); err != nil {
+		t.Error("Token signed with the key of the kid rejected", err)
+	}
+	if err := signAndParse("ES256", "", testClaims(), key, t).VerifyWithKeys(keys); err != nil {
+		t.Error("Token without kid rejected", err)
+	}
+	if signAndParse("ES256", "other", testClaims(), key, t).VerifyWithKeys(keys) == nil {
+		t.Error("Token signed with a key other than the one of the kid accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	validation := &Validation{
+		Issuer:      "partner",
+		Audiences:   []string{"https://helios.example.com", "https://helios.example.com/token"},
+		MaxLifetime: 10 * time.Minute,
+		ClockSkew:   time.Minute,
+		Required:    []string{"sub", "jti"},
+	}
+	if err := testClaims().Validate(validation); err != nil {
+		t.Fatal("Valid claims rejected", err)
+	}
+
+	now := time.Now()
+	for expected, change := range map[error]func(claims Claims){
+		InvalidIssuerError:   func(claims Claims) { claims["iss"] = "other" },
+		InvalidAudienceError: func(claims Claims) { claims["aud"] = []interface{}{"https://other.example.com"} },
+		MissingClaimError:    func(claims Claims) { delete(claims, "jti") },
+		ExpiredError:         func(claims Claims) { claims["exp"] = now.Add(-2 * time.Minute).Unix() },
+		LifetimeTooLongError: func(claims Claims) { claims["exp"] = now.Add(time.Hour).Unix() },
+		NotYetValidError:     func(claims Claims) { claims["nbf"] = now.Add(2 * time.Minute).Unix() },
+	} {
+		claims := testClaims()
+		change(claims)
+		//The claims are checked the way they are after parsing
+		claimsJSON, _ := json.Marshal(claims)
+		var parsed Claims
+		decodeJSONPart(base64.RawURLEncoding.EncodeToString(claimsJSON), &parsed)
+		if err := parsed.Validate(validation); err != expected {
+			t.Errorf("Expected %v, got %v for %v", expected, err, claims)
+		}
+	}
+}
+
+func TestParsePublicKeysPEM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	ecDER, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecDER})...)
+
+	keys, err := ParsePublicKeysPEM(data)
+	if err != nil || len(keys) != 2 {
+		t.Fatal("Error parsing PEM keys", err)
+	}
+	if err = signAndParse("ES256", "", testClaims(), ecKey, t).VerifyWithKeys(keys); err != nil {
+		t.Error("Token signed with a PEM key rejected", err)
+	}
+
+	if _, err = ParsePublicKeysPEM([]byte("no keys")); err != NoPublicKeysError {
+		t.Error("Data without keys accepted", err)
+	}
+}
+
+func TestFetchKeySet(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaJWK, _ := NewJWK("rsa", &rsaKey.PublicKey)
+	ecJWK, _ := NewJWK("ec", &ecKey.PublicKey)
+	encryptionJWK := *ecJWK
+	encryptionJWK.Kid = "enc"
+	encryptionJWK.Use = "enc"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&JWKSet{Keys: []JWK{*rsaJWK, *ecJWK, encryptionJWK}})
+	}))
+	defer server.Close()
+
+	set, err := FetchKeySet(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal("Error fetching the JWK set", err)
+	}
+	keys := set.PublicKeys()
+	if len(keys) != 2 {
+		t.Fatal("Unexpected keys in the JWK set", keys)
+	}
+	if err = signAndParse("RS256", "rsa", testClaims(), rsaKey, t).VerifyWithKeys(keys); err != nil {
+		t.Error("Token signed with the RSA JWK rejected", err)
+	}
+	if err = signAndParse("ES256", "ec", testClaims(), ecKey, t).VerifyWithKeys(keys); err != nil {
+		t.Error("Token signed with the EC JWK rejected", err)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	//The example of RFC 7638 section 3.1
+	jwk := &JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhM" +
+			"stn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQv" +
+			"RL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzK" +
+			"nqDKgw",
+		Kid: "2011-04-29",
+		Alg: "RS256",
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil || thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatal("Wrong thumbprint", thumbprint, err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecJWK, _ := NewJWK("", &key.PublicKey)
+	ecJWK.Kid = "ignored"
+	if thumbprint, err = ecJWK.Thumbprint(); err != nil {
+		t.Fatal("Error computing the EC thumbprint", err)
+	}
+	ecJWK.Kid = ""
+	if other, _ := ecJWK.Thumbprint(); other != thumbprint {
+		t.Error("Thumbprint depends on the optional members")
+	}
+}
@@ -0,0 +1,233 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+)
+
+const (
+	maxKeySetSize = 1 << 20
+)
+
+var (
+	NoPublicKeysError   = errors.New("No public keys found in the PEM data")
+	UnsupportedKeyError = errors.New("The key type is not supported")
+)
+
+//Public key in the JSON Web Key format (RFC 7517), RSA and EC keys are supported
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	//RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	//EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	//Private part of both key types, it's read only to reject the private keys sent by mistake
+	D string `json:"d,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+//Parses all the public keys and certificates in the PEM data
+func ParsePublicKeysPEM(data []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var certificate *x509.Certificate
+			if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = certificate.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, PublicKey{Key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, NoPublicKeysError
+	}
+	return keys, nil
+}
+
+func NewJWK(kid string, key crypto.PublicKey) (*JWK, error) {
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		x := make([]byte, size)
+		y := make([]byte, size)
+		publicKey.X.FillBytes(x)
+		publicKey.Y.FillBytes(y)
+		return &JWK{
+			Kty: "EC",
+			Kid: kid,
+			Crv: publicKey.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+		}, nil
+	}
+	return nil, UnsupportedKeyError
+}
+
+func (jwk *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+			return nil, UnsupportedKeyError
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, isSupported := curves[jwk.Crv]
+		if !isSupported {
+			return nil, UnsupportedKeyError
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, UnsupportedKeyError
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, UnsupportedKeyError
+}
+
+func (jwk *JWK) IsPrivate() bool {
+	return jwk.D != ""
+}
+
+//JWK thumbprint (RFC 7638): base64url encoded SHA-256 of the required members of the key in lexicographic order
+func (jwk *JWK) Thumbprint() (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		return "", UnsupportedKeyError
+	}
+
+	membersJSON, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(membersJSON)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, UnsupportedKeyError
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+//Returns the keys of the set which can be used for verifying signatures, the unsupported ones are skipped
+func (set *JWKSet) PublicKeys() []PublicKey {
+	keys := make([]PublicKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys = append(keys, PublicKey{Kid: jwk.Kid, Key: key})
+		}
+	}
+	return keys
+}
+
+//Downloads the JWK set published at the URL
+func FetchKeySet(httpClient *http.Client, url string) (*JWKSet, error) {
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching the JWK set from %s failed with status %d", url, response.StatusCode)
+	}
+	//JWK sets are small, anything bigger is not one
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: response.Body, N: maxKeySetSize})
+	if err != nil {
+		return nil, err
+	}
+
+	set := new(JWKSet)
+	if err = json.Unmarshal(body, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
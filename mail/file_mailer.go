@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"os"
+	"sync"
+
+	"github.com/Wikia/helios/config"
+)
+
+const (
+	FileMailerSeparator = "\r\n----------\r\n"
+)
+
+//Appends the emails to a file instead of sending them, for development and testing
+type FileMailer struct {
+	path string
+	from string
+	lock sync.Mutex
+}
+
+func NewFileMailer(mailConfig *config.MailConfig) *FileMailer {
+	return &FileMailer{path: mailConfig.FilePath, from: mailConfig.From}
+}
+
+func (mailer *FileMailer) Send(message *Message) error {
+	mailer.lock.Lock()
+	defer mailer.lock.Unlock()
+
+	file, err := os.OpenFile(mailer.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(message.Bytes(mailer.from), FileMailerSeparator...)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
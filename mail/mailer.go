@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/Wikia/helios/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+//Sends the emails of helios, e.g. password reset links
+type Mailer interface {
+	Send(message *Message) error
+}
+
+//Creates the mailer of the configured type or returns nil if sending emails is disabled
+func NewMailer(mailConfig *config.MailConfig) Mailer {
+	switch mailConfig.Type {
+	case config.MailTypeSmtp:
+		return NewSmtpMailer(mailConfig)
+	case config.MailTypeFile:
+		return NewFileMailer(mailConfig)
+	}
+	return nil
+}
+
+//Formats the message as a plain text email
+func (message *Message) Bytes(from string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(message.Body)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
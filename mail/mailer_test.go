@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Wikia/helios/config"
+)
+
+func TestNewMailer(t *testing.T) {
+	if NewMailer(&config.MailConfig{}) != nil {
+		t.Error("Mailer created although sending emails is disabled")
+	}
+	if _, isSmtp := NewMailer(&config.MailConfig{Type: config.MailTypeSmtp}).(*SmtpMailer); !isSmtp {
+		t.Error("SMTP mailer not created")
+	}
+	if _, isFile := NewMailer(&config.MailConfig{Type: config.MailTypeFile}).(*FileMailer); !isFile {
+		t.Error("File mailer not created")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helios")
+	if err != nil {
+		t.Fatal("Error creating temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.txt")
+	mailer := NewFileMailer(&config.MailConfig{FilePath: path, From: "helios@example.com"})
+	for _, subject := range []string{"First", "Second"} {
+		if err = mailer.Send(&Message{To: "user@example.com", Subject: subject, Body: "Hello"}); err != nil {
+			t.Fatal("Error sending email", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Error reading emails", err)
+	}
+	messages := strings.Split(strings.TrimSuffix(string(content), FileMailerSeparator), FileMailerSeparator)
+	if len(messages) != 2 {
+		t.Fatal("Unexpected number of emails", len(messages))
+	}
+	for _, header := range []string{"From: helios@example.com\r\n", "To: user@example.com\r\n", "Subject: Second\r\n"} {
+		if !strings.Contains(messages[1], header) {
+			t.Errorf("Header %q missing in %q", header, messages[1])
+		}
+	}
+	if !strings.HasSuffix(messages[1], "\r\n\r\nHello\r\n") {
+		t.Errorf("Unexpected body in %q", messages[1])
+	}
+}
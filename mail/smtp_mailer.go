@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+
+	"github.com/Wikia/helios/config"
+)
+
+type SmtpMailer struct {
+	address  string
+	from     string
+	username string
+	password string
+}
+
+func NewSmtpMailer(mailConfig *config.MailConfig) *SmtpMailer {
+	return &SmtpMailer{
+		address:  mailConfig.SmtpAddress,
+		from:     mailConfig.From,
+		username: mailConfig.SmtpUsername,
+		password: mailConfig.SmtpPassword,
+	}
+}
+
+func (mailer *SmtpMailer) Send(message *Message) error {
+	from, err := mail.ParseAddress(mailer.from)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if mailer.username != "" {
+		host, _, err := net.SplitHostPort(mailer.address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", mailer.username, mailer.password, host)
+	}
+
+	return smtp.SendMail(mailer.address, auth, from.Address, []string{message.To}, message.Bytes(mailer.from))
+}
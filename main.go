@@ -3,27 +3,75 @@ package main
 import (
 	"fmt"
 	"os"
-
-	"github.com/Wikia/helios/helios"
+	"strings"
 )
 
 const (
 	ConfigPath = "./config/config.ini"
 )
 
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{"serve", "[-config path]", "Starts the OAuth service (the default when no command is given)", runServe},
+		{"check-config", "[-config path]", "Validates the config and prints the effective settings", runCheckConfig},
+		{"create-client", "-id id -redirect-uri uri [-secret-stdin < secret] [-token-reuse-policy policy] [-max-sessions n] [-config path]",
+			"Creates or replaces a client, a random secret is generated unless -secret-stdin is given", runCreateClient},
+		{"list-clients", "[-config path]", "Lists the registered clients", runListClients},
+		{"hash-password", "< password", "Prints the user_password hash of the password read from stdin", runHashPassword},
+		{"revoke-user", "-user-id id [-config path]", "Removes the tokens of the given user", runRevokeUser},
+		{"ping", "[-config path]", "Reports the status of Redis and MySQL", runPing},
+	}
+}
+
+func printHelp() {
+	fmt.Printf("Helios OAuth service.\n\nUsage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Printf("  %-14s %s\n  %-14s   %s %s\n", command.Name, command.Description, "", command.Name, command.Usage)
+	}
+	fmt.Printf("\nIf no config path is provided the default %s will be used.\n", ConfigPath)
+}
+
+func findCommand(name string) *Command {
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
 func main() {
+	args := os.Args[1:]
+
+	if len(args) > 0 && (args[0] == "--help" || args[0] == "-h" || args[0] == "help") {
+		printHelp()
+		return
+	}
 
-	displayHelp := false
-	configFile := ConfigPath
-	if len(os.Args) == 2 && os.Args[1] == "--help" || len(os.Args) > 2 {
-		displayHelp = true
-	} else if len(os.Args) == 2 {
-		configFile = os.Args[1]
+	command := findCommand("serve")
+	if len(args) == 1 && !strings.HasPrefix(args[0], "-") && findCommand(args[0]) == nil {
+		//Backward compatibility - the config path given as the only argument
+		args = []string{"-config", args[0]}
+	} else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if command = findCommand(args[0]); command == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+			printHelp()
+			os.Exit(2)
+		}
+		args = args[1:]
 	}
-	if displayHelp {
-		fmt.Printf("Helios OAuth service. Can be started with one argument - path to config file.\n"+
-			"If no arguments are provided the default %s will be used.\n", configFile)
-	} else {
-		helios.NewHelios().Run(configFile)
+
+	if err := command.Run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", command.Name, err.Error())
+		os.Exit(1)
 	}
 }
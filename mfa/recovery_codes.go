@@ -0,0 +1,58 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	RecoveryCodeLength = 10
+	//Lowercase letters and digits without the ones easily confused, like 0 and o
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+//Generates the one-time recovery codes, formatted like "abcde-fghjk", and the hashes they are stored as
+func GenerateRecoveryCodes(count int) ([]string, []string, error) {
+	codes := make([]string, count)
+	hashes := make([]string, count)
+	for i := range codes {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:RecoveryCodeLength/2] + "-" + code[RecoveryCodeLength/2:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func randomRecoveryCode() (string, error) {
+	//Bytes above the highest multiple of the alphabet length are skipped, so all the characters are equally likely
+	limit := 256 - 256%len(recoveryCodeAlphabet)
+	code := make([]byte, 0, RecoveryCodeLength)
+	randomBytes := make([]byte, RecoveryCodeLength)
+	for len(code) < RecoveryCodeLength {
+		if _, err := rand.Read(randomBytes); err != nil {
+			return "", err
+		}
+		for _, randomByte := range randomBytes {
+			if int(randomByte) < limit && len(code) < RecoveryCodeLength {
+				code = append(code, recoveryCodeAlphabet[int(randomByte)%len(recoveryCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+//The codes are hashed without the separator and case insensitively, as users retype them
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
+
+func IsRecoveryCode(code string) bool {
+	return len(strings.Replace(strings.TrimSpace(code), "-", "", -1)) == RecoveryCodeLength
+}
@@ -0,0 +1,102 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//TOTP (RFC 6238) parameters supported by all the common authenticator apps
+const (
+	SecretLength = 20
+	Digits       = 6
+	PeriodInSec  = 30
+	//Number of periods before and after the current one whose codes are accepted, for clock drift
+	AllowedSkew = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//Generates a random secret encoded in base32, the way authenticator apps expect it
+func GenerateSecret() (string, error) {
+	secret := make([]byte, SecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return secretEncoding.DecodeString(strings.ToUpper(strings.Replace(secret, " ", "", -1)))
+}
+
+//Returns the counter of the period the time falls into
+func Counter(t time.Time) int64 {
+	return t.Unix() / PeriodInSec
+}
+
+//Returns the code for the period the time falls into
+func GenerateCode(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Counter(t), Digits), nil
+}
+
+//Checks the code against the periods around the time. Returns the counter of the matching period,
+//so that the caller can reject the code if it's presented again.
+func ValidateCode(secret string, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	counter := Counter(t)
+	for skew := int64(-AllowedSkew); skew <= AllowedSkew; skew++ {
+		expected := hotp(key, counter+skew, Digits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter + skew, true
+		}
+	}
+	return 0, false
+}
+
+//HOTP (RFC 4226) with HMAC-SHA1
+func hotp(key []byte, counter int64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+//Returns the otpauth:// URI authenticator apps can be set up with, usually shown as a QR code
+func KeyURI(issuer string, accountName string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", Digits))
+	query.Set("period", fmt.Sprintf("%d", PeriodInSec))
+
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
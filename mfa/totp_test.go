@@ -0,0 +1,120 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+//The SHA-1 test vectors of RFC 6238, appendix B
+func TestHotpRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unixTime int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		if code := hotp(key, Counter(time.Unix(test.unixTime, 0)), 8); code != test.expected {
+			t.Errorf("Code for %d is %s instead of %s", test.unixTime, code, test.expected)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	code, err := GenerateCode(secret, now)
+	if err != nil {
+		t.Fatal("Error generating code", err)
+	}
+	if code != "050471" {
+		t.Fatal("Unexpected code", code)
+	}
+
+	if counter, isValid := ValidateCode(secret, code, now); !isValid || counter != Counter(now) {
+		t.Fatal("Valid code rejected")
+	}
+	if _, isValid := ValidateCode(secret, code, now.Add(PeriodInSec*time.Second)); !isValid {
+		t.Fatal("Code from the previous period rejected")
+	}
+	if _, isValid := ValidateCode(secret, code, now.Add(3*PeriodInSec*time.Second)); isValid {
+		t.Fatal("Outdated code accepted")
+	}
+	if _, isValid := ValidateCode(secret, "123456", now); isValid {
+		t.Fatal("Invalid code accepted")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal("Error generating secret", err)
+	}
+	key, err := decodeSecret(secret)
+	if err != nil || len(key) != SecretLength {
+		t.Fatal("Invalid secret generated", secret)
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	uri := KeyURI("Fandom", "Test User", "ABCDEF")
+	if !strings.HasPrefix(uri, "otpauth://totp/Fandom:Test%20User?") {
+		t.Fatal("Unexpected URI", uri)
+	}
+	for _, param := range []string{"secret=ABCDEF", "issuer=Fandom", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("Parameter %s missing in %s", param, uri)
+		}
+	}
+}
+
+func TestCipher(t *testing.T) {
+	cipher, err := NewCipher(strings.Repeat("ab", EncryptionKeyLength))
+	if err != nil {
+		t.Fatal("Error creating cipher", err)
+	}
+
+	ciphertext, err := cipher.Encrypt("secret")
+	if err != nil {
+		t.Fatal("Error encrypting", err)
+	}
+	if plaintext, err := cipher.Decrypt(ciphertext); err != nil || plaintext != "secret" {
+		t.Fatal("Unexpected plaintext", plaintext, err)
+	}
+
+	otherCipher, _ := NewCipher(strings.Repeat("cd", EncryptionKeyLength))
+	if _, err = otherCipher.Decrypt(ciphertext); err == nil {
+		t.Fatal("Ciphertext decrypted with another key")
+	}
+	if _, err = NewCipher("abcd"); err == nil {
+		t.Fatal("Short key accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal("Error generating recovery codes", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !IsRecoveryCode(code) || seen[code] {
+			t.Fatal("Invalid or duplicate recovery code", code)
+		}
+		seen[code] = true
+		if HashRecoveryCode(strings.ToUpper(strings.Replace(code, "-", "", 1))) != hashes[i] {
+			t.Fatal("Recovery code hash depends on the case or separator", code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	//Format of the timestamps stored by MediaWiki
+	TimestampFormat = "20060102150405"
+	InfiniteExpiry  = "infinity"
+)
+
+const (
+	blockColumns = "ipb_id, ipb_address, ipb_user, ipb_by_text, ipb_reason, ipb_timestamp, ipb_expiry"
+)
+
+//Row of the MediaWiki ipblocks table, only the columns helios needs are mapped
+type Block struct {
+	Id        int64  `db:"ipb_id"`
+	Address   string `db:"ipb_address"`
+	UserId    int64  `db:"ipb_user"`
+	ByText    string `db:"ipb_by_text"`
+	Reason    string `db:"ipb_reason"`
+	Timestamp string `db:"ipb_timestamp"`
+	Expiry    string `db:"ipb_expiry"`
+}
+
+func (block *Block) IsInfinite() bool {
+	return block.Expiry == InfiniteExpiry
+}
+
+func (block *Block) IsExpired() bool {
+	if block.IsInfinite() {
+		return false
+	}
+	return block.Expiry <= FormatTimestamp(time.Now())
+}
+
+//Returns the expiry in RFC 3339 format or "infinity"
+func (block *Block) FormattedExpiry() string {
+	if block.IsInfinite() {
+		return InfiniteExpiry
+	}
+	expiry, err := time.Parse(TimestampFormat, block.Expiry)
+	if err != nil {
+		return block.Expiry
+	}
+	return expiry.Format(time.RFC3339)
+}
+
+func (block *Block) Description() string {
+	description := fmt.Sprintf("Blocked by %s until %s", block.ByText, block.FormattedExpiry())
+	if block.Reason != "" {
+		description += ": " + block.Reason
+	}
+	return description
+}
+
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+//Converts the IP to the hexadecimal form MediaWiki stores in ipb_range_start and ipb_range_end
+func IPToHex(ip string) (string, error) {
+	parsedIP := net.ParseIP(strings.TrimSpace(ip))
+	if parsedIP == nil {
+		return "", errors.New("Invalid IP address: " + ip)
+	}
+	if ipv4 := parsedIP.To4(); ipv4 != nil {
+		return strings.ToUpper(hex.EncodeToString(ipv4)), nil
+	}
+	return "v6-" + strings.ToUpper(hex.EncodeToString(parsedIP.To16())), nil
+}
+
+type BlockStorage struct {
+	dbmapSlave *gorp.DbMap
+}
+
+func NewBlockStorage(dbmapSlave *gorp.DbMap) *BlockStorage {
+	blockStorage := BlockStorage{dbmapSlave: dbmapSlave}
+	return &blockStorage
+}
+
+//Returns the active block of the user account or nil if there is none
+func (blockStorage *BlockStorage) FindUserBlock(userId int64) (*Block, error) {
+	return blockStorage.findBlock(
+		"select "+blockColumns+" from ipblocks where ipb_user=? and ipb_expiry>? order by ipb_expiry desc limit 1",
+		userId, FormatTimestamp(time.Now()))
+}
+
+//Returns the active block of the IP or of a range containing it, or nil if there is none.
+//Blocks applying to anonymous users only are skipped, as logging in requires an account.
+func (blockStorage *BlockStorage) FindIPBlock(ip string) (*Block, error) {
+	ipHex, err := IPToHex(ip)
+	if err != nil {
+		return nil, err
+	}
+	return blockStorage.findBlock(
+		"select "+blockColumns+" from ipblocks where ipb_user=0 and ipb_anon_only=0 "+
+			"and ipb_range_start<=? and ipb_range_end>=? and ipb_expiry>? order by ipb_expiry desc limit 1",
+		ipHex, ipHex, FormatTimestamp(time.Now()))
+}
+
+func (blockStorage *BlockStorage) findBlock(query string, args ...interface{}) (*Block, error) {
+	block := new(Block)
+	err := blockStorage.dbmapSlave.SelectOne(block, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return block, nil
+}
+
+//Caches the blocks of users and IPs for a short time, so checking them on every token request
+//doesn't hammer the MySQL slave
+type BlockChecker struct {
+	blockStorage *BlockStorage
+	cache        *ExpiringCache
+}
+
+func NewBlockChecker(blockStorage *BlockStorage, cacheTTL time.Duration) *BlockChecker {
+	return &BlockChecker{blockStorage: blockStorage, cache: NewExpiringCache(cacheTTL)}
+}
+
+func (checker *BlockChecker) GetUserBlock(userId int64) (*Block, error) {
+	return checker.getBlock("user:"+strconv.FormatInt(userId, 10), func() (*Block, error) {
+		return checker.blockStorage.FindUserBlock(userId)
+	})
+}
+
+func (checker *BlockChecker) GetIPBlock(ip string) (*Block, error) {
+	return checker.getBlock("ip:"+ip, func() (*Block, error) {
+		return checker.blockStorage.FindIPBlock(ip)
+	})
+}
+
+func (checker *BlockChecker) getBlock(key string, load func() (*Block, error)) (*Block, error) {
+	value, err := checker.cache.Get(key, func() (interface{}, error) {
+		return load()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block := value.(*Block)
+	//The block could have expired while it was cached
+	if block == nil || block.IsExpired() {
+		return nil, nil
+	}
+	return block, nil
+}
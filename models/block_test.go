@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPToHex(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"127.0.0.1", "7F000001"},
+		{"10.20.30.255", "0A141EFF"},
+		{" 192.168.0.1 ", "C0A80001"},
+		{"::ffff:127.0.0.1", "7F000001"},
+		{"2001:db8::1", "v6-20010DB8000000000000000000000001"},
+	}
+
+	for _, test := range tests {
+		ipHex, err := IPToHex(test.ip)
+		if err != nil {
+			t.Errorf("Error converting %q: %v", test.ip, err)
+		} else if ipHex != test.expected {
+			t.Errorf("Converted %q to %s instead of %s", test.ip, ipHex, test.expected)
+		}
+	}
+
+	if _, err := IPToHex("not-an-ip"); err == nil {
+		t.Error("Invalid IP converted without an error")
+	}
+}
+
+func TestBlockExpiry(t *testing.T) {
+	block := Block{Expiry: InfiniteExpiry}
+	if block.IsExpired() {
+		t.Error("Infinite block expired")
+	}
+
+	block.Expiry = FormatTimestamp(time.Now().Add(time.Hour))
+	if block.IsExpired() {
+		t.Error("Block expired before its expiry")
+	}
+
+	block.Expiry = FormatTimestamp(time.Now().Add(-time.Second))
+	if !block.IsExpired() {
+		t.Error("Block not expired after its expiry")
+	}
+
+	block.Expiry = "20300102030405"
+	if block.FormattedExpiry() != "2030-01-02T03:04:05Z" {
+		t.Error("Unexpected formatted expiry", block.FormattedExpiry())
+	}
+}
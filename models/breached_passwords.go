@@ -0,0 +1,66 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	//Length of the SHA-1 prefix the hashes are grouped by, like in the k-anonymity range API of Pwned Passwords
+	BreachedPasswordPrefixLength = 5
+)
+
+//SHA-1 hashes of breached passwords grouped by their prefix, so that a lookup only searches one small range
+type BreachedPasswords struct {
+	ranges map[string][]string
+}
+
+//Loads the file with one uppercase hex SHA-1 hash per line, optionally followed by ":count"
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breachedPasswords := &BreachedPasswords{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		hash := strings.ToUpper(strings.TrimSpace(strings.SplitN(scanner.Text(), ":", 2)[0]))
+		if hash == "" {
+			continue
+		}
+		if _, err = hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, lineNumber)
+		}
+		breachedPasswords.add(hash)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range breachedPasswords.ranges {
+		sort.Strings(suffixes)
+	}
+	return breachedPasswords, nil
+}
+
+func (breachedPasswords *BreachedPasswords) add(hash string) {
+	prefix := hash[:BreachedPasswordPrefixLength]
+	breachedPasswords.ranges[prefix] = append(breachedPasswords.ranges[prefix], hash[BreachedPasswordPrefixLength:])
+}
+
+func (breachedPasswords *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := breachedPasswords.ranges[hash[:BreachedPasswordPrefixLength]]
+	suffix := hash[BreachedPasswordPrefixLength:]
+	i := sort.SearchStrings(suffixes, suffix)
+	return i < len(suffixes) && suffixes[i] == suffix
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	DeviceCodeLength = 32
+	UserCodeLength   = 8
+	//Uppercase consonants only, as recommended by RFC 8628, so the codes are easy to type on a TV and don't spell words
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+//Creates the code the device polls for the tokens with
+func NewDeviceCode() (string, error) {
+	codeBytes := make([]byte, DeviceCodeLength/2)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(codeBytes), nil
+}
+
+//Creates the code the user enters on the verification page, formatted like "BCDF-GHJK"
+func NewUserCode() (string, error) {
+	//Bytes above the highest multiple of the alphabet length are skipped, so all the characters are equally likely
+	limit := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, UserCodeLength)
+	randomBytes := make([]byte, UserCodeLength)
+	for len(code) < UserCodeLength {
+		if _, err := rand.Read(randomBytes); err != nil {
+			return "", err
+		}
+		for _, randomByte := range randomBytes {
+			if int(randomByte) < limit && len(code) < UserCodeLength {
+				code = append(code, userCodeAlphabet[int(randomByte)%len(userCodeAlphabet)])
+			}
+		}
+	}
+	return string(code[:UserCodeLength/2]) + "-" + string(code[UserCodeLength/2:]), nil
+}
+
+//Users may type the code in lowercase, without the dash or with spaces
+func NormalizeUserCode(code string) string {
+	normalized := make([]rune, 0, UserCodeLength)
+	for _, char := range strings.ToUpper(code) {
+		if char != '-' && char != ' ' {
+			normalized = append(normalized, char)
+		}
+	}
+	return string(normalized)
+}
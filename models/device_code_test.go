@@ -0,0 +1,40 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewUserCode(t *testing.T) {
+	code, err := NewUserCode()
+	if err != nil {
+		t.Fatal("Error creating user code", err)
+	}
+	if len(code) != UserCodeLength+1 || code[UserCodeLength/2] != '-' {
+		t.Fatal("Unexpected user code format", code)
+	}
+	for _, char := range NormalizeUserCode(code) {
+		if !strings.ContainsRune(userCodeAlphabet, char) {
+			t.Fatal("Unexpected character in user code", code)
+		}
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for _, code := range []string{"BCDF-GHJK", "bcdf-ghjk", "BCDFGHJK", " bcdf ghjk "} {
+		if normalized := NormalizeUserCode(code); normalized != "BCDFGHJK" {
+			t.Errorf("User code %q normalized to %q", code, normalized)
+		}
+	}
+}
+
+func TestNewDeviceCode(t *testing.T) {
+	code, err := NewDeviceCode()
+	if err != nil {
+		t.Fatal("Error creating device code", err)
+	}
+	otherCode, _ := NewDeviceCode()
+	if len(code) != DeviceCodeLength || code == otherCode {
+		t.Fatal("Unexpected device codes", code, otherCode)
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	EmailTokenLength = 32
+)
+
+//The pattern of Sanitizer::validateEmail in MediaWiki
+var emailPattern = regexp.MustCompile("(?i)^[a-z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-z0-9-]+(\\.[a-z0-9-]+)*$")
+
+var InvalidEmailError = errors.New("The email address is not valid")
+
+func ValidateEmail(email string) error {
+	if !emailPattern.MatchString(email) {
+		return InvalidEmailError
+	}
+	return nil
+}
+
+//Creates a token for confirming the email address. The token is sent to the user,
+//user_email_token stores its hash the way MediaWiki does.
+func NewEmailToken() (string, string, error) {
+	tokenBytes := make([]byte, EmailTokenLength/2)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	return token, HashEmailToken(token), nil
+}
+
+func HashEmailToken(token string) string {
+	hash := md5.Sum([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+//Sets a new email confirmation token which can be used for the given time
+func (user *User) SetEmailToken(tokenHash string, expiration time.Duration) {
+	expires := FormatTimestamp(time.Now().Add(expiration))
+	user.EmailToken = &tokenHash
+	user.EmailTokenExpires = &expires
+}
+
+//Checks the email confirmation token and its expiry
+func (user *User) IsValidEmailToken(token string) bool {
+	if user.EmailToken == nil || *user.EmailToken == "" || user.EmailTokenExpires == nil {
+		return false
+	}
+	if *user.EmailTokenExpires <= FormatTimestamp(time.Now()) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(*user.EmailToken), []byte(HashEmailToken(token))) == 1
+}
+
+func (user *User) IsEmailConfirmed() bool {
+	return user.Email != "" && user.EmailAuthenticated != nil && *user.EmailAuthenticated != ""
+}
+
+//Marks the email address as confirmed and clears the token
+func (userStorage *UserStorage) ConfirmEmail(userId int64) error {
+	now := FormatTimestamp(time.Now())
+	return userStorage.updateUser(userId,
+		"user_email_authenticated=?, user_email_token=NULL, user_email_token_expires=NULL, user_touched=?", now, now)
+}
+
+//Caches whether the users have confirmed their email addresses, as it's reported by each /info request
+type EmailStatusChecker struct {
+	userStorage *UserStorage
+	cache       *ExpiringCache
+}
+
+func NewEmailStatusChecker(userStorage *UserStorage, cacheTTL time.Duration) *EmailStatusChecker {
+	return &EmailStatusChecker{userStorage: userStorage, cache: NewExpiringCache(cacheTTL)}
+}
+
+func (checker *EmailStatusChecker) IsEmailConfirmed(userId int64) (bool, error) {
+	isConfirmed, err := checker.cache.Get(strconv.FormatInt(userId, 10), func() (interface{}, error) {
+		user, err := checker.userStorage.FindById(userId)
+		if err != nil {
+			return false, err
+		}
+		return user != nil && user.IsEmailConfirmed(), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return isConfirmed.(bool), nil
+}
+
+func (checker *EmailStatusChecker) Invalidate(userId int64) {
+	checker.cache.Invalidate(strconv.FormatInt(userId, 10))
+}
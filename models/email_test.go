@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateEmail(t *testing.T) {
+	for _, email := range []string{"user@example.com", "first.last+tag@sub.example.co.uk", "USER@EXAMPLE.COM"} {
+		if ValidateEmail(email) != nil {
+			t.Errorf("Valid email %q rejected", email)
+		}
+	}
+	for _, email := range []string{"", "user", "user@", "@example.com", "user@exa mple.com", "User <user@example.com>"} {
+		if ValidateEmail(email) != InvalidEmailError {
+			t.Errorf("Invalid email %q accepted", email)
+		}
+	}
+}
+
+func TestNewEmailToken(t *testing.T) {
+	token, tokenHash, err := NewEmailToken()
+	if err != nil {
+		t.Fatal("Error creating email token", err)
+	}
+	if len(token) != EmailTokenLength || tokenHash != HashEmailToken(token) {
+		t.Fatal("Unexpected email token", token, tokenHash)
+	}
+
+	user := User{}
+	user.SetEmailToken(tokenHash, time.Hour)
+	if *user.EmailToken != tokenHash || *user.EmailTokenExpires <= FormatTimestamp(time.Now()) {
+		t.Fatal("Unexpected email token set", *user.EmailToken, *user.EmailTokenExpires)
+	}
+}
+
+func TestIsValidEmailToken(t *testing.T) {
+	token, tokenHash, _ := NewEmailToken()
+	user := User{}
+	if user.IsValidEmailToken(token) {
+		t.Fatal("Token accepted although none is set")
+	}
+
+	user.SetEmailToken(tokenHash, time.Hour)
+	if !user.IsValidEmailToken(token) {
+		t.Fatal("Valid token rejected")
+	}
+	if user.IsValidEmailToken("invalid") {
+		t.Fatal("Invalid token accepted")
+	}
+
+	user.SetEmailToken(tokenHash, -time.Minute)
+	if user.IsValidEmailToken(token) {
+		t.Fatal("Expired token accepted")
+	}
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+//In-process cache for values loaded from MySQL which may be slightly stale
+type ExpiringCache struct {
+	ttl       time.Duration
+	lock      sync.Mutex
+	entries   map[string]cacheEntry
+	lastSweep time.Time
+}
+
+func NewExpiringCache(ttl time.Duration) *ExpiringCache {
+	return &ExpiringCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+//Returns the cached value or loads and caches it if it's missing or expired. Errors are not cached.
+func (cache *ExpiringCache) Get(key string, load func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+
+	cache.lock.Lock()
+	entry, isCached := cache.entries[key]
+	cache.lock.Unlock()
+	if isCached && now.Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if now.Sub(cache.lastSweep) > cache.ttl {
+		cache.removeExpired(now)
+		cache.lastSweep = now
+	}
+	cache.entries[key] = cacheEntry{value: value, expiresAt: now.Add(cache.ttl)}
+	return value, nil
+}
+
+func (cache *ExpiringCache) Invalidate(key string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.entries, key)
+}
+
+func (cache *ExpiringCache) removeExpired(now time.Time) {
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(cache.entries, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Wikia/helios/config"
+)
+
+//Codes of the password policy violations, the clients use them to show localized messages
+const (
+	PasswordErrorTooShort        = "password_too_short"
+	PasswordErrorTooFewClasses   = "password_too_few_character_classes"
+	PasswordErrorMatchesUserName = "password_matches_username"
+	PasswordErrorMatchesEmail    = "password_matches_email"
+	PasswordErrorBreached        = "password_breached"
+)
+
+const (
+	//Passwords can't be empty even if no min length is configured
+	passwordMinLength           = 1
+	passwordCharacterClassCount = 4
+)
+
+type PasswordPolicyError struct {
+	Code    string
+	Message string
+	//Values the localized messages can refer to, e.g. the min length
+	Params map[string]interface{}
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+//Rules for the passwords set through helios
+type PasswordPolicy struct {
+	minLength           int
+	minCharacterClasses int
+	breachedPasswords   *BreachedPasswords
+}
+
+func NewPasswordPolicy(policyConfig *config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		minLength:           policyConfig.MinLength,
+		minCharacterClasses: policyConfig.MinCharacterClasses,
+	}
+	if policy.minLength < passwordMinLength {
+		policy.minLength = passwordMinLength
+	}
+
+	if policyConfig.BreachedPasswordsFile != "" {
+		breachedPasswords, err := LoadBreachedPasswords(policyConfig.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.breachedPasswords = breachedPasswords
+	}
+	return policy, nil
+}
+
+//Checks the new password of the user. Returns a *PasswordPolicyError describing the first rule it breaks.
+func (policy *PasswordPolicy) Validate(password string, userName string, email string) error {
+	if length := utf8.RuneCountInString(password); length < policy.minLength {
+		return &PasswordPolicyError{
+			Code:    PasswordErrorTooShort,
+			Message: fmt.Sprintf("The password must be at least %d characters long", policy.minLength),
+			Params:  map[string]interface{}{"min_length": policy.minLength},
+		}
+	}
+
+	if classes := countCharacterClasses(password); classes < policy.minCharacterClasses {
+		return &PasswordPolicyError{
+			Code: PasswordErrorTooFewClasses,
+			Message: fmt.Sprintf("The password must contain at least %d of: lowercase letters, uppercase letters, "+
+				"digits and other characters", policy.minCharacterClasses),
+			Params: map[string]interface{}{"min_character_classes": policy.minCharacterClasses},
+		}
+	}
+
+	if strings.EqualFold(password, userName) {
+		return &PasswordPolicyError{Code: PasswordErrorMatchesUserName,
+			Message: "The password can't be the same as the user name"}
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		return &PasswordPolicyError{Code: PasswordErrorMatchesEmail,
+			Message: "The password can't be the same as the email address"}
+	}
+
+	if policy.breachedPasswords != nil && policy.breachedPasswords.Contains(password) {
+		return &PasswordPolicyError{Code: PasswordErrorBreached,
+			Message: "The password has appeared in a data breach, choose a different one"}
+	}
+	return nil
+}
+
+func countCharacterClasses(password string) int {
+	var hasLower, hasUpper, hasDigit, hasOther bool
+	for _, char := range password {
+		switch {
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		default:
+			hasOther = true
+		}
+	}
+
+	classes := 0
+	for _, hasClass := range [passwordCharacterClassCount]bool{hasLower, hasUpper, hasDigit, hasOther} {
+		if hasClass {
+			classes++
+		}
+	}
+	return classes
+}
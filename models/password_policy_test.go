@@ -0,0 +1,100 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Wikia/helios/config"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeBreachedPasswords(passwords []string, t *testing.T) string {
+	file, err := ioutil.TempFile("", "helios")
+	if err != nil {
+		t.Fatal("Error creating temp file", err)
+	}
+	defer file.Close()
+
+	for _, password := range passwords {
+		if _, err = file.WriteString(sha1Hex(password) + ":42\n"); err != nil {
+			t.Fatal("Error writing temp file", err)
+		}
+	}
+	return file.Name()
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := writeBreachedPasswords([]string{"Password1", "Qwerty123"}, t)
+	defer os.Remove(path)
+
+	policy, err := NewPasswordPolicy(&config.PasswordPolicyConfig{
+		MinLength: 8, MinCharacterClasses: 2, BreachedPasswordsFile: path})
+	if err != nil {
+		t.Fatal("Error creating password policy", err)
+	}
+
+	tests := []struct {
+		password string
+		expected string
+	}{
+		{"correct horse battery", ""},
+		{"Tr0ub4dor&3", ""},
+		{"", PasswordErrorTooShort},
+		{"Short1", PasswordErrorTooShort},
+		{"ąęśćżźół", PasswordErrorTooFewClasses},
+		{"alllowercase", PasswordErrorTooFewClasses},
+		{"12345678", PasswordErrorTooFewClasses},
+		{"testuser1", PasswordErrorMatchesUserName},
+		{"test1@example.com", PasswordErrorMatchesEmail},
+		{"Password1", PasswordErrorBreached},
+		{"Qwerty123", PasswordErrorBreached},
+	}
+
+	for _, test := range tests {
+		err := policy.Validate(test.password, "TestUser1", "test1@example.com")
+		code := ""
+		if policyErr, isPolicyErr := err.(*PasswordPolicyError); isPolicyErr {
+			code = policyErr.Code
+		} else if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if code != test.expected {
+			t.Errorf("Validating %q returned %q instead of %q", test.password, code, test.expected)
+		}
+	}
+}
+
+func TestDefaultPasswordPolicy(t *testing.T) {
+	policy, err := NewPasswordPolicy(&config.PasswordPolicyConfig{})
+	if err != nil {
+		t.Fatal("Error creating password policy", err)
+	}
+	if policy.Validate("a", "Foo", "") != nil {
+		t.Fatal("Password rejected by the default policy")
+	}
+	if policy.Validate("", "Foo", "") == nil {
+		t.Fatal("Empty password accepted")
+	}
+}
+
+func TestLoadBreachedPasswordsInvalidHash(t *testing.T) {
+	file, err := ioutil.TempFile("", "helios")
+	if err != nil {
+		t.Fatal("Error creating temp file", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(sha1Hex("password") + "\nnot-a-hash\n")
+	file.Close()
+
+	if _, err = LoadBreachedPasswords(file.Name()); err == nil {
+		t.Fatal("Invalid hash accepted")
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/Wikia/helios/config"
 	"github.com/coopernurse/gorp"
@@ -9,10 +10,13 @@ import (
 )
 
 type StorageFactory struct {
-	dbmapMaster   *gorp.DbMap
-	dbmapSlave    *gorp.DbMap
-	userStorage   *UserStorage
-	storagePinger *StoragePinger
+	dbmapMaster        *gorp.DbMap
+	dbmapSlave         *gorp.DbMap
+	userStorage        *UserStorage
+	userStatusChecker  *UserStatusChecker
+	blockChecker       *BlockChecker
+	emailStatusChecker *EmailStatusChecker
+	storagePinger      *StoragePinger
 }
 
 func (storageFactory *StorageFactory) Close() {
@@ -31,12 +35,18 @@ func NewStorageFactory(dbConfig *config.DbConfig) *StorageFactory {
 	if err != nil {
 		panic(err)
 	}
-	storageFactory.dbmapMaster = &gorp.DbMap{Db: dbMaster, Dialect: gorp.MySQLDialect{dbConfig.Engine, dbConfig.Encoding}}
-	storageFactory.dbmapSlave = &gorp.DbMap{Db: dbSlave, Dialect: gorp.MySQLDialect{dbConfig.Engine, dbConfig.Encoding}}
+	storageFactory.dbmapMaster = &gorp.DbMap{Db: dbMaster, Dialect: gorp.MySQLDialect{Engine: dbConfig.Engine, Encoding: dbConfig.Encoding}}
+	storageFactory.dbmapSlave = &gorp.DbMap{Db: dbSlave, Dialect: gorp.MySQLDialect{Engine: dbConfig.Engine, Encoding: dbConfig.Encoding}}
 	storageFactory.dbmapMaster.AddTableWithName(User{}, dbConfig.UserTable).SetKeys(true, dbConfig.UserTableKey)
 	storageFactory.dbmapSlave.AddTableWithName(User{}, dbConfig.UserTable).SetKeys(true, dbConfig.UserTableKey)
 
-	storageFactory.userStorage = NewUserStorage(storageFactory.dbmapMaster, storageFactory.dbmapSlave)
+	storageFactory.userStorage = NewUserStorage(storageFactory.dbmapMaster, storageFactory.dbmapSlave, dbConfig.UserTable)
+	storageFactory.userStatusChecker = NewUserStatusChecker(storageFactory.userStorage.LoadUserStatus,
+		time.Duration(dbConfig.UserStatusCacheTTLInSec)*time.Second)
+	storageFactory.blockChecker = NewBlockChecker(NewBlockStorage(storageFactory.dbmapSlave),
+		time.Duration(dbConfig.UserStatusCacheTTLInSec)*time.Second)
+	storageFactory.emailStatusChecker = NewEmailStatusChecker(storageFactory.userStorage,
+		time.Duration(dbConfig.UserStatusCacheTTLInSec)*time.Second)
 	storageFactory.storagePinger = NewStoragePinger(storageFactory.dbmapMaster, storageFactory.dbmapSlave)
 
 	return storageFactory
@@ -46,6 +56,18 @@ func (storageFactory *StorageFactory) GetUserStorage() *UserStorage {
 	return storageFactory.userStorage
 }
 
+func (storageFactory *StorageFactory) GetUserStatusChecker() *UserStatusChecker {
+	return storageFactory.userStatusChecker
+}
+
+func (storageFactory *StorageFactory) GetBlockChecker() *BlockChecker {
+	return storageFactory.blockChecker
+}
+
+func (storageFactory *StorageFactory) GetEmailStatusChecker() *EmailStatusChecker {
+	return storageFactory.emailStatusChecker
+}
+
 func (storageFactory *StorageFactory) GetStoragePinger() *StoragePinger {
 	return storageFactory.storagePinger
 }
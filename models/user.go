@@ -2,6 +2,7 @@ package models
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -11,6 +12,9 @@ import (
 
 const (
 	HashPrefix = ":B:"
+	SaltLength = 8
+	//Length of user_token, which MediaWiki uses for the "remember me" cookies
+	UserTokenLength = 32
 )
 
 type User struct {
@@ -21,6 +25,7 @@ type User struct {
 	HashedPassword     string     `db:"user_password"`
 	NewPassword        string     `db:"user_newpassword"`
 	NewPassTime        *string    `db:"user_newpass_time"`
+	PasswordExpires    *string    `db:"user_password_expires"`
 	Email              string     `db:"user_email"`
 	Touched            string     `db:"user_touched"`
 	Token              string     `db:"user_token"`
@@ -33,19 +38,78 @@ type User struct {
 	Options            []byte     `db:"user_options"`
 }
 
+//Creates a user with the defaults MediaWiki sets for new accounts
+func NewUser(userName string, email string, password string) (*User, error) {
+	hash, err := NewPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+	tokenBytes := make([]byte, UserTokenLength/2)
+	if _, err = rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+
+	now := FormatTimestamp(time.Now())
+	editCount := int64(0)
+	user := User{
+		Name:           userName,
+		HashedPassword: hash,
+		Email:          email,
+		Touched:        now,
+		Token:          hex.EncodeToString(tokenBytes),
+		Registration:   &now,
+		EditCount:      &editCount,
+		Options:        []byte{},
+	}
+	return &user, nil
+}
+
+//MediaWiki sets user_password_expires to make the user choose a new password, the current one can't be
+//used once it has passed. NULL means the password doesn't expire.
+func (user *User) IsPasswordResetRequired() bool {
+	if user.PasswordExpires == nil || *user.PasswordExpires == "" {
+		return false
+	}
+	return *user.PasswordExpires <= FormatTimestamp(time.Now())
+}
+
 func (user *User) IsValidPassword(password string) bool {
-	if len(user.HashedPassword) < 3 {
+	return user.matchesHash(user.HashedPassword, password)
+}
+
+//Checks the temporary password or password reset token stored in user_newpassword
+func (user *User) IsValidNewPassword(password string) bool {
+	if user.NewPassword == "" {
+		return false
+	}
+	return user.matchesHash(user.NewPassword, password)
+}
+
+//The temporary password can only be used for the given time after it has been set
+func (user *User) IsNewPasswordExpired(expiration time.Duration) bool {
+	if user.NewPassTime == nil {
+		return true
+	}
+	newPassTime, err := time.Parse(TimestampFormat, *user.NewPassTime)
+	if err != nil {
+		return true
+	}
+	return time.Now().After(newPassTime.Add(expiration))
+}
+
+func (user *User) matchesHash(hash string, password string) bool {
+	if len(hash) < 3 {
 		log.Printf("To short hash for user: %s\n", user.Name)
 		return false
 	}
 
-	prefix := user.HashedPassword[0:3]
+	prefix := hash[0:3]
 	if prefix == HashPrefix {
-		salt, passHash := ExtractHashAndSalt(user.HashedPassword)
+		salt, passHash := ExtractHashAndSalt(hash)
 		return passHash == HashPassword(password, salt)
 	}
 
-	return user.HashedPassword == OldHashPassword(password, user.Id)
+	return hash == OldHashPassword(password, user.Id)
 }
 
 func ExtractHashAndSalt(hash string) (string, string) {
@@ -57,6 +121,17 @@ func ExtractHashAndSalt(hash string) (string, string) {
 	return splitedHash[0], splitedHash[1]
 }
 
+//Creates a salted hash in the format stored in user_password (:B:salt:hash)
+func NewPasswordHash(password string) (string, error) {
+	saltBytes := make([]byte, SaltLength/2)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return "", err
+	}
+	salt := hex.EncodeToString(saltBytes)
+
+	return HashPrefix + salt + ":" + HashPassword(password, salt), nil
+}
+
 func HashPassword(password string, salt string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(password))
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	UserStatusOk                    = iota
+	UserStatusDeleted               = iota
+	UserStatusDisabled              = iota
+	UserStatusPasswordResetRequired = iota
+)
+
+var userStatusDescriptions = map[int]string{
+	UserStatusOk:                    "The user account is active.",
+	UserStatusDeleted:               "The user account does not exist.",
+	UserStatusDisabled:              "The user account has been disabled.",
+	UserStatusPasswordResetRequired: "The password of the user account has expired, it has to be reset.",
+}
+
+func UserStatusDescription(status int) string {
+	return userStatusDescriptions[status]
+}
+
+//Checks whether users are still allowed to log in. The statuses are cached for a short time,
+//so refreshing tokens doesn't query the MySQL slave each time.
+type UserStatusChecker struct {
+	loadStatus func(userId int64) (int, error)
+	cache      *ExpiringCache
+}
+
+func NewUserStatusChecker(loadStatus func(userId int64) (int, error), cacheTTL time.Duration) *UserStatusChecker {
+	return &UserStatusChecker{loadStatus: loadStatus, cache: NewExpiringCache(cacheTTL)}
+}
+
+func (checker *UserStatusChecker) GetStatus(userId int64) (int, error) {
+	status, err := checker.cache.Get(strconv.FormatInt(userId, 10), func() (interface{}, error) {
+		return checker.loadStatus(userId)
+	})
+	if err != nil {
+		return UserStatusOk, err
+	}
+	return status.(int), nil
+}
+
+//Drops the cached status, e.g. after the user has been changed by helios itself
+func (checker *UserStatusChecker) Invalidate(userId int64) {
+	checker.cache.Invalidate(strconv.FormatInt(userId, 10))
+}
+
+func (userStorage *UserStorage) LoadUserStatus(userId int64) (int, error) {
+	user, err := userStorage.FindById(userId)
+	if err != nil {
+		return UserStatusOk, err
+	}
+	if user == nil {
+		return UserStatusDeleted, nil
+	}
+
+	isDisabled, err := userStorage.IsDisabled(userId)
+	if err != nil {
+		return UserStatusOk, err
+	}
+	return getUserStatus(user, isDisabled), nil
+}
+
+//Status of the existing user
+func getUserStatus(user *User, isDisabled bool) int {
+	switch {
+	case isDisabled:
+		return UserStatusDisabled
+	case user.IsPasswordResetRequired():
+		return UserStatusPasswordResetRequired
+	}
+	return UserStatusOk
+}
@@ -2,25 +2,48 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/Wikia/go-commons/logger"
 	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	MySQLDuplicateEntryError = 1062
+)
+
+var (
+	MultipleAccountsForEmailError = errors.New("Several accounts share the email address, log in with the username instead")
+	UserNameTakenError            = errors.New("The user name is already taken")
 )
 
 type UserStorage struct {
 	dbmapMaster *gorp.DbMap
 	dbmapSlave  *gorp.DbMap
+	userTable   string
 }
 
-func NewUserStorage(dbmapMaster *gorp.DbMap, dbmapSlave *gorp.DbMap) *UserStorage {
-	userStorage := UserStorage{dbmapMaster: dbmapMaster, dbmapSlave: dbmapSlave}
+func NewUserStorage(dbmapMaster *gorp.DbMap, dbmapSlave *gorp.DbMap, userTable string) *UserStorage {
+	userStorage := UserStorage{dbmapMaster: dbmapMaster, dbmapSlave: dbmapSlave, userTable: userTable}
 	return &userStorage
 }
 
+func (userStorage *UserStorage) quotedUserTable() string {
+	return userStorage.dbmapSlave.Dialect.QuotedTableForQuery("", userStorage.userTable)
+}
+
 func (userStorage *UserStorage) FindByName(userName string, mustExist bool) (*User, error) {
+	userName = NormalizeUserName(userName)
+	if userName == "" && !mustExist {
+		return nil, nil
+	}
 
 	user := new(User)
-	err := userStorage.dbmapSlave.SelectOne(&user, "select * from user where user_name=?", userName)
+	err := userStorage.dbmapSlave.SelectOne(&user,
+		"select * from "+userStorage.quotedUserTable()+" where user_name=?", userName)
 	if err != nil {
 		if err == sql.ErrNoRows && !mustExist {
 			return nil, nil
@@ -31,3 +54,125 @@ func (userStorage *UserStorage) FindByName(userName string, mustExist bool) (*Us
 	}
 	return user, nil
 }
+
+//Finds the user by a confirmed email address. Returns nil if no account has confirmed the address
+//and MultipleAccountsForEmailError if more than one has.
+func (userStorage *UserStorage) FindByEmail(email string) (*User, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, nil
+	}
+
+	var users []*User
+	_, err := userStorage.dbmapSlave.Select(&users,
+		"select * from "+userStorage.quotedUserTable()+
+			" where user_email=? and user_email_authenticated is not null limit 2", email)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+
+	switch len(users) {
+	case 0:
+		return nil, nil
+	case 1:
+		return users[0], nil
+	default:
+		return nil, MultipleAccountsForEmailError
+	}
+}
+
+//Finds the user by the name or, if there is no such user and the login looks like an email address,
+//by a confirmed email address
+func (userStorage *UserStorage) FindByLogin(login string) (*User, error) {
+	user, err := userStorage.FindByName(login, false)
+	if err != nil || user != nil || !strings.Contains(login, "@") {
+		return user, err
+	}
+	return userStorage.FindByEmail(login)
+}
+
+func (userStorage *UserStorage) FindById(userId int64) (*User, error) {
+	return userStorage.findById(userStorage.dbmapSlave, userId)
+}
+
+//Reads the user from the master, for checks which can't tolerate the replication lag
+func (userStorage *UserStorage) FindByIdOnMaster(userId int64) (*User, error) {
+	return userStorage.findById(userStorage.dbmapMaster, userId)
+}
+
+func (userStorage *UserStorage) findById(dbmap *gorp.DbMap, userId int64) (*User, error) {
+	user, err := dbmap.Get(User{}, userId)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+	return user.(*User), nil
+}
+
+//Accounts closed by their owners have the disabled user property set
+func (userStorage *UserStorage) IsDisabled(userId int64) (bool, error) {
+	count, err := userStorage.dbmapSlave.SelectInt(
+		"select count(*) from user_properties where up_user=? and up_property='disabled' and up_value='1'", userId)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+//Checks on the master, so that accounts which have just been created are taken into account
+func (userStorage *UserStorage) IsNameTaken(userName string) (bool, error) {
+	count, err := userStorage.dbmapMaster.SelectInt(
+		"select count(*) from "+userStorage.quotedUserTable()+" where user_name=?", userName)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+//Inserts the user through the master and sets its id. Returns UserNameTakenError if the name
+//has been taken in the meantime.
+func (userStorage *UserStorage) CreateUser(user *User) error {
+	err := userStorage.dbmapMaster.Insert(user)
+	if mysqlErr, isMySQLErr := err.(*mysql.MySQLError); isMySQLErr && mysqlErr.Number == MySQLDuplicateEntryError {
+		return UserNameTakenError
+	}
+	logger.GetLogger().ErrorErr(err)
+	return err
+}
+
+//Stores the hash of the temporary password or password reset token in user_newpassword
+func (userStorage *UserStorage) SetNewPassword(userId int64, newPassword string) error {
+	hash, err := NewPasswordHash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := FormatTimestamp(time.Now())
+	return userStorage.updateUser(userId,
+		"user_newpassword=?, user_newpass_time=?, user_touched=?", hash, now, now)
+}
+
+//Sets the password and clears the temporary one and the expiration of the old one
+func (userStorage *UserStorage) SetPassword(userId int64, password string) error {
+	hash, err := NewPasswordHash(password)
+	if err != nil {
+		return err
+	}
+
+	return userStorage.updateUser(userId,
+		"user_password=?, user_newpassword='', user_newpass_time=NULL, user_password_expires=NULL, user_touched=?",
+		hash, FormatTimestamp(time.Now()))
+}
+
+func (userStorage *UserStorage) updateUser(userId int64, assignments string, args ...interface{}) error {
+	_, err := userStorage.dbmapMaster.Exec(
+		"update "+userStorage.quotedUserTable()+" set "+assignments+" where user_id=?", append(args, userId)...)
+	logger.GetLogger().ErrorErr(err)
+	return err
+}
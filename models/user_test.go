@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 )
 
 const (
@@ -37,3 +38,149 @@ func TestOldHashPassword(t *testing.T) {
 		t.Fatal("Wrong hash generated. Expected:", UserOldPasswordHash, "Actual:", hash)
 	}
 }
+
+func TestNewPasswordHash(t *testing.T) {
+	hash, err := NewPasswordHash(UserPassword)
+	if err != nil {
+		t.Fatal("Error generating hash", err)
+	}
+	user := User{Id: UserID, HashedPassword: hash}
+	if !user.IsValidPassword(UserPassword) {
+		t.Fatal("Generated hash doesn't match the password. Hash:", hash)
+	}
+	if user.IsValidPassword("InvalidPassword") {
+		t.Fatal("Generated hash matches an invalid password. Hash:", hash)
+	}
+}
+
+func TestUserStatusCheckerCachesStatus(t *testing.T) {
+	loads := 0
+	status := UserStatusOk
+	checker := NewUserStatusChecker(func(userId int64) (int, error) {
+		loads++
+		return status, nil
+	}, time.Minute)
+
+	checker.GetStatus(UserID)
+	status = UserStatusDisabled
+	if cached, _ := checker.GetStatus(UserID); cached != UserStatusOk || loads != 1 {
+		t.Fatal("Status not cached. Status:", cached, "Loads:", loads)
+	}
+
+	checker.Invalidate(UserID)
+	if loaded, _ := checker.GetStatus(UserID); loaded != UserStatusDisabled || loads != 2 {
+		t.Fatal("Status not reloaded after invalidation. Status:", loaded, "Loads:", loads)
+	}
+}
+
+func TestUserStatusCheckerExpiresStatus(t *testing.T) {
+	loads := 0
+	checker := NewUserStatusChecker(func(userId int64) (int, error) {
+		loads++
+		return UserStatusOk, nil
+	}, 0)
+
+	checker.GetStatus(UserID)
+	checker.GetStatus(UserID)
+	if loads != 2 {
+		t.Fatal("Status cached despite zero TTL. Loads:", loads)
+	}
+}
+
+func TestIsPasswordResetRequired(t *testing.T) {
+	past := FormatTimestamp(time.Now().Add(-time.Hour))
+	future := FormatTimestamp(time.Now().Add(time.Hour))
+	empty := ""
+	tests := []struct {
+		name            string
+		passwordExpires *string
+		expected        bool
+	}{
+		{"password without expiration", nil, false},
+		{"empty expiration", &empty, false},
+		{"password expiring later", &future, false},
+		{"expired password", &past, true},
+	}
+
+	for _, test := range tests {
+		user := User{Id: UserID, HashedPassword: UserOldPasswordHash, PasswordExpires: test.passwordExpires}
+		if user.IsPasswordResetRequired() != test.expected {
+			t.Errorf("Wrong result for the %s. Expected: %t", test.name, test.expected)
+		}
+	}
+}
+
+func TestGetUserStatus(t *testing.T) {
+	expired := FormatTimestamp(time.Now().Add(-time.Hour))
+	tests := []struct {
+		name       string
+		user       *User
+		isDisabled bool
+		expected   int
+	}{
+		{"active user", &User{Id: UserID}, false, UserStatusOk},
+		{"disabled user", &User{Id: UserID}, true, UserStatusDisabled},
+		{"user with an expired password", &User{Id: UserID, PasswordExpires: &expired}, false, UserStatusPasswordResetRequired},
+		{"disabled user with an expired password", &User{Id: UserID, PasswordExpires: &expired}, true, UserStatusDisabled},
+	}
+
+	for _, test := range tests {
+		if status := getUserStatus(test.user, test.isDisabled); status != test.expected {
+			t.Errorf("Wrong status for the %s. Expected: %d Actual: %d", test.name, test.expected, status)
+		}
+	}
+}
+
+func TestUserStatusCheckerPasswordResetRequired(t *testing.T) {
+	expired := FormatTimestamp(time.Now().Add(-time.Hour))
+	user := &User{Id: UserID, PasswordExpires: &expired}
+	checker := NewUserStatusChecker(func(userId int64) (int, error) {
+		return getUserStatus(user, false), nil
+	}, time.Minute)
+
+	status, err := checker.GetStatus(UserID)
+	if err != nil || status != UserStatusPasswordResetRequired {
+		t.Fatal("Expired password not reported. Status:", status, "Error:", err)
+	}
+	if UserStatusDescription(status) == "" {
+		t.Fatal("No description of the status")
+	}
+
+	//Once the password has been reset, the checker is invalidated
+	user.PasswordExpires = nil
+	checker.Invalidate(UserID)
+	if status, _ = checker.GetStatus(UserID); status != UserStatusOk {
+		t.Fatal("Status not updated after the password reset. Status:", status)
+	}
+}
+
+func TestIsValidNewPassword(t *testing.T) {
+	user := User{Id: UserID, HashedPassword: HashPrefix + UserSalt + ":" + UserPasswordHash}
+	if user.IsValidNewPassword(UserPassword) {
+		t.Fatal("Password accepted as a new password which isn't set")
+	}
+
+	user.NewPassword = HashPrefix + UserSalt + ":" + UserPasswordHash
+	if !user.IsValidNewPassword(UserPassword) {
+		t.Fatal("Valid new password rejected")
+	}
+	if user.IsValidNewPassword("wrong") {
+		t.Fatal("Invalid new password accepted")
+	}
+}
+
+func TestIsNewPasswordExpired(t *testing.T) {
+	user := User{}
+	if !user.IsNewPasswordExpired(time.Hour) {
+		t.Fatal("New password without a time not expired")
+	}
+
+	newPassTime := FormatTimestamp(time.Now().Add(-time.Minute))
+	user.NewPassTime = &newPassTime
+	if user.IsNewPasswordExpired(time.Hour) {
+		t.Fatal("New password expired too early")
+	}
+	if !user.IsNewPasswordExpired(time.Second) {
+		t.Fatal("New password not expired")
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Characters MediaWiki treats as spaces in titles, they are stored as a single space in user_name
+var titleWhitespace = regexp.MustCompile(`[ _\x{00A0}\x{1680}\x{180E}\x{2000}-\x{200A}\x{2028}\x{2029}\x{202F}\x{205F}\x{3000}]+`)
+
+//Direction marks MediaWiki strips from titles
+var titleDirectionMarks = regexp.MustCompile(`[\x{200E}\x{200F}\x{202A}-\x{202E}]`)
+
+//Normalizes the user name the way MediaWiki does for titles in the User namespace, so that e.g.
+//"foo bar", "Foo_bar" and " foo__bar " all find the same row. Returns an empty string if nothing is left.
+func NormalizeUserName(userName string) string {
+	userName = norm.NFC.String(userName)
+	userName = titleDirectionMarks.ReplaceAllString(userName, "")
+	userName = titleWhitespace.ReplaceAllString(userName, " ")
+	userName = strings.TrimSpace(userName)
+	if userName == "" {
+		return ""
+	}
+
+	//Only the first letter is capitalized, the rest of the name is case sensitive
+	first, size := utf8.DecodeRuneInString(userName)
+	return string(unicode.ToUpper(first)) + userName[size:]
+}
+
+const (
+	//Longest name MediaWiki lets new accounts use, in bytes
+	MaxUserNameLength = 235
+)
+
+//Characters MediaWiki doesn't allow in the names of new accounts: the ones invalid in titles,
+//the subpage separator and $wgInvalidUsernameCharacters
+var invalidUserNameCharacters = regexp.MustCompile(`[#<>\[\]|{}/@:\x{0000}-\x{001F}\x{007F}\x{0080}-\x{009F}\x{E000}-\x{F8FF}\x{FFFD}]`)
+
+var (
+	EmptyUserNameError            = errors.New("The user name is empty")
+	UserNameTooLongError          = errors.New(fmt.Sprintf("The user name is longer than %d bytes", MaxUserNameLength))
+	UserNameIsIPError             = errors.New("The user name can't be an IP address")
+	UserNameInvalidCharacterError = errors.New("The user name contains characters which are not allowed: # < > [ ] | { } / @ :")
+)
+
+//Checks whether a new account can use the user name, which has to be normalized already
+func ValidateUserName(userName string) error {
+	switch {
+	case userName == "":
+		return EmptyUserNameError
+	case len(userName) > MaxUserNameLength:
+		return UserNameTooLongError
+	case net.ParseIP(userName) != nil:
+		return UserNameIsIPError
+	case invalidUserNameCharacters.MatchString(userName):
+		return UserNameInvalidCharacterError
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUserName(t *testing.T) {
+	tests := []struct {
+		userName string
+		expected string
+	}{
+		{"Foo bar", "Foo bar"},
+		{"foo bar", "Foo bar"},
+		{"Foo_bar", "Foo bar"},
+		{"foo_bar", "Foo bar"},
+		{"  foo bar  ", "Foo bar"},
+		{"__foo__bar__", "Foo bar"},
+		{"foo _ bar", "Foo bar"},
+		{"foo\u00a0bar", "Foo bar"},
+		{"foo\u3000bar", "Foo bar"},
+		{"fooBar", "FooBar"},
+		{"FOO bar", "FOO bar"},
+		{"\u200efoo bar\u200f", "Foo bar"},
+		{"\u00e9ric", "\u00c9ric"},
+		{"e\u0301ric", "\u00c9ric"},
+		{"\u0142ukasz", "\u0141ukasz"},
+		{"\u00dftra\u00dfe", "\u00dftra\u00dfe"},
+		{"1foo", "1foo"},
+		{"", ""},
+		{" _ ", ""},
+	}
+
+	for _, test := range tests {
+		if normalized := NormalizeUserName(test.userName); normalized != test.expected {
+			t.Errorf("Normalized %q to %q instead of %q", test.userName, normalized, test.expected)
+		}
+	}
+}
+
+func TestValidateUserName(t *testing.T) {
+	tests := []struct {
+		userName string
+		expected error
+	}{
+		{"Foo bar", nil},
+		{"Łukasz", nil},
+		{"Foo (bar)", nil},
+		{"", EmptyUserNameError},
+		{strings.Repeat("A", MaxUserNameLength), nil},
+		{strings.Repeat("A", MaxUserNameLength+1), UserNameTooLongError},
+		{"127.0.0.1", UserNameIsIPError},
+		{"2001:db8::1", UserNameIsIPError},
+		{"Foo#bar", UserNameInvalidCharacterError},
+		{"Foo/bar", UserNameInvalidCharacterError},
+		{"foo@example.com", UserNameInvalidCharacterError},
+		{"User:Foo", UserNameInvalidCharacterError},
+		{"Foo[bar]", UserNameInvalidCharacterError},
+		{"Foo\tbar", UserNameInvalidCharacterError},
+	}
+
+	for _, test := range tests {
+		if err := ValidateUserName(test.userName); err != test.expected {
+			t.Errorf("Validating %q returned %v instead of %v", test.userName, err, test.expected)
+		}
+	}
+}
@@ -7,6 +7,10 @@ then
 fi
 
 run_tests() {
+    godep go test $1 github.com/Wikia/helios/config
+    godep go test $1 github.com/Wikia/helios/jwt
+    godep go test $1 github.com/Wikia/helios/mail
+    godep go test $1 github.com/Wikia/helios/mfa
     godep go test $1 github.com/Wikia/helios/models
     godep go test $1 github.com/Wikia/helios/helios
 }
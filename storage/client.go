@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"github.com/Wikia/helios/jwt"
+)
+
+//Client stored in Redis. It's compatible with the JSON of osin.DefaultClient, so the clients
+//saved before the additional settings were introduced can still be loaded.
+type Client struct {
+	Id          string
+	Secret      string
+	RedirectUri string
+	UserData    interface{}
+
+	//Empty values mean that the server defaults are used
+	TokenReusePolicy string `json:",omitempty"`
+	MaxSessions      int    `json:",omitempty"`
+
+	//Audiences the client may exchange tokens for (RFC 8693), token exchange is denied if it's empty
+	TokenExchangeAudiences []string `json:",omitempty"`
+	//Whether the client may exchange tokens without an actor_token, getting tokens which don't record it as acting
+	TokenExchangeImpersonation bool `json:",omitempty"`
+
+	//PEM encoded public keys, the JWK set and the URL of the JWK set which the JWT bearer assertions and the
+	//private_key_jwt client assertions of the client are verified with, the client can't use them without keys
+	JwtPublicKeys string      `json:",omitempty"`
+	Jwks          *jwt.JWKSet `json:",omitempty"`
+	JwksUri       string      `json:",omitempty"`
+	//iss of the JWT bearer assertions, the client id is expected if it's empty
+	JwtIssuer string `json:",omitempty"`
+
+	//Metadata of the clients registered through /clients (RFC 7591). The client may use only the grant
+	//types listed, all of them if the list is empty.
+	ClientName              string   `json:",omitempty"`
+	GrantTypes              []string `json:",omitempty"`
+	TokenEndpointAuthMethod string   `json:",omitempty"`
+	//What the certificates of the clients authenticating with mutual TLS (RFC 8705) are checked against,
+	//the subject DN for tls_client_auth and the SHA-256 thumbprint for self_signed_tls_client_auth
+	TlsClientAuthSubjectDn         string `json:",omitempty"`
+	TlsClientCertificateThumbprint string `json:",omitempty"`
+	//Hash of the token the registration is managed with
+	RegistrationAccessTokenHash string `json:",omitempty"`
+	IssuedAt                    int64  `json:",omitempty"`
+}
+
+func (client *Client) GetId() string {
+	return client.Id
+}
+
+func (client *Client) GetSecret() string {
+	return client.Secret
+}
+
+func (client *Client) GetRedirectUri() string {
+	return client.RedirectUri
+}
+
+func (client *Client) GetUserData() interface{} {
+	return client.UserData
+}
+
+func (client *Client) IsTokenExchangeAudienceAllowed(audience string) bool {
+	for _, allowed := range client.TokenExchangeAudiences {
+		if allowed == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) GetJwtIssuer() string {
+	if client.JwtIssuer != "" {
+		return client.JwtIssuer
+	}
+	return client.Id
+}
+
+func (client *Client) IsGrantTypeAllowed(grantType string) bool {
+	if len(client.GrantTypes) == 0 {
+		return true
+	}
+	for _, allowed := range client.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
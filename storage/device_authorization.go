@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	DeviceCodePrefix       = "deviceCode."
+	UserCodePrefix         = "userCode."
+	DeviceCodePollPrefix   = "deviceCodePoll."
+	UserCodeAttemptsPrefix = "userCodeAttempts."
+)
+
+//Replaces the authorization with ARGV[1] for ARGV[2] seconds, unless the user has already approved or denied it.
+//Returns 1 if it has been replaced.
+var updatePendingDeviceAuthorizationScript = redis.NewScript(1, `
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+local authorization = cjson.decode(current)
+if authorization.UserId ~= "" or authorization.IsDenied then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+return 1
+`)
+
+//Device authorization grant (RFC 8628) waiting for the user to enter the user code
+type DeviceAuthorization struct {
+	ClientId string
+	Scope    string
+	//Normalized user code
+	UserCode string
+	//Set once the user has approved the device
+	UserId   string
+	IsDenied bool
+	//Min number of seconds between the polls of the device
+	Interval  int
+	ExpiresAt time.Time
+}
+
+//Returns false if the user code is in use by another device, a new one has to be generated then
+func (storage *RedisStorage) SaveDeviceAuthorization(deviceCode string, authorization *DeviceAuthorization) (bool, error) {
+	authorizationJSON, err := json.Marshal(authorization)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return false, err
+	}
+	expireInSec := authorization.expireInSec()
+
+	isSaved := false
+	err = storage.doWrite(func(db redis.Conn) error {
+		reply, err := db.Do("SET", storage.createUserCodeKey(authorization.UserCode), deviceCode, "EX", expireInSec, "NX")
+		if err != nil || reply == nil {
+			return err
+		}
+		isSaved = true
+		_, err = db.Do("SET", storage.createDeviceCodeKey(deviceCode), string(authorizationJSON), "EX", expireInSec)
+		return err
+	})
+	return isSaved, err
+}
+
+//Stores the changed authorization, keeping its expiration. Returns false if it has expired or the user
+//has approved or denied it in the meantime, so only the first of concurrent decisions is stored.
+func (storage *RedisStorage) UpdatePendingDeviceAuthorization(
+	deviceCode string, authorization *DeviceAuthorization) (bool, error) {
+
+	authorizationJSON, err := json.Marshal(authorization)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return false, err
+	}
+
+	isUpdated := false
+	err = storage.doWrite(func(db redis.Conn) error {
+		var err error
+		isUpdated, err = redis.Bool(updatePendingDeviceAuthorizationScript.Do(db, storage.createDeviceCodeKey(deviceCode),
+			string(authorizationJSON), authorization.expireInSec()))
+		return err
+	})
+	return isUpdated, err
+}
+
+func (storage *RedisStorage) LoadDeviceAuthorization(deviceCode string) (*DeviceAuthorization, error) {
+	authorizationJSON, err := storage.GetKey(storage.createDeviceCodeKey(deviceCode), false)
+	if err != nil || authorizationJSON == nil {
+		return nil, err
+	}
+
+	authorization := new(DeviceAuthorization)
+	if err = json.Unmarshal(authorizationJSON, authorization); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return authorization, nil
+}
+
+//Returns the device code the user code was issued with, or an empty string if it doesn't exist
+func (storage *RedisStorage) FindDeviceCode(userCode string) (string, error) {
+	deviceCode, err := storage.GetKey(storage.createUserCodeKey(userCode), false)
+	if err != nil {
+		return "", err
+	}
+	return string(deviceCode), nil
+}
+
+//Counts the user codes the user has entered and returns their number including this one
+func (storage *RedisStorage) AddUserCodeAttempt(userId string, expireInSec int) (int, error) {
+	return storage.addAttempt(storage.createUserCodeAttemptsKey(userId), expireInSec)
+}
+
+//Stops counting the attempt, once its user code has been accepted
+func (storage *RedisStorage) RemoveUserCodeAttempt(userId string) error {
+	return storage.removeAttempt(storage.createUserCodeAttemptsKey(userId))
+}
+
+//Removes the user code, so it can't be entered again once the user has decided
+func (storage *RedisStorage) DeleteUserCode(userCode string) error {
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("DEL", storage.createUserCodeKey(userCode))
+		return err
+	})
+}
+
+//Removes the authorization the user has decided on. Returns true only to the one of concurrent polls
+//which has removed it, only that one may get the tokens.
+func (storage *RedisStorage) ClaimDeviceAuthorization(deviceCode string, authorization *DeviceAuthorization) (bool, error) {
+	isClaimed := false
+	err := storage.doWrite(func(db redis.Conn) error {
+		removed, err := redis.Int(db.Do("DEL", storage.createDeviceCodeKey(deviceCode)))
+		if err != nil {
+			return err
+		}
+		isClaimed = removed == 1
+		_, err = db.Do("DEL", storage.createUserCodeKey(authorization.UserCode), storage.createDeviceCodePollKey(deviceCode))
+		return err
+	})
+	return isClaimed, err
+}
+
+//Records the poll of the device. Returns false if the device polled within the interval before.
+func (storage *RedisStorage) MarkDevicePolled(deviceCode string, intervalInSec int) (bool, error) {
+	isInTime := false
+	err := storage.doWrite(func(db redis.Conn) error {
+		reply, err := db.Do("SET", storage.createDeviceCodePollKey(deviceCode), 1, "EX", intervalInSec, "NX")
+		isInTime = reply != nil
+		return err
+	})
+	return isInTime, err
+}
+
+func (authorization *DeviceAuthorization) expireInSec() int {
+	expireInSec := int(authorization.ExpiresAt.Sub(time.Now()).Seconds())
+	if expireInSec < 1 {
+		return 1
+	}
+	return expireInSec
+}
+
+func (storage *RedisStorage) createDeviceCodeKey(deviceCode string) string {
+	return storage.prefix + DeviceCodePrefix + deviceCode
+}
+
+func (storage *RedisStorage) createUserCodeKey(userCode string) string {
+	return storage.prefix + UserCodePrefix + userCode
+}
+
+func (storage *RedisStorage) createDeviceCodePollKey(deviceCode string) string {
+	return storage.prefix + DeviceCodePollPrefix + deviceCode
+}
+
+func (storage *RedisStorage) createUserCodeAttemptsKey(userId string) string {
+	return storage.prefix + UserCodeAttemptsPrefix + userId
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	JwtIdPrefix = "jwtId."
+)
+
+//Records the jti of a JWT until it expires. Returns false if the JWT of the issuer has been used before.
+func (storage *RedisStorage) MarkJwtIdUsed(issuer string, jwtId string, expireInSec int) (bool, error) {
+	isFirstUse := false
+	err := storage.doWrite(func(db redis.Conn) error {
+		reply, err := db.Do("SET", storage.createJwtIdKey(issuer, jwtId), 1, "EX", expireInSec, "NX")
+		isFirstUse = reply != nil
+		return err
+	})
+	return isFirstUse, err
+}
+
+//Both the issuer and the jti are chosen by the partners, so they are hashed together to keep the key unambiguous
+func (storage *RedisStorage) createJwtIdKey(issuer string, jwtId string) string {
+	hash := sha256.Sum256([]byte(issuer + "\x00" + jwtId))
+	return storage.prefix + JwtIdPrefix + hex.EncodeToString(hash[:])
+}
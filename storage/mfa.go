@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	MfaPrefix              = "mfa."
+	MfaRecoveryCodesPrefix = "mfaRecoveryCodes."
+	MfaUsedCodePrefix      = "mfaUsedCode."
+	MfaTokenPrefix         = "mfaToken."
+	MfaTokenAttemptsPrefix = "mfaTokenAttempts."
+	MfaAttemptsPrefix      = "mfaAttempts."
+)
+
+//Decrements the counter unless it has expired in the meantime
+var decrementAttemptsScript = redis.NewScript(1, `
+local attempts = tonumber(redis.call("GET", KEYS[1]))
+if attempts and attempts > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+//TOTP enrollment of a user. It's stored without expiration, like the clients.
+type MfaEnrollment struct {
+	//The TOTP secret, encrypted
+	Secret string
+	//Set once the user has entered a valid code, only confirmed enrollments are required at login
+	IsConfirmed bool
+}
+
+//Password grant which is waiting for the second factor
+type MfaChallenge struct {
+	UserId   string
+	ClientId string
+}
+
+func (storage *RedisStorage) GetMfaEnrollment(userId string) (*MfaEnrollment, error) {
+	enrollmentJSON, err := storage.GetKey(storage.createMfaKey(userId), false)
+	if err != nil || enrollmentJSON == nil {
+		return nil, err
+	}
+
+	enrollment := new(MfaEnrollment)
+	if err = json.Unmarshal(enrollmentJSON, enrollment); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+func (storage *RedisStorage) SaveMfaEnrollment(userId string, enrollment *MfaEnrollment) error {
+	enrollmentJSON, err := json.Marshal(enrollment)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("SET", storage.createMfaKey(userId), string(enrollmentJSON))
+		return err
+	})
+}
+
+func (storage *RedisStorage) DeleteMfaEnrollment(userId string) error {
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("DEL", storage.createMfaKey(userId), storage.createMfaRecoveryCodesKey(userId))
+		return err
+	})
+}
+
+//Replaces the recovery codes of the user with the given hashes
+func (storage *RedisStorage) SetMfaRecoveryCodes(userId string, hashes []string) error {
+	key := storage.createMfaRecoveryCodesKey(userId)
+	args := []interface{}{key}
+	for _, hash := range hashes {
+		args = append(args, hash)
+	}
+
+	return storage.doWrite(func(db redis.Conn) error {
+		db.Send("MULTI")
+		db.Send("DEL", key)
+		db.Send("SADD", args...)
+		_, err := db.Do("EXEC")
+		return err
+	})
+}
+
+//Removes the recovery code, returns false if the user doesn't have it (anymore)
+func (storage *RedisStorage) UseMfaRecoveryCode(userId string, hash string) (bool, error) {
+	isUsed := false
+	err := storage.doWrite(func(db redis.Conn) error {
+		var err error
+		isUsed, err = redis.Bool(db.Do("SREM", storage.createMfaRecoveryCodesKey(userId), hash))
+		return err
+	})
+	return isUsed, err
+}
+
+//Records that the TOTP code of the period has been used. Returns false if it has been used before,
+//as each code may only be used once.
+func (storage *RedisStorage) MarkMfaCodeUsed(userId string, counter int64, expireInSec int) (bool, error) {
+	isFirstUse := false
+	err := storage.doWrite(func(db redis.Conn) error {
+		reply, err := db.Do("SET", storage.createMfaUsedCodeKey(userId, counter), 1, "EX", expireInSec, "NX")
+		isFirstUse = reply != nil
+		return err
+	})
+	return isFirstUse, err
+}
+
+func (storage *RedisStorage) SaveMfaChallenge(token string, challenge *MfaChallenge, expireInSec int) error {
+	challengeJSON, err := json.Marshal(challenge)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("SET", storage.createMfaTokenKey(token), string(challengeJSON), "EX", expireInSec)
+		return err
+	})
+}
+
+func (storage *RedisStorage) LoadMfaChallenge(token string) (*MfaChallenge, error) {
+	challengeJSON, err := storage.GetKey(storage.createMfaTokenKey(token), false)
+	if err != nil || challengeJSON == nil {
+		return nil, err
+	}
+
+	challenge := new(MfaChallenge)
+	if err = json.Unmarshal(challengeJSON, challenge); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return challenge, nil
+}
+
+//Counts the attempts to complete the challenge and returns their number including this one
+func (storage *RedisStorage) AddMfaChallengeAttempt(token string, expireInSec int) (int, error) {
+	return storage.addAttempt(storage.createMfaTokenAttemptsKey(token), expireInSec)
+}
+
+//Counts the codes the user has tried at login and returns their number including this one
+func (storage *RedisStorage) AddMfaAttempt(userId string, expireInSec int) (int, error) {
+	return storage.addAttempt(storage.createMfaAttemptsKey(userId), expireInSec)
+}
+
+//Stops counting the attempt, once its code has been accepted
+func (storage *RedisStorage) RemoveMfaAttempt(userId string) error {
+	return storage.removeAttempt(storage.createMfaAttemptsKey(userId))
+}
+
+func (storage *RedisStorage) DeleteMfaChallenge(token string) error {
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("DEL", storage.createMfaTokenKey(token), storage.createMfaTokenAttemptsKey(token))
+		return err
+	})
+}
+
+//Increments the counter of the attempts, which expires expireInSec after the last one, and returns
+//their number including this one
+func (storage *RedisStorage) addAttempt(key string, expireInSec int) (int, error) {
+	attempts := 0
+	err := storage.doWrite(func(db redis.Conn) error {
+		var err error
+		if attempts, err = redis.Int(db.Do("INCR", key)); err != nil {
+			return err
+		}
+		_, err = db.Do("EXPIRE", key, expireInSec)
+		return err
+	})
+	return attempts, err
+}
+
+func (storage *RedisStorage) removeAttempt(key string) error {
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := decrementAttemptsScript.Do(db, key)
+		return err
+	})
+}
+
+func (storage *RedisStorage) doWrite(write func(db redis.Conn) error) error {
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	err = write(db)
+	logger.GetLogger().ErrorErr(err)
+	return err
+}
+
+func (storage *RedisStorage) createMfaKey(userId string) string {
+	return storage.prefix + MfaPrefix + userId
+}
+
+func (storage *RedisStorage) createMfaRecoveryCodesKey(userId string) string {
+	return storage.prefix + MfaRecoveryCodesPrefix + userId
+}
+
+func (storage *RedisStorage) createMfaUsedCodeKey(userId string, counter int64) string {
+	return storage.prefix + MfaUsedCodePrefix + fmt.Sprintf("%s.%d", userId, counter)
+}
+
+func (storage *RedisStorage) createMfaTokenKey(token string) string {
+	return storage.prefix + MfaTokenPrefix + token
+}
+
+func (storage *RedisStorage) createMfaTokenAttemptsKey(token string) string {
+	return storage.prefix + MfaTokenAttemptsPrefix + token
+}
+
+func (storage *RedisStorage) createMfaAttemptsKey(userId string) string {
+	return storage.prefix + MfaAttemptsPrefix + userId
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/RangelReale/osin"
@@ -13,19 +16,65 @@ import (
 )
 
 const (
-	ClientPrefix          = "client."
-	AuthorizePrefix       = "authorization."
-	AccessPrefix          = "access."
-	RefreshPrefix         = "refresh."
-	UserIdAccessKeyPrefix = "userIdAccessKey."
+	ClientPrefix             = "client."
+	AuthorizePrefix          = "authorization."
+	AccessPrefix             = "access."
+	RefreshPrefix            = "refresh."
+	UserClientAccessPrefix   = "userClientAccess."
+	UserTokensPrefix         = "userTokens."
+	TokenFamilyPrefix        = "tokenFamily."
+	RefreshFamilyPrefix      = "refreshFamily."
+	RotatedRefreshPrefix     = "rotatedRefresh."
+	RevokedTokenFamilyPrefix = "revokedTokenFamily."
+)
+
+const (
+	UserTokenAccessPrefix  = "access:"
+	UserTokenRefreshPrefix = "refresh:"
+	UserTokenClientPrefix  = "client:"
+)
+
+//Adds the tokens (ARGV[2..]) to the user's set and extends the set's TTL to ARGV[1] seconds
+//unless it already lives longer
+var addUserTokensScript = redis.NewScript(1, `
+redis.call("SADD", KEYS[1], unpack(ARGV, 2))
+if redis.call("TTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+//Adds the access token ARGV[3] with the score ARGV[2] to the user's sorted set of the client's tokens
+//and extends the set's TTL to ARGV[1] seconds unless it already lives longer
+var addUserClientAccessScript = redis.NewScript(1, `
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+if redis.call("TTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+const (
+	PoolCloseGracePeriodSec = 10
+	ScanBatchSize           = 100
 )
 
 type RedisStorage struct {
-	masterPool                  *redis.Pool
-	slavePool                   *redis.Pool
-	forceUseSlave               bool
-	refreshTokenExpirationInSec int
-	prefix                      string
+	poolLock      sync.RWMutex
+	masterPool    *redis.Pool
+	slavePool     *redis.Pool
+	forceUseSlave bool
+	tokenSettings atomic.Value
+	prefix        string
+}
+
+//The token settings which can be changed by a config reload. They are replaced as a whole,
+//so a request never sees a mix of the old and the new values.
+type TokenSettings struct {
+	RefreshTokenExpirationInSec int
+	DefaultTokenReusePolicy     string
+	DefaultMaxSessions          int
+	RotateRefreshTokens         bool
 }
 
 type StorageDisabledError struct {
@@ -50,13 +99,14 @@ func NewRedisStorage(
 	if masterPool == nil && slavePool == nil {
 		panic(errors.New("Neither Redis master pool nor slave have been configured"))
 	}
-	return &RedisStorage{
-		masterPool:                  masterPool,
-		slavePool:                   slavePool,
-		refreshTokenExpirationInSec: serverConfig.RefreshTokenExpirationInSec,
-		forceUseSlave:               false,
-		prefix:                      generalConfig.Prefix,
+	storage := &RedisStorage{
+		masterPool:    masterPool,
+		slavePool:     slavePool,
+		forceUseSlave: false,
+		prefix:        generalConfig.Prefix,
 	}
+	storage.SetTokenSettings(serverConfig)
+	return storage
 }
 
 func newPool(config *config.RedisInstanceConfig) *redis.Pool {
@@ -69,6 +119,13 @@ func newPool(config *config.RedisInstanceConfig) *redis.Pool {
 				logger.GetLogger().ErrorErr(err)
 				return nil, err
 			}
+			if config.Password != "" {
+				if _, err = c.Do("AUTH", config.Password); err != nil {
+					logger.GetLogger().ErrorErr(err)
+					c.Close()
+					return nil, err
+				}
+			}
 			return c, err
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
@@ -79,14 +136,66 @@ func newPool(config *config.RedisInstanceConfig) *redis.Pool {
 }
 
 func (storage *RedisStorage) SetForceUseSlave(forceUseSlave bool) {
+	storage.poolLock.Lock()
+	defer storage.poolLock.Unlock()
+
 	storage.forceUseSlave = forceUseSlave
 }
 
+func (storage *RedisStorage) SetTokenSettings(serverConfig *config.ServerConfig) {
+	storage.tokenSettings.Store(&TokenSettings{
+		RefreshTokenExpirationInSec: serverConfig.RefreshTokenExpirationInSec,
+		DefaultTokenReusePolicy:     serverConfig.DefaultTokenReusePolicy,
+		DefaultMaxSessions:          serverConfig.DefaultMaxSessions,
+		RotateRefreshTokens:         serverConfig.RotateRefreshTokens,
+	})
+}
+
+func (storage *RedisStorage) GetTokenSettings() *TokenSettings {
+	return storage.tokenSettings.Load().(*TokenSettings)
+}
+
+//Returns the token reuse policy of the client, or the server default if the client doesn't set one
+func (storage *RedisStorage) GetTokenReusePolicy(client osin.Client) (string, int) {
+	if heliosClient, isHeliosClient := client.(*Client); isHeliosClient && heliosClient.TokenReusePolicy != "" {
+		return heliosClient.TokenReusePolicy, heliosClient.MaxSessions
+	}
+	settings := storage.GetTokenSettings()
+	return settings.DefaultTokenReusePolicy, settings.DefaultMaxSessions
+}
+
+//Replaces the pools of the configured instances with ones using the new settings. The old pools
+//are closed after a grace period, so requests which have just picked them can still finish.
+func (storage *RedisStorage) ReconfigurePools(
+	masterConfig *config.RedisInstanceConfig, slaveConfig *config.RedisInstanceConfig) {
+
+	storage.poolLock.Lock()
+	defer storage.poolLock.Unlock()
+
+	if storage.masterPool != nil {
+		closePoolLater(storage.masterPool)
+		storage.masterPool = newPool(masterConfig)
+	}
+	if storage.slavePool != nil {
+		closePoolLater(storage.slavePool)
+		storage.slavePool = newPool(slaveConfig)
+	}
+}
+
+func closePoolLater(pool *redis.Pool) {
+	time.AfterFunc(PoolCloseGracePeriodSec*time.Second, func() {
+		pool.Close()
+	})
+}
+
 //This is an inteface function called after each reponse has been handled. We do not
 //want to recreate the storage object for each request, so this function is empty
 func (storage *RedisStorage) Close() {}
 
 func (storage *RedisStorage) DoClose() {
+	storage.poolLock.Lock()
+	defer storage.poolLock.Unlock()
+
 	if storage.masterPool != nil {
 		storage.masterPool.Close()
 		storage.masterPool = nil
@@ -108,7 +217,7 @@ func (storage *RedisStorage) GetClient(id string) (osin.Client, error) {
 		return nil, err
 	}
 
-	client := new(osin.DefaultClient)
+	client := new(Client)
 	if err := json.Unmarshal(clientJSON, &client); err != nil {
 		logger.GetLogger().ErrorErr(err)
 		return nil, err
@@ -128,6 +237,27 @@ func (storage *RedisStorage) SetClient(id string, client osin.Client) error {
 	return storage.SetKey(key, clientJSON)
 }
 
+func (storage *RedisStorage) DeleteClient(id string) error {
+	return storage.DeleteKey(storage.createClientKey(id))
+}
+
+func (storage *RedisStorage) ListClients() ([]osin.Client, error) {
+	keys, err := storage.ScanKeys(storage.createClientKey("*"))
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]osin.Client, 0, len(keys))
+	for _, key := range keys {
+		client, err := storage.GetClient(key[len(storage.createClientKey("")):])
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
 func (storage *RedisStorage) SaveAuthorize(data *osin.AuthorizeData) error {
 	key := storage.createAuthorizeKey(data.Code)
 	dataJSON, err := json.Marshal(data)
@@ -168,14 +298,29 @@ func (storage *RedisStorage) SaveAccess(data *osin.AccessData) error {
 		return err
 	}
 
+	settings := storage.GetTokenSettings()
+	refreshExpireInSec := settings.RefreshTokenExpirationInSec
+	familyId := ""
+	if data.RefreshToken != "" && settings.RotateRefreshTokens {
+		if familyId, refreshExpireInSec, err = storage.addToTokenFamily(data); err != nil {
+			return err
+		}
+	}
+
 	err = storage.SetExpirableKey(key, dataJSON, int(data.ExpiresIn))
-	if data.RefreshToken != "" {
+	if err == nil && data.RefreshToken != "" {
 		key_refresh := storage.createRefreshKey(data.RefreshToken)
-		err = storage.SetExpirableKey(key_refresh, dataJSON, storage.refreshTokenExpirationInSec)
+		err = storage.SetExpirableKey(key_refresh, dataJSON, refreshExpireInSec)
+	}
+	if err == nil && familyId != "" {
+		err = storage.removeIfTokenFamilyRevoked(familyId, data)
 	}
 	if err == nil {
 		err = storage.SaveAccessTokenForUserId(data)
 	}
+	if err == nil {
+		err = storage.addUserTokens(data)
+	}
 	return err
 }
 
@@ -195,11 +340,23 @@ func (storage *RedisStorage) RemoveAccess(token string) error {
 }
 
 func (storage *RedisStorage) LoadRefresh(token string) (*osin.AccessData, error) {
-	key := storage.createRefreshKey(token)
-	refreshJSON, storeErr := storage.GetKey(key, true)
+	var refreshJSON []byte
+	var storeErr error
+	if storage.GetTokenSettings().RotateRefreshTokens {
+		//A rotated refresh token may be used only once
+		refreshJSON, storeErr = storage.claimRefresh(token)
+	} else {
+		refreshJSON, storeErr = storage.GetKey(storage.createRefreshKey(token), true)
+	}
 	if storeErr != nil {
 		return nil, storeErr
 	}
+	if refreshJSON == nil {
+		if err := storage.checkRefreshReuse(token); err != nil {
+			return nil, err
+		}
+		return nil, RefreshTokenNotFoundError
+	}
 
 	access, err := unmarshallAccess(refreshJSON)
 	if err != nil {
@@ -218,21 +375,273 @@ func (storage *RedisStorage) RemoveRefresh(token string) error {
 	return storage.DeleteKey(key)
 }
 
+//Adds the access token to the index of the user's tokens for the client. When the client's policy
+//caps the number of sessions, the oldest tokens above the cap are revoked.
 func (storage *RedisStorage) SaveAccessTokenForUserId(data *osin.AccessData) error {
-	key := storage.createUserIdAccessKey(data.UserData.(string))
-	return storage.SetExpirableKey(key, []byte(data.AccessToken), int(data.ExpiresIn))
+	userId, isUserToken := data.UserData.(string)
+	if !isUserToken || userId == "" || data.Client == nil {
+		return nil
+	}
+
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	key := storage.createUserClientAccessKey(userId, data.Client.GetId())
+	score := data.CreatedAt.UnixNano() / int64(time.Millisecond)
+	_, err = addUserClientAccessScript.Do(db, key, int(data.ExpiresIn), score, data.AccessToken)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+
+	policy, maxSessions := storage.GetTokenReusePolicy(data.Client)
+	if policy != config.TokenReusePolicyCap {
+		return nil
+	}
+
+	liveTokens, err := storage.getLiveUserClientAccessTokens(db, key)
+	if err != nil || len(liveTokens) <= maxSessions {
+		return err
+	}
+	for _, token := range liveTokens[:len(liveTokens)-maxSessions] {
+		if err = storage.evictAccess(db, key, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Returns the newest valid access token of the user for the given client or nil if there is none
+func (storage *RedisStorage) GetAccessForUserId(userId string, clientId string) (*osin.AccessData, error) {
+	pool, err := storage.getPoolForRead()
+	if err != nil {
+		return nil, err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	tokens, err := redis.Strings(db.Do("ZREVRANGE", storage.createUserClientAccessKey(userId, clientId), 0, -1))
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		accessData, err := storage.loadAccessIfExists(db, token)
+		if err != nil {
+			return nil, err
+		}
+		if accessData != nil && !accessData.IsExpired() {
+			return accessData, nil
+		}
+	}
+	return nil, nil
 }
 
-func (storage *RedisStorage) GetAccessForUserId(userId string) (*osin.AccessData, error) {
-	key := storage.createUserIdAccessKey(userId)
-	accessToken, err := storage.GetKey(key, false)
+//Returns the tokens from the index which still exist, the oldest first. The ones which don't are removed
+//from the index.
+func (storage *RedisStorage) getLiveUserClientAccessTokens(db redis.Conn, key string) ([]string, error) {
+	tokens, err := redis.Strings(db.Do("ZRANGE", key, 0, -1))
 	if err != nil {
+		logger.GetLogger().ErrorErr(err)
 		return nil, err
 	}
-	if accessToken == nil {
+
+	var liveTokens []string
+	for _, token := range tokens {
+		exists, err := redis.Bool(db.Do("EXISTS", storage.createAccessKey(token)))
+		if err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return nil, err
+		}
+		if exists {
+			liveTokens = append(liveTokens, token)
+		} else if _, err = db.Do("ZREM", key, token); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return nil, err
+		}
+	}
+	return liveTokens, nil
+}
+
+//Revokes the access token together with its refresh token and removes it from the index
+func (storage *RedisStorage) evictAccess(db redis.Conn, key string, token string) error {
+	accessData, err := storage.loadAccessIfExists(db, token)
+	if err != nil {
+		return err
+	}
+
+	keys := []interface{}{storage.createAccessKey(token)}
+	if accessData != nil && accessData.RefreshToken != "" {
+		keys = append(keys, storage.createRefreshKey(accessData.RefreshToken))
+	}
+	if _, err = db.Do("DEL", keys...); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+	_, err = db.Do("ZREM", key, token)
+	logger.GetLogger().ErrorErr(err)
+	return err
+}
+
+func (storage *RedisStorage) loadAccessIfExists(db redis.Conn, token string) (*osin.AccessData, error) {
+	accessJSON, err := redis.Bytes(db.Do("GET", storage.createAccessKey(token)))
+	if err == redis.ErrNil {
 		return nil, nil
 	}
-	return storage.LoadAccess(string(accessToken))
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return unmarshallAccess(accessJSON)
+}
+
+//Keeps track of all the access and refresh tokens of the user, so they can be revoked together
+func (storage *RedisStorage) addUserTokens(data *osin.AccessData) error {
+	userId, isUserToken := data.UserData.(string)
+	if !isUserToken || userId == "" {
+		return nil
+	}
+
+	expireInSec := int(data.ExpiresIn)
+	members := []interface{}{UserTokenAccessPrefix + data.AccessToken}
+	if data.Client != nil {
+		members = append(members, UserTokenClientPrefix+data.Client.GetId())
+	}
+	if data.RefreshToken != "" {
+		members = append(members, UserTokenRefreshPrefix+data.RefreshToken)
+		if refreshExpireInSec := storage.GetTokenSettings().RefreshTokenExpirationInSec; refreshExpireInSec > expireInSec {
+			expireInSec = refreshExpireInSec
+		}
+	}
+
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+	userTokensKey := storage.createUserTokensKey(userId)
+	//The set lives as long as the newest refresh token, so the expired and removed tokens are dropped here
+	deadMembers, err := storage.getDeadUserTokens(db, userId, userTokensKey)
+	if err != nil {
+		return err
+	}
+	if len(deadMembers) > 0 {
+		if _, err = db.Do("SREM", append([]interface{}{userTokensKey}, deadMembers...)...); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return err
+		}
+	}
+
+	args := append([]interface{}{userTokensKey, expireInSec}, members...)
+	_, err = addUserTokensScript.Do(db, args...)
+	logger.GetLogger().ErrorErr(err)
+	return err
+}
+
+//Returns the members of the user's set whose tokens, or client indexes, don't exist anymore
+func (storage *RedisStorage) getDeadUserTokens(db redis.Conn, userId string, userTokensKey string) ([]interface{}, error) {
+	members, err := redis.Strings(db.Do("SMEMBERS", userTokensKey))
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+
+	for _, member := range members {
+		db.Send("EXISTS", storage.createUserTokenMemberKey(userId, member))
+	}
+	if err = db.Flush(); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+
+	var deadMembers []interface{}
+	for _, member := range members {
+		exists, err := redis.Bool(db.Receive())
+		if err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return nil, err
+		}
+		if !exists {
+			deadMembers = append(deadMembers, member)
+		}
+	}
+	return deadMembers, nil
+}
+
+//Removes all the access and refresh tokens of the given user and returns the number of removed tokens
+func (storage *RedisStorage) RevokeUserTokens(userId string) (int, error) {
+	return storage.RevokeUserTokensExcept(userId, nil)
+}
+
+//Revokes all the tokens of the user apart from the given access token and its refresh token,
+//e.g. to keep the session in which the password has been changed. Returns the number of revoked tokens.
+func (storage *RedisStorage) RevokeUserTokensExcept(userId string, keep *osin.AccessData) (int, error) {
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return 0, err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	keptMembers := make(map[string]bool)
+	if keep != nil {
+		keptMembers[UserTokenAccessPrefix+keep.AccessToken] = true
+		if keep.RefreshToken != "" {
+			keptMembers[UserTokenRefreshPrefix+keep.RefreshToken] = true
+		}
+	}
+
+	userTokensKey := storage.createUserTokensKey(userId)
+	members, err := redis.Strings(db.Do("SMEMBERS", userTokensKey))
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return 0, err
+	}
+
+	indexKeys := []interface{}{userTokensKey}
+	revokedMembers := []interface{}{userTokensKey}
+	var tokenKeys []interface{}
+	for _, member := range members {
+		if keptMembers[member] {
+			continue
+		}
+		if strings.HasPrefix(member, UserTokenClientPrefix) {
+			indexKeys = append(indexKeys, storage.createUserTokenMemberKey(userId, member))
+			continue
+		}
+		tokenKeys = append(tokenKeys, storage.createUserTokenMemberKey(userId, member))
+		revokedMembers = append(revokedMembers, member)
+	}
+
+	//Tokens which have already expired are not counted
+	removed := 0
+	if len(tokenKeys) > 0 {
+		if removed, err = redis.Int(db.Do("DEL", tokenKeys...)); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return 0, err
+		}
+	}
+
+	//The indexes are needed for the kept token, the revoked ones are dropped from the client indexes when they're read
+	if len(keptMembers) > 0 {
+		if len(revokedMembers) > 1 {
+			_, err = db.Do("SREM", revokedMembers...)
+		}
+	} else {
+		_, err = db.Do("DEL", indexKeys...)
+	}
+	logger.GetLogger().ErrorErr(err)
+	return removed, err
 }
 
 func (storage *RedisStorage) GetKey(keyName string, mustExist bool) ([]byte, error) {
@@ -258,6 +667,39 @@ func (storage *RedisStorage) GetKey(keyName string, mustExist bool) ([]byte, err
 	return []byte(value), nil
 }
 
+//Returns the names of all the keys matching the given pattern. SCAN is used, so Redis isn't blocked
+//for the whole iteration.
+func (storage *RedisStorage) ScanKeys(pattern string) ([]string, error) {
+	pool, err := storage.getPoolForRead()
+	if err != nil {
+		return nil, err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(db.Do("SCAN", cursor, "MATCH", pattern, "COUNT", ScanBatchSize))
+		if err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
 func (storage *RedisStorage) SetKey(key string, value []byte) error {
 	pool, err := storage.getPoolForRead()
 	if err != nil {
@@ -298,6 +740,9 @@ func (storage *RedisStorage) DeleteKey(keyName string) error {
 }
 
 func (storage *RedisStorage) getPoolForWrite() (*redis.Pool, error) {
+	storage.poolLock.RLock()
+	defer storage.poolLock.RUnlock()
+
 	if storage.forceUseSlave {
 		err := errors.New("Use slave flag is on, cannot get redis pool for writing")
 		logger.GetLogger().ErrorErr(err)
@@ -314,6 +759,9 @@ func (storage *RedisStorage) getPoolForWrite() (*redis.Pool, error) {
 }
 
 func (storage *RedisStorage) getPoolForRead() (*redis.Pool, error) {
+	storage.poolLock.RLock()
+	defer storage.poolLock.RUnlock()
+
 	if storage.forceUseSlave && storage.slavePool == nil {
 		err := errors.New("Use slave flag is on, but slave pool has not been configured, cannot get redis pool for reading")
 		logger.GetLogger().ErrorErr(err)
@@ -328,11 +776,19 @@ func (storage *RedisStorage) getPoolForRead() (*redis.Pool, error) {
 }
 
 func (storage *RedisStorage) PingMaster() error {
-	return storage.Ping(storage.masterPool)
+	storage.poolLock.RLock()
+	pool := storage.masterPool
+	storage.poolLock.RUnlock()
+
+	return storage.Ping(pool)
 }
 
 func (storage *RedisStorage) PingSlave() error {
-	return storage.Ping(storage.slavePool)
+	storage.poolLock.RLock()
+	pool := storage.slavePool
+	storage.poolLock.RUnlock()
+
+	return storage.Ping(pool)
 }
 
 func (storage *RedisStorage) Ping(pool *redis.Pool) error {
@@ -363,15 +819,30 @@ func (storage *RedisStorage) createRefreshKey(token string) string {
 	return storage.prefix + RefreshPrefix + token
 }
 
-func (storage *RedisStorage) createUserIdAccessKey(userId string) string {
-	return storage.prefix + UserIdAccessKeyPrefix + userId
+func (storage *RedisStorage) createUserClientAccessKey(userId string, clientId string) string {
+	return storage.prefix + UserClientAccessPrefix + userId + "." + clientId
+}
+
+func (storage *RedisStorage) createUserTokensKey(userId string) string {
+	return storage.prefix + UserTokensPrefix + userId
+}
+
+//Returns the key of the token, or the client index, a member of the user's token set stands for
+func (storage *RedisStorage) createUserTokenMemberKey(userId string, member string) string {
+	switch {
+	case strings.HasPrefix(member, UserTokenClientPrefix):
+		return storage.createUserClientAccessKey(userId, member[len(UserTokenClientPrefix):])
+	case strings.HasPrefix(member, UserTokenRefreshPrefix):
+		return storage.createRefreshKey(member[len(UserTokenRefreshPrefix):])
+	}
+	return storage.createAccessKey(strings.TrimPrefix(member, UserTokenAccessPrefix))
 }
 
 func unmarshallAccess(JSON []byte) (*osin.AccessData, error) {
 	access := new(osin.AccessData)
-	access.Client = new(osin.DefaultClient)
+	access.Client = new(Client)
 	access.AccessData = new(osin.AccessData)
-	access.AccessData.Client = new(osin.DefaultClient)
+	access.AccessData.Client = new(Client)
 	err := json.Unmarshal(JSON, &access)
 	if err != nil {
 		logger.GetLogger().ErrorErr(err)
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/go-commons/logger"
+	"github.com/Wikia/helios/config"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	TestRedisAddress = "localhost:6379"
+	TestClientId     = "test-client"
+	TestUserId       = "1"
+)
+
+//Returns the storage using the Redis running at TestRedisAddress. Its keys are prefixed uniquely for the test
+//and removed once the test finishes.
+func newTestRedisStorage(serverConfig *config.ServerConfig, t *testing.T) *RedisStorage {
+	if testing.Short() {
+		t.Skip("Skipping Redis test in short mode.")
+	}
+	//Fails where there's no syslog, only the errors would be logged then
+	logger.InitLogger("helios", logger.LogLevelError)
+
+	storage := NewRedisStorage(&config.RedisGeneralConfig{Prefix: "test." + uuid.New() + "."},
+		&config.RedisInstanceConfig{UseThisInstance: true, Address: TestRedisAddress, MaxIdleConn: 10},
+		&config.RedisInstanceConfig{}, serverConfig)
+	t.Cleanup(func() {
+		keys, err := storage.ScanKeys(storage.prefix + "*")
+		if err != nil {
+			t.Error("Error removing the test keys", err)
+			return
+		}
+		for _, key := range keys {
+			storage.DeleteKey(key)
+		}
+	})
+	return storage
+}
+
+func newTestAccessData(userId string, clientId string) *osin.AccessData {
+	return &osin.AccessData{
+		Client:       &Client{Id: clientId},
+		AccessToken:  uuid.New(),
+		RefreshToken: uuid.New(),
+		ExpiresIn:    3600,
+		CreatedAt:    time.Now(),
+		UserData:     userId,
+	}
+}
+
+//Runs the command on the storage's Redis
+func doTestCommand(storage *RedisStorage, t *testing.T, command string, args ...interface{}) interface{} {
+	var reply interface{}
+	err := storage.doWrite(func(db redis.Conn) error {
+		var err error
+		reply, err = db.Do(command, args...)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error running %s: %s", command, err)
+	}
+	return reply
+}
+
+func isAccessStored(storage *RedisStorage, token string, t *testing.T) bool {
+	exists, _ := redis.Bool(doTestCommand(storage, t, "EXISTS", storage.createAccessKey(token)), nil)
+	return exists
+}
+
+func isRefreshStored(storage *RedisStorage, token string, t *testing.T) bool {
+	exists, _ := redis.Bool(doTestCommand(storage, t, "EXISTS", storage.createRefreshKey(token)), nil)
+	return exists
+}
+
+func saveTestAccess(storage *RedisStorage, data *osin.AccessData, t *testing.T) *osin.AccessData {
+	if err := storage.SaveAccess(data); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+	return data
+}
+
+func getUserTokenMembers(storage *RedisStorage, userId string, t *testing.T) []string {
+	members, err := redis.Strings(doTestCommand(storage, t, "SMEMBERS", storage.createUserTokensKey(userId)), nil)
+	if err != nil {
+		t.Fatal("Error reading the user's tokens", err)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func getSessionMembers(sessions ...*osin.AccessData) []string {
+	var members []string
+	for _, data := range sessions {
+		members = append(members, UserTokenAccessPrefix+data.AccessToken, UserTokenRefreshPrefix+data.RefreshToken)
+	}
+	return members
+}
+
+func TestAddUserTokensRemovesDeadTokens(t *testing.T) {
+	storage := newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600}, t)
+	expired := saveTestAccess(storage, newTestAccessData(TestUserId, TestClientId), t)
+	live := saveTestAccess(storage, newTestAccessData(TestUserId, TestClientId), t)
+	otherClient := saveTestAccess(storage, newTestAccessData(TestUserId, "other-client"), t)
+
+	//The tokens expire or are revoked without touching the user's set
+	storage.RemoveAccess(expired.AccessToken)
+	storage.RemoveRefresh(expired.RefreshToken)
+	storage.RemoveAccess(otherClient.AccessToken)
+	storage.RemoveRefresh(otherClient.RefreshToken)
+	storage.DeleteKey(storage.createUserClientAccessKey(TestUserId, "other-client"))
+
+	newest := saveTestAccess(storage, newTestAccessData(TestUserId, TestClientId), t)
+	expected := append(getSessionMembers(live, newest), UserTokenClientPrefix+TestClientId)
+	sort.Strings(expected)
+	if members := getUserTokenMembers(storage, TestUserId, t); !reflect.DeepEqual(members, expected) {
+		t.Errorf("Wrong tokens of the user. Expected: %v, got: %v", expected, members)
+	}
+
+	ttl, _ := redis.Int(doTestCommand(storage, t, "TTL", storage.createUserTokensKey(TestUserId)), nil)
+	if ttl <= 3500 || ttl > 3600 {
+		t.Errorf("The user's tokens don't live as long as the refresh token: %d", ttl)
+	}
+}
+
+func TestRevokeUserTokensExcept(t *testing.T) {
+	storage := newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600}, t)
+	first := saveTestAccess(storage, newTestAccessData(TestUserId, TestClientId), t)
+	kept := saveTestAccess(storage, newTestAccessData(TestUserId, TestClientId), t)
+	otherClient := saveTestAccess(storage, newTestAccessData(TestUserId, "other-client"), t)
+	otherUser := saveTestAccess(storage, newTestAccessData("2", TestClientId), t)
+
+	removed, err := storage.RevokeUserTokensExcept(TestUserId, kept)
+	if err != nil {
+		t.Fatal("Error revoking the tokens", err)
+	}
+	if removed != 4 {
+		t.Errorf("Wrong number of revoked tokens: %d", removed)
+	}
+	for _, data := range []*osin.AccessData{first, otherClient} {
+		if isAccessStored(storage, data.AccessToken, t) || isRefreshStored(storage, data.RefreshToken, t) {
+			t.Error("The tokens haven't been revoked")
+		}
+	}
+	for _, data := range []*osin.AccessData{kept, otherUser} {
+		if !isAccessStored(storage, data.AccessToken, t) || !isRefreshStored(storage, data.RefreshToken, t) {
+			t.Error("The tokens which should be kept have been revoked")
+		}
+	}
+
+	//The kept session stays in the indexes, so it can be revoked later
+	if removed, err = storage.RevokeUserTokens(TestUserId); err != nil {
+		t.Fatal("Error revoking the tokens", err)
+	}
+	if removed != 2 || isAccessStored(storage, kept.AccessToken, t) || isRefreshStored(storage, kept.RefreshToken, t) {
+		t.Errorf("The kept tokens haven't been revoked, %d revoked", removed)
+	}
+	for _, key := range []string{storage.createUserTokensKey(TestUserId),
+		storage.createUserClientAccessKey(TestUserId, TestClientId),
+		storage.createUserClientAccessKey(TestUserId, "other-client")} {
+
+		if exists, _ := redis.Bool(doTestCommand(storage, t, "EXISTS", key), nil); exists {
+			t.Errorf("The index %s hasn't been removed", key)
+		}
+	}
+}
+
+func TestCapEvictsOldestSessions(t *testing.T) {
+	tests := []struct {
+		maxSessions int
+		sessions    int
+		evicted     int
+	}{
+		{1, 3, 2},
+		{2, 3, 1},
+		{3, 3, 0},
+		{5, 2, 0},
+	}
+
+	for _, test := range tests {
+		storage := newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600}, t)
+		client := &Client{Id: TestClientId, TokenReusePolicy: config.TokenReusePolicyCap, MaxSessions: test.maxSessions}
+		createdAt := time.Now()
+		var sessions []*osin.AccessData
+		for i := 0; i < test.sessions; i++ {
+			data := newTestAccessData(TestUserId, TestClientId)
+			data.Client = client
+			data.CreatedAt = createdAt.Add(time.Duration(i) * time.Second)
+			sessions = append(sessions, saveTestAccess(storage, data, t))
+		}
+
+		for i, data := range sessions {
+			isEvicted := i < test.evicted
+			if isAccessStored(storage, data.AccessToken, t) == isEvicted ||
+				isRefreshStored(storage, data.RefreshToken, t) == isEvicted {
+
+				t.Errorf("Wrong state of session %d of %d with max %d sessions. Expected evicted: %t",
+					i+1, test.sessions, test.maxSessions, isEvicted)
+			}
+		}
+
+		var expected []string
+		for _, data := range sessions[test.evicted:] {
+			expected = append(expected, data.AccessToken)
+		}
+		index, _ := redis.Strings(doTestCommand(storage, t, "ZRANGE",
+			storage.createUserClientAccessKey(TestUserId, TestClientId), 0, -1), nil)
+		if !reflect.DeepEqual(index, expected) {
+			t.Errorf("Wrong index of the sessions with max %d sessions. Expected: %v, got: %v",
+				test.maxSessions, expected, index)
+		}
+	}
+}
+
+func TestCapIgnoresRevokedSessions(t *testing.T) {
+	storage := newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600}, t)
+	client := &Client{Id: TestClientId, TokenReusePolicy: config.TokenReusePolicyCap, MaxSessions: 2}
+	var sessions []*osin.AccessData
+	for i := 0; i < 2; i++ {
+		data := newTestAccessData(TestUserId, TestClientId)
+		data.Client = client
+		data.CreatedAt = data.CreatedAt.Add(time.Duration(i) * time.Second)
+		sessions = append(sessions, saveTestAccess(storage, data, t))
+	}
+
+	//The session revoked elsewhere doesn't count towards the cap
+	storage.RemoveAccess(sessions[1].AccessToken)
+	data := newTestAccessData(TestUserId, TestClientId)
+	data.Client = client
+	data.CreatedAt = data.CreatedAt.Add(2 * time.Second)
+	saveTestAccess(storage, data, t)
+
+	if !isAccessStored(storage, sessions[0].AccessToken, t) || !isAccessStored(storage, data.AccessToken, t) {
+		t.Error("A live session has been evicted")
+	}
+}
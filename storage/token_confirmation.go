@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	TokenConfirmationPrefix   = "tokenConfirmation."
+	RefreshConfirmationPrefix = "refreshConfirmation."
+)
+
+//Key an access or refresh token is bound to (the cnf claim), the token can be used only by the holder of the key.
+//It's stored next to the token as osin.AccessData has no place for it.
+type TokenConfirmation struct {
+	//SHA-256 thumbprint of the client certificate (RFC 8705)
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	//JWK thumbprint of the key the DPoP proofs are signed with (RFC 9449)
+	Jkt string `json:"jkt,omitempty"`
+}
+
+func (storage *RedisStorage) SaveTokenConfirmation(accessToken string, confirmation *TokenConfirmation, expireInSec int) error {
+	return storage.saveConfirmation(storage.createTokenConfirmationKey(accessToken), confirmation, expireInSec)
+}
+
+//Returns nil if the access token isn't bound to a key
+func (storage *RedisStorage) LoadTokenConfirmation(accessToken string) (*TokenConfirmation, error) {
+	return storage.loadConfirmation(storage.createTokenConfirmationKey(accessToken))
+}
+
+func (storage *RedisStorage) SaveRefreshConfirmation(refreshToken string, confirmation *TokenConfirmation, expireInSec int) error {
+	return storage.saveConfirmation(storage.createRefreshConfirmationKey(refreshToken), confirmation, expireInSec)
+}
+
+//Returns nil if the refresh token isn't bound to a key
+func (storage *RedisStorage) LoadRefreshConfirmation(refreshToken string) (*TokenConfirmation, error) {
+	return storage.loadConfirmation(storage.createRefreshConfirmationKey(refreshToken))
+}
+
+func (storage *RedisStorage) saveConfirmation(key string, confirmation *TokenConfirmation, expireInSec int) error {
+	confirmationJSON, err := json.Marshal(confirmation)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("SET", key, string(confirmationJSON), "EX", expireInSec)
+		return err
+	})
+}
+
+func (storage *RedisStorage) loadConfirmation(key string) (*TokenConfirmation, error) {
+	confirmationJSON, err := storage.GetKey(key, false)
+	if err != nil || confirmationJSON == nil {
+		return nil, err
+	}
+
+	confirmation := new(TokenConfirmation)
+	if err = json.Unmarshal(confirmationJSON, confirmation); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return confirmation, nil
+}
+
+func (storage *RedisStorage) createTokenConfirmationKey(accessToken string) string {
+	return storage.prefix + TokenConfirmationPrefix + accessToken
+}
+
+func (storage *RedisStorage) createRefreshConfirmationKey(refreshToken string) string {
+	return storage.prefix + RefreshConfirmationPrefix + refreshToken
+}
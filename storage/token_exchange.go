@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/Wikia/go-commons/logger"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	TokenExchangePrefix = "tokenExchange."
+)
+
+//Party acting on behalf of the user (RFC 8693 act claim). Act holds the parties which acted before it,
+//when a token issued by an exchange is exchanged again.
+type Actor struct {
+	Sub      string `json:"sub"`
+	ClientId string `json:"client_id"`
+	Act      *Actor `json:"act,omitempty"`
+}
+
+//How an access token issued by a token exchange may be used, stored next to the access token
+//as osin.AccessData has no place for it
+type TokenExchange struct {
+	Audience string
+	//Nil for impersonation
+	Act *Actor
+}
+
+func (storage *RedisStorage) SaveTokenExchange(accessToken string, exchange *TokenExchange, expireInSec int) error {
+	exchangeJSON, err := json.Marshal(exchange)
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return err
+	}
+
+	return storage.doWrite(func(db redis.Conn) error {
+		_, err := db.Do("SET", storage.createTokenExchangeKey(accessToken), string(exchangeJSON), "EX", expireInSec)
+		return err
+	})
+}
+
+//Returns nil if the access token wasn't issued by a token exchange
+func (storage *RedisStorage) LoadTokenExchange(accessToken string) (*TokenExchange, error) {
+	exchangeJSON, err := storage.GetKey(storage.createTokenExchangeKey(accessToken), false)
+	if err != nil || exchangeJSON == nil {
+		return nil, err
+	}
+
+	exchange := new(TokenExchange)
+	if err = json.Unmarshal(exchangeJSON, exchange); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return nil, err
+	}
+	return exchange, nil
+}
+
+func (storage *RedisStorage) createTokenExchangeKey(accessToken string) string {
+	return storage.prefix + TokenExchangePrefix + accessToken
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/go-commons/logger"
+	"github.com/garyburd/redigo/redis"
+)
+
+//A token family groups all the tokens issued from one login through refresh token rotation.
+//The family lives for refresh-token-expiration-in-sec from the login, rotated refresh tokens
+//don't extend it. Presenting a refresh token which has already been rotated out revokes the whole family.
+
+const (
+	TokenFamilyUserPrefix   = "user:"
+	TokenFamilyClientPrefix = "client:"
+)
+
+var (
+	RefreshTokenNotFoundError = errors.New("Refresh token not found")
+	RefreshTokenReusedError   = errors.New("Rotated out refresh token presented again, token family revoked")
+)
+
+//Removes the refresh token KEYS[1] and returns its data, or nil if it doesn't exist. While its family
+//(ARGV[1] followed by the family id KEYS[2] refers to) lives, the token is marked as rotated out in KEYS[3],
+//so its later use is recognized as a reuse.
+var claimRefreshScript = redis.NewScript(3, `
+local refresh = redis.call("GET", KEYS[1])
+if not refresh then
+	return false
+end
+redis.call("DEL", KEYS[1])
+local familyId = redis.call("GET", KEYS[2])
+if familyId then
+	local ttl = redis.call("TTL", ARGV[1] .. familyId)
+	if ttl > 0 then
+		redis.call("SET", KEYS[3], familyId, "EX", ttl)
+	end
+	redis.call("DEL", KEYS[2])
+end
+return refresh
+`)
+
+//Loads the refresh token and removes it in one step, so only one of concurrent refreshes gets it.
+//The others see it as rotated out. Returns nil if the token doesn't exist.
+func (storage *RedisStorage) claimRefresh(token string) ([]byte, error) {
+	var refreshJSON []byte
+	err := storage.doWrite(func(db redis.Conn) error {
+		var err error
+		refreshJSON, err = redis.Bytes(claimRefreshScript.Do(db, storage.createRefreshKey(token),
+			storage.createRefreshFamilyKey(token), storage.createRotatedRefreshKey(token), storage.prefix+TokenFamilyPrefix))
+		if err == redis.ErrNil {
+			return nil
+		}
+		return err
+	})
+	return refreshJSON, err
+}
+
+//Adds the tokens to the family of the refresh token they were issued from (or to a new family for a login)
+//and returns the family id and the number of seconds the family has left to live.
+//Returns RefreshTokenReusedError if the family has been revoked since the refresh token was claimed.
+func (storage *RedisStorage) addToTokenFamily(data *osin.AccessData) (string, int, error) {
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return "", 0, err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	//The refresh token itself is already in a family when an access token is reused
+	familyId, err := storage.getTokenFamilyId(db, storage.createRefreshFamilyKey(data.RefreshToken))
+	if err == nil && familyId == "" && data.AccessData != nil && data.AccessData.RefreshToken != "" {
+		familyId, err = storage.getTokenFamilyId(db, storage.createRotatedRefreshKey(data.AccessData.RefreshToken))
+		if err == nil && familyId != "" {
+			var isRevoked bool
+			if isRevoked, err = storage.isTokenFamilyRevoked(db, familyId); err == nil && isRevoked {
+				return "", 0, RefreshTokenReusedError
+			}
+		}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	expireInSec := 0
+	if familyId != "" {
+		if expireInSec, err = redis.Int(db.Do("TTL", storage.createTokenFamilyKey(familyId))); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return "", 0, err
+		}
+	}
+	if expireInSec <= 0 {
+		familyId = uuid.New()
+		expireInSec = storage.GetTokenSettings().RefreshTokenExpirationInSec
+	}
+
+	familyKey := storage.createTokenFamilyKey(familyId)
+	members := []interface{}{familyKey,
+		UserTokenAccessPrefix + data.AccessToken, UserTokenRefreshPrefix + data.RefreshToken}
+	if userId, isUserToken := data.UserData.(string); isUserToken {
+		members = append(members, TokenFamilyUserPrefix+userId)
+	}
+	if data.Client != nil {
+		members = append(members, TokenFamilyClientPrefix+data.Client.GetId())
+	}
+
+	db.Send("MULTI")
+	db.Send("SADD", members...)
+	db.Send("EXPIRE", familyKey, expireInSec)
+	db.Send("SET", storage.createRefreshFamilyKey(data.RefreshToken), familyId, "EX", expireInSec)
+	if _, err = db.Do("EXEC"); err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return "", 0, err
+	}
+	return familyId, expireInSec, nil
+}
+
+//Called once the tokens added to the family are stored. If the family has been revoked in the meantime,
+//its revocation might have missed them, so they are removed and RefreshTokenReusedError returned.
+func (storage *RedisStorage) removeIfTokenFamilyRevoked(familyId string, data *osin.AccessData) error {
+	return storage.doWrite(func(db redis.Conn) error {
+		isRevoked, err := storage.isTokenFamilyRevoked(db, familyId)
+		if err != nil || !isRevoked {
+			return err
+		}
+		if _, err = db.Do("DEL", storage.createAccessKey(data.AccessToken), storage.createRefreshKey(data.RefreshToken),
+			storage.createRefreshFamilyKey(data.RefreshToken)); err != nil {
+			return err
+		}
+		return RefreshTokenReusedError
+	})
+}
+
+func (storage *RedisStorage) isTokenFamilyRevoked(db redis.Conn, familyId string) (bool, error) {
+	isRevoked, err := redis.Bool(db.Do("EXISTS", storage.createRevokedTokenFamilyKey(familyId)))
+	logger.GetLogger().ErrorErr(err)
+	return isRevoked, err
+}
+
+//Checks whether the refresh token which wasn't found has been rotated out before.
+//If so, the whole family is revoked and RefreshTokenReusedError returned.
+func (storage *RedisStorage) checkRefreshReuse(token string) error {
+	pool, err := storage.getPoolForRead()
+	if err != nil {
+		return err
+	}
+
+	db := pool.Get()
+	familyId, err := storage.getTokenFamilyId(db, storage.createRotatedRefreshKey(token))
+	db.Close()
+	if err != nil || familyId == "" {
+		return err
+	}
+
+	userId, clientId, revoked, err := storage.RevokeTokenFamily(familyId)
+	if err != nil {
+		return err
+	}
+
+	logger.GetLogger().WarnMap(map[string]interface{}{
+		"@message":       "Security event: " + RefreshTokenReusedError.Error(),
+		"event":          "refresh_token_reuse",
+		"token_family":   familyId,
+		"user_id":        userId,
+		"client_id":      clientId,
+		"revoked_tokens": revoked,
+	})
+	return RefreshTokenReusedError
+}
+
+//Removes all the tokens of the family. Returns the user and client the family was issued to
+//and the number of removed tokens. The rotated out refresh tokens stay marked until the family would expire,
+//so a refresh which has claimed one of them concurrently finds the family revoked.
+func (storage *RedisStorage) RevokeTokenFamily(familyId string) (string, string, int, error) {
+	pool, err := storage.getPoolForWrite()
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	db := pool.Get()
+	defer db.Close()
+
+	//Marked as revoked first, so the tokens being added to the family concurrently are either revoked below
+	//or removed by their own request
+	familyKey := storage.createTokenFamilyKey(familyId)
+	expireInSec, err := redis.Int(db.Do("TTL", familyKey))
+	if err == nil && expireInSec > 0 {
+		_, err = db.Do("SET", storage.createRevokedTokenFamilyKey(familyId), 1, "EX", expireInSec)
+	}
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return "", "", 0, err
+	}
+
+	members, err := redis.Strings(db.Do("SMEMBERS", familyKey))
+	if err != nil {
+		logger.GetLogger().ErrorErr(err)
+		return "", "", 0, err
+	}
+
+	var userId, clientId string
+	var tokenKeys []interface{}
+	markerKeys := []interface{}{familyKey}
+	for _, member := range members {
+		switch {
+		case strings.HasPrefix(member, UserTokenAccessPrefix):
+			tokenKeys = append(tokenKeys, storage.createAccessKey(member[len(UserTokenAccessPrefix):]))
+		case strings.HasPrefix(member, UserTokenRefreshPrefix):
+			token := member[len(UserTokenRefreshPrefix):]
+			tokenKeys = append(tokenKeys, storage.createRefreshKey(token))
+			markerKeys = append(markerKeys, storage.createRefreshFamilyKey(token))
+		case strings.HasPrefix(member, TokenFamilyUserPrefix):
+			userId = member[len(TokenFamilyUserPrefix):]
+		case strings.HasPrefix(member, TokenFamilyClientPrefix):
+			clientId = member[len(TokenFamilyClientPrefix):]
+		}
+	}
+
+	removed := 0
+	if len(tokenKeys) > 0 {
+		if removed, err = redis.Int(db.Do("DEL", tokenKeys...)); err != nil {
+			logger.GetLogger().ErrorErr(err)
+			return "", "", 0, err
+		}
+	}
+
+	_, err = db.Do("DEL", markerKeys...)
+	logger.GetLogger().ErrorErr(err)
+	return userId, clientId, removed, err
+}
+
+func (storage *RedisStorage) getTokenFamilyId(db redis.Conn, key string) (string, error) {
+	familyId, err := redis.String(db.Do("GET", key))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	logger.GetLogger().ErrorErr(err)
+	return familyId, err
+}
+
+func (storage *RedisStorage) createTokenFamilyKey(familyId string) string {
+	return storage.prefix + TokenFamilyPrefix + familyId
+}
+
+func (storage *RedisStorage) createRevokedTokenFamilyKey(familyId string) string {
+	return storage.prefix + RevokedTokenFamilyPrefix + familyId
+}
+
+func (storage *RedisStorage) createRefreshFamilyKey(token string) string {
+	return storage.prefix + RefreshFamilyPrefix + token
+}
+
+func (storage *RedisStorage) createRotatedRefreshKey(token string) string {
+	return storage.prefix + RotatedRefreshPrefix + token
+}
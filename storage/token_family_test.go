@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/RangelReale/osin"
+	"github.com/Wikia/helios/config"
+	"github.com/garyburd/redigo/redis"
+)
+
+func newTestRotatingRedisStorage(t *testing.T) *RedisStorage {
+	return newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600, RotateRefreshTokens: true}, t)
+}
+
+//Stores the tokens issued from the loaded refresh token the way osin does
+func saveRefreshedAccess(storage *RedisStorage, previous *osin.AccessData, t *testing.T) (*osin.AccessData, error) {
+	data := newTestAccessData(TestUserId, TestClientId)
+	data.AccessData = previous
+	if err := storage.SaveAccess(data); err != nil {
+		return nil, err
+	}
+	if err := storage.RemoveRefresh(previous.RefreshToken); err != nil {
+		t.Fatal("Error removing the refresh token", err)
+	}
+	if err := storage.RemoveAccess(previous.AccessToken); err != nil {
+		t.Fatal("Error removing the access token", err)
+	}
+	return data, nil
+}
+
+func refreshTestAccess(storage *RedisStorage, refreshToken string, t *testing.T) *osin.AccessData {
+	previous, err := storage.LoadRefresh(refreshToken)
+	if err != nil {
+		t.Fatal("Error loading the refresh token", err)
+	}
+	data, err := saveRefreshedAccess(storage, previous, t)
+	if err != nil {
+		t.Fatal("Error saving the refreshed tokens", err)
+	}
+	return data
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	storage := newTestRotatingRedisStorage(t)
+	login := newTestAccessData(TestUserId, TestClientId)
+	if err := storage.SaveAccess(login); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+
+	refreshed := refreshTestAccess(storage, login.RefreshToken, t)
+	if isRefreshStored(storage, login.RefreshToken, t) || isAccessStored(storage, login.AccessToken, t) {
+		t.Error("The rotated out tokens haven't been removed")
+	}
+	if !isRefreshStored(storage, refreshed.RefreshToken, t) || !isAccessStored(storage, refreshed.AccessToken, t) {
+		t.Fatal("The refreshed tokens haven't been stored")
+	}
+
+	if _, err := storage.LoadRefresh(login.RefreshToken); err != RefreshTokenReusedError {
+		t.Errorf("Wrong error for the reused refresh token: %v", err)
+	}
+	if isRefreshStored(storage, refreshed.RefreshToken, t) || isAccessStored(storage, refreshed.AccessToken, t) {
+		t.Error("The token family hasn't been revoked")
+	}
+	if _, err := storage.LoadRefresh(refreshed.RefreshToken); err != RefreshTokenNotFoundError {
+		t.Errorf("Wrong error for the revoked refresh token: %v", err)
+	}
+	if _, err := storage.LoadRefresh(uuid.New()); err != RefreshTokenNotFoundError {
+		t.Errorf("Wrong error for the unknown refresh token: %v", err)
+	}
+}
+
+func TestConcurrentRefreshTokenClaims(t *testing.T) {
+	storage := newTestRotatingRedisStorage(t)
+	login := newTestAccessData(TestUserId, TestClientId)
+	if err := storage.SaveAccess(login); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+
+	const refreshes = 10
+	errs := make(chan error, refreshes)
+	var wg sync.WaitGroup
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := storage.LoadRefresh(login.RefreshToken)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	claimed := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			claimed++
+		case RefreshTokenReusedError:
+		default:
+			t.Error("Error loading the refresh token", err)
+		}
+	}
+	if claimed != 1 {
+		t.Errorf("The refresh token has been claimed %d times", claimed)
+	}
+}
+
+func TestRefreshCompletedAfterTokenFamilyRevoked(t *testing.T) {
+	storage := newTestRotatingRedisStorage(t)
+	login := newTestAccessData(TestUserId, TestClientId)
+	if err := storage.SaveAccess(login); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+
+	previous, err := storage.LoadRefresh(login.RefreshToken)
+	if err != nil {
+		t.Fatal("Error loading the refresh token", err)
+	}
+	//The refresh token is reused before the refresh which has claimed it stores its tokens
+	if _, err = storage.LoadRefresh(login.RefreshToken); err != RefreshTokenReusedError {
+		t.Fatalf("Wrong error for the reused refresh token: %v", err)
+	}
+
+	data := newTestAccessData(TestUserId, TestClientId)
+	data.AccessData = previous
+	if err = storage.SaveAccess(data); err != RefreshTokenReusedError {
+		t.Errorf("Wrong error for the tokens of the revoked family: %v", err)
+	}
+	if isRefreshStored(storage, data.RefreshToken, t) || isAccessStored(storage, data.AccessToken, t) {
+		t.Error("The tokens of the revoked family have been stored")
+	}
+}
+
+func TestTokenFamilyAbsoluteLifetime(t *testing.T) {
+	storage := newTestRotatingRedisStorage(t)
+	login := newTestAccessData(TestUserId, TestClientId)
+	if err := storage.SaveAccess(login); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+
+	familyId, err := redis.String(doTestCommand(storage, t, "GET", storage.createRefreshFamilyKey(login.RefreshToken)), nil)
+	if err != nil {
+		t.Fatal("The refresh token hasn't been added to a family", err)
+	}
+	familyKey := storage.createTokenFamilyKey(familyId)
+	refreshTtl, _ := redis.Int(doTestCommand(storage, t, "TTL", storage.createRefreshKey(login.RefreshToken)), nil)
+	if refreshTtl <= 3500 || refreshTtl > 3600 {
+		t.Errorf("Wrong TTL of the refresh token issued at login: %d", refreshTtl)
+	}
+
+	//As if the family was issued a while ago
+	doTestCommand(storage, t, "EXPIRE", familyKey, 100)
+	refreshed := refreshTestAccess(storage, login.RefreshToken, t)
+
+	refreshedFamilyId, _ := redis.String(doTestCommand(storage, t, "GET",
+		storage.createRefreshFamilyKey(refreshed.RefreshToken)), nil)
+	if refreshedFamilyId != familyId {
+		t.Errorf("The refreshed tokens have been added to another family: %s instead of %s", refreshedFamilyId, familyId)
+	}
+	familyTtl, _ := redis.Int(doTestCommand(storage, t, "TTL", familyKey), nil)
+	refreshTtl, _ = redis.Int(doTestCommand(storage, t, "TTL", storage.createRefreshKey(refreshed.RefreshToken)), nil)
+	if familyTtl > 100 || refreshTtl > 100 || refreshTtl <= 0 {
+		t.Errorf("Rotation has extended the family's lifetime, family TTL: %d, refresh token TTL: %d", familyTtl, refreshTtl)
+	}
+}
+
+func TestRefreshTokenWithoutRotation(t *testing.T) {
+	storage := newTestRedisStorage(&config.ServerConfig{RefreshTokenExpirationInSec: 3600}, t)
+	login := newTestAccessData(TestUserId, TestClientId)
+	if err := storage.SaveAccess(login); err != nil {
+		t.Fatal("Error saving the tokens", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := storage.LoadRefresh(login.RefreshToken); err != nil {
+			t.Fatal("Error loading the refresh token", err)
+		}
+	}
+}